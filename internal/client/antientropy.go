@@ -0,0 +1,216 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/vrnvu/go-dynamolike/internal/merkle"
+)
+
+const merkleDepth = 8
+
+var antiEntropyRepairs = expvar.NewMap("anti_entropy_repairs")
+
+// RangeStatus describes one key range in the last anti-entropy run.
+type RangeStatus struct {
+	Range     string   `json:"range"`
+	Replicas  []string `json:"replicas"`
+	Keys      int      `json:"keys"`
+	Divergent int      `json:"divergent"`
+	Repaired  int      `json:"repaired"`
+}
+
+type AntiEntropyStatus struct {
+	LastRun   time.Time     `json:"last_run"`
+	Duration  string        `json:"duration"`
+	Divergent int           `json:"divergent"`
+	Repaired  int           `json:"repaired"`
+	Error     string        `json:"error,omitempty"`
+	Ranges    []RangeStatus `json:"ranges"`
+}
+
+type antiEntropyState struct {
+	mu     sync.RWMutex
+	status AntiEntropyStatus
+}
+
+type keyRange struct {
+	replicas []*MinioNode
+	trees    map[*MinioNode]*merkle.Tree
+}
+
+// objectNameOf returns the object a stored key belongs to.
+func objectNameOf(key string) string {
+	rest, ok := strings.CutPrefix(key, siblingsPrefix)
+	if !ok {
+		return key
+	}
+	if i := strings.LastIndex(rest, "/"); i > 0 {
+		return rest[:i]
+	}
+	return rest
+}
+
+// AntiEntropyStatus returns the outcome of the last anti-entropy run.
+func (m *MinioGateway) AntiEntropyStatus() AntiEntropyStatus {
+	m.antiEntropy.mu.RLock()
+	defer m.antiEntropy.mu.RUnlock()
+	return m.antiEntropy.status
+}
+
+// RunAntiEntropy compares Merkle trees of the replicas of every key range
+// and copies the versions that differ to the replicas missing them.
+func (m *MinioGateway) RunAntiEntropy(ctx context.Context) error {
+	topo := m.topology()
+	healthy := m.healthySet(topo)
+	return m.scanNodes(ctx, topo, healthy, m.newAntiEntropyScan(topo, healthy))
+}
+
+// antiEntropyScan files each key stored on a node into the Merkle tree of
+// its key range on that node.
+type antiEntropyScan struct {
+	m       *MinioGateway
+	topo    *topology
+	healthy map[*MinioNode]bool
+	start   time.Time
+	ranges  map[string]*keyRange
+}
+
+func (m *MinioGateway) newAntiEntropyScan(topo *topology, healthy map[*MinioNode]bool) *antiEntropyScan {
+	return &antiEntropyScan{m: m, topo: topo, healthy: healthy, start: time.Now(), ranges: make(map[string]*keyRange)}
+}
+
+func (s *antiEntropyScan) visit(_ context.Context, node *MinioNode, object minio.ObjectInfo) {
+	if nodeLocal(object.Key) {
+		return
+	}
+	replicas, err := s.m.replicasOf(s.topo, objectNameOf(object.Key))
+	if err != nil || !slices.Contains(replicas, node) {
+		return
+	}
+	name := replicas[0].ID
+	kr, ok := s.ranges[name]
+	if !ok {
+		kr = &keyRange{trees: make(map[*MinioNode]*merkle.Tree)}
+		for _, replica := range replicas {
+			if s.healthy[replica] {
+				kr.replicas = append(kr.replicas, replica)
+				kr.trees[replica] = merkle.New(merkleDepth)
+			}
+		}
+		s.ranges[name] = kr
+	}
+	if tree, ok := kr.trees[node]; ok {
+		tree.Insert(object.Key, leafValue(object))
+	}
+}
+
+func (s *antiEntropyScan) finish(ctx context.Context, listErr error) error {
+	m := s.m
+	status := AntiEntropyStatus{LastRun: s.start}
+	for _, name := range sortedKeys(s.ranges) {
+		kr := s.ranges[name]
+		if len(kr.replicas) < 2 {
+			continue
+		}
+		rs := RangeStatus{Range: name}
+		for _, node := range kr.replicas {
+			rs.Replicas = append(rs.Replicas, node.ID)
+		}
+
+		divergent := m.divergentObjects(kr)
+		rs.Keys = kr.trees[kr.replicas[0]].Len()
+		rs.Divergent = len(divergent)
+		for _, objectName := range divergent {
+			rs.Repaired += m.syncObject(ctx, objectName, kr.replicas)
+		}
+
+		status.Divergent += rs.Divergent
+		status.Repaired += rs.Repaired
+		status.Ranges = append(status.Ranges, rs)
+	}
+	status.Duration = time.Since(s.start).String()
+	if listErr != nil {
+		status.Error = listErr.Error()
+	}
+
+	m.antiEntropy.mu.Lock()
+	m.antiEntropy.status = status
+	m.antiEntropy.mu.Unlock()
+
+	slog.Info("Anti-entropy run completed",
+		slog.Int("ranges", len(status.Ranges)),
+		slog.Int("divergent", status.Divergent),
+		slog.Int("repaired", status.Repaired),
+		slog.String("duration", status.Duration))
+	return nil
+}
+
+// leafValue is what the copies of a key are compared by, so a tombstone
+// differs from an empty value.
+func leafValue(object minio.ObjectInfo) string {
+	var b strings.Builder
+	b.WriteString(object.ETag)
+	for _, key := range sortedKeys(object.UserMetadata) {
+		if strings.HasPrefix(strings.ToLower(key), "x-amz-meta-") {
+			fmt.Fprintf(&b, "\n%s=%s", strings.ToLower(key), object.UserMetadata[key])
+		}
+	}
+	return b.String()
+}
+
+func (m *MinioGateway) healthyNodes(nodes []*MinioNode) []*MinioNode {
+	var healthy []*MinioNode
+	for _, node := range nodes {
+		if m.isHealthy(node) {
+			healthy = append(healthy, node)
+		}
+	}
+	return healthy
+}
+
+func (m *MinioGateway) divergentObjects(kr *keyRange) []string {
+	names := make(map[string]bool)
+	reference := kr.trees[kr.replicas[0]]
+	for _, replica := range kr.replicas[1:] {
+		for _, key := range merkle.Diff(reference, kr.trees[replica]) {
+			names[objectNameOf(key)] = true
+		}
+	}
+	return sortedKeys(names)
+}
+
+// syncObject reconciles the versions of objectName held by replicas and
+// writes the missing ones back. It returns the number of versions copied.
+func (m *MinioGateway) syncObject(ctx context.Context, objectName string, replicas []*MinioNode) int {
+	var replies []versionsReply
+	for _, node := range replicas {
+		versions, err := node.Versions(ctx, objectName)
+		if err != nil {
+			slog.Warn("Failed to read replica for anti-entropy",
+				slog.String("node_id", node.ID),
+				slog.String("object_name", objectName),
+				slog.String("error", err.Error()))
+			continue
+		}
+		replies = append(replies, versionsReply{node: node, versions: versions})
+	}
+	return m.repair(ctx, objectName, reconcile(collectVersions(replies)), replies, antiEntropyRepairs)
+}
+
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/vrnvu/go-dynamolike/internal/discovery"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestObjectNameOf(t *testing.T) {
+	assert.Equal(t, "key", objectNameOf("key"))
+	assert.Equal(t, "dir/key", objectNameOf("dir/key"))
+	assert.Equal(t, "dir/key", objectNameOf(siblingKey("dir/key", vclock.Clock{"a": 1})))
+}
+
+func TestSortedKeys(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", "c"}, sortedKeys(map[string]bool{"c": true, "a": true, "b": true}))
+	assert.Equal(t, []string{"a", "b"}, sortedKeys(map[string]*MinioNode{"b": nil, "a": nil}))
+}
+
+func TestRunAntiEntropySkipsRangesWithoutTwoHealthyReplicas(t *testing.T) {
+	a, aS3 := newFakeNode(t, "a")
+	b, bS3 := newFakeNode(t, "b")
+	gateway := newFakeGateway(a, b)
+	for _, s3 := range []*fakeS3{aS3, bS3} {
+		s3.put("key", []byte("v1"), VersionMeta{Clock: vclock.Clock{"g": 1}}, time.Now())
+	}
+	// Every node but the first one checked is down.
+	registry := new(mockRegistry)
+	registry.On("GetInstance", mock.Anything).Return(discovery.MinioInstance{Healthy: true}, nil).Once()
+	registry.On("GetInstance", mock.Anything).Return(discovery.MinioInstance{}, nil)
+	gateway.registry = registry
+
+	assert.NoError(t, gateway.RunAntiEntropy(context.Background()))
+	assert.Empty(t, gateway.AntiEntropyStatus().Ranges)
+}
+
+func TestRunAntiEntropyRepairsCopiesWithTheSameETag(t *testing.T) {
+	a, aS3 := newFakeNode(t, "a")
+	b, bS3 := newFakeNode(t, "b")
+	gateway := newFakeGateway(a, b)
+	// An empty value and the tombstone replacing it have the same body.
+	now := time.Now()
+	aS3.put("key", nil, VersionMeta{Clock: vclock.Clock{"g": 1}}, now)
+	bS3.put("key", nil, VersionMeta{Clock: vclock.Clock{"g": 2}, Tombstone: true}, now)
+
+	assert.NoError(t, gateway.RunAntiEntropy(context.Background()))
+
+	status := gateway.AntiEntropyStatus()
+	assert.Equal(t, 1, status.Divergent)
+	assert.Equal(t, 1, status.Repaired)
+	versions, err := a.Versions(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+	assert.True(t, versions[0].Tombstone, "the tombstone was copied over the value it deletes")
+}
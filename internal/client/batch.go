@@ -0,0 +1,217 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/vrnvu/go-dynamolike/internal/item"
+)
+
+const (
+	// MaxBatchGetKeys and MaxBatchWriteItems cap the keys of a batch, across
+	// all of its tables.
+	MaxBatchGetKeys    = 100
+	MaxBatchWriteItems = 25
+
+	batchParallelism = 8
+)
+
+// BatchGetRequest names the items to read from a table.
+type BatchGetRequest struct {
+	Keys       []item.Item
+	Projection string
+	Params     item.Params
+}
+
+// BatchGetResult holds the items found, by table. Keys that couldn't be
+// read are returned unprocessed, to be requested again.
+type BatchGetResult struct {
+	Responses   map[string][]item.Item
+	Unprocessed map[string]BatchGetRequest
+}
+
+type WriteRequest struct {
+	Put    item.Item
+	Delete item.Item
+}
+
+type batchKey struct {
+	table Table
+	name  string
+	key   item.Item
+	whole bool
+}
+
+func (m *MinioGateway) resolveBatch(ctx context.Context, keys []batchKey, limit int) error {
+	if len(keys) == 0 || len(keys) > limit {
+		return fmt.Errorf("%w: a batch has between 1 and %d keys, got %d", ErrInvalidArgument, limit, len(keys))
+	}
+	seen := make(map[string]bool)
+	for i := range keys {
+		key := &keys[i]
+		var err error
+		if key.table, err = m.DescribeTable(ctx, key.table.Name); err != nil {
+			return err
+		}
+		if key.whole {
+			key.name, err = itemName(key.table, key.key)
+		} else {
+			key.name, err = keyName(key.table, key.key)
+		}
+		if err != nil {
+			return err
+		}
+		if seen[key.table.key(key.name)] {
+			return fmt.Errorf("%w: item %s of table %s is in the batch twice", ErrInvalidArgument, key.name, key.table.Name)
+		}
+		seen[key.table.key(key.name)] = true
+	}
+	return nil
+}
+
+// fanOut calls do for each key, grouped by the node owning it, with at most
+// batchParallelism calls per node at once.
+func (m *MinioGateway) fanOut(keys []batchKey, do func(i int)) {
+	topo := m.topology()
+	groups := make(map[string][]int)
+	for i, key := range keys {
+		owner := topo.partitioner.Hash(placementKey(key.table.key(key.name)))
+		groups[owner] = append(groups[owner], i)
+	}
+
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		slots := make(chan struct{}, batchParallelism)
+		for _, i := range group {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				slots <- struct{}{}
+				defer func() { <-slots }()
+				do(i)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func retryable(err error) bool {
+	return errors.Is(err, ErrNodeUnavailable) || errors.Is(err, ErrConflict)
+}
+
+func batchError(keys []batchKey, errs []error) error {
+	for i, err := range errs {
+		if err != nil && !errors.Is(err, ErrNotFound) && !retryable(err) {
+			return fmt.Errorf("item %s of table %s: %w", keys[i].name, keys[i].table.Name, err)
+		}
+	}
+	return nil
+}
+
+func logUnprocessed(op string, key batchKey, err error) {
+	slog.Warn("Batch key unprocessed",
+		slog.String("op", op),
+		slog.String("table", key.table.Name),
+		slog.String("object_name", key.name),
+		slog.String("error", err.Error()))
+}
+
+// BatchGetItem reads the items of several tables at once. Items that don't
+// exist are left out of the result.
+func (m *MinioGateway) BatchGetItem(ctx context.Context, requests map[string]BatchGetRequest, consistency Consistency) (BatchGetResult, error) {
+	var keys []batchKey
+	for _, table := range sortedKeys(requests) {
+		request := requests[table]
+		if _, err := item.ParseProjection(request.Projection, request.Params); err != nil {
+			return BatchGetResult{}, fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+		}
+		for _, key := range request.Keys {
+			keys = append(keys, batchKey{table: Table{Name: table}, key: key})
+		}
+	}
+	if err := m.resolveBatch(ctx, keys, MaxBatchGetKeys); err != nil {
+		return BatchGetResult{}, err
+	}
+
+	items := make([]item.Item, len(keys))
+	errs := make([]error, len(keys))
+	m.fanOut(keys, func(i int) {
+		request := requests[keys[i].table.Name]
+		items[i], errs[i] = m.GetItem(ctx, keys[i].table.Name, keys[i].key, GetItemOptions{
+			Consistency: consistency,
+			Projection:  request.Projection,
+			Params:      request.Params,
+		})
+	})
+
+	if err := batchError(keys, errs); err != nil {
+		return BatchGetResult{}, err
+	}
+	result := BatchGetResult{Responses: make(map[string][]item.Item), Unprocessed: make(map[string]BatchGetRequest)}
+	for i, key := range keys {
+		table := key.table.Name
+		switch {
+		case errors.Is(errs[i], ErrNotFound):
+		case errs[i] != nil:
+			logUnprocessed("get", key, errs[i])
+			unprocessed, ok := result.Unprocessed[table]
+			if !ok {
+				unprocessed = BatchGetRequest{Projection: requests[table].Projection, Params: requests[table].Params}
+			}
+			unprocessed.Keys = append(unprocessed.Keys, key.key)
+			result.Unprocessed[table] = unprocessed
+		default:
+			result.Responses[table] = append(result.Responses[table], items[i])
+		}
+	}
+	return result, nil
+}
+
+// BatchWriteItem puts and deletes items of several tables at once. Writes
+// that failed because a node was unavailable or the item was in conflict are
+// returned, to be sent again.
+func (m *MinioGateway) BatchWriteItem(ctx context.Context, requests map[string][]WriteRequest, consistency Consistency) (map[string][]WriteRequest, error) {
+	var keys []batchKey
+	var writes []WriteRequest
+	for _, table := range sortedKeys(requests) {
+		for _, request := range requests[table] {
+			key := batchKey{table: Table{Name: table}, key: request.Delete}
+			switch {
+			case (request.Put == nil) == (request.Delete == nil):
+				return nil, fmt.Errorf("%w: a write request of table %s must either put or delete", ErrInvalidArgument, table)
+			case request.Put != nil:
+				key.key, key.whole = request.Put, true
+			}
+			keys = append(keys, key)
+			writes = append(writes, request)
+		}
+	}
+	if err := m.resolveBatch(ctx, keys, MaxBatchWriteItems); err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(keys))
+	m.fanOut(keys, func(i int) {
+		opts := WriteItemOptions{Consistency: consistency}
+		if keys[i].whole {
+			errs[i] = m.PutItem(ctx, keys[i].table.Name, keys[i].key, opts)
+		} else {
+			errs[i] = m.DeleteItem(ctx, keys[i].table.Name, keys[i].key, opts)
+		}
+	})
+
+	if err := batchError(keys, errs); err != nil {
+		return nil, err
+	}
+	unprocessed := make(map[string][]WriteRequest)
+	for i, key := range keys {
+		if errs[i] != nil {
+			logUnprocessed("write", key, errs[i])
+			unprocessed[key.table.Name] = append(unprocessed[key.table.Name], writes[i])
+		}
+	}
+	return unprocessed, nil
+}
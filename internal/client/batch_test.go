@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vrnvu/go-dynamolike/internal/item"
+	"github.com/vrnvu/go-dynamolike/internal/partition"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestFanOutBoundsParallelismPerNode(t *testing.T) {
+	gateway := &MinioGateway{}
+	gateway.topo.Store(&topology{partitioner: partition.NewRing(partition.Node{ID: "minio1"}, partition.Node{ID: "minio2"})})
+	users := Table{Name: "users", ID: "1234"}
+	var keys []batchKey
+	for i := range 4 * batchParallelism {
+		keys = append(keys, batchKey{table: users, name: fmt.Sprintf("user-%d", i)})
+	}
+
+	var mu sync.Mutex
+	running := make(map[string]int)
+	peak := make(map[string]int)
+	var calls atomic.Int32
+	gateway.fanOut(keys, func(i int) {
+		owner := gateway.topology().partitioner.Hash(users.key(keys[i].name))
+		mu.Lock()
+		running[owner]++
+		peak[owner] = max(peak[owner], running[owner])
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running[owner]--
+		mu.Unlock()
+		calls.Add(1)
+	})
+
+	assert.Equal(t, int32(len(keys)), calls.Load())
+	for owner, n := range peak {
+		assert.LessOrEqual(t, n, batchParallelism, owner)
+	}
+}
+
+func TestBatchWriteItemRejectsInvalidBatches(t *testing.T) {
+	gateway := &MinioGateway{}
+	gateway.catalog.store(catalog{Tables: map[string]Table{"users": {Name: "users", ID: "1234"}}})
+	alice := item.Item{"id": item.String("alice")}
+	tooMany := make([]WriteRequest, MaxBatchWriteItems+1)
+	for i := range tooMany {
+		tooMany[i].Delete = alice
+	}
+
+	tests := map[string]map[string][]WriteRequest{
+		"empty":           nil,
+		"put and delete":  {"users": {{Put: alice, Delete: alice}}},
+		"same item twice": {"users": {{Put: alice}, {Delete: alice}}},
+		"missing key":     {"users": {{Put: item.Item{"name": item.String("alice")}}}},
+		"too many writes": {"users": tooMany},
+	}
+	for name, requests := range tests {
+		_, err := gateway.BatchWriteItem(context.Background(), requests, ConsistencyDefault)
+		assert.ErrorIs(t, err, ErrInvalidArgument, name)
+	}
+}
+
+func TestBatchGetItemFailsOnPermanentErrors(t *testing.T) {
+	gateway, s3, table := newStreamedGateway(t)
+	s3.put(table.key("order-1"), []byte("not an item"), VersionMeta{Clock: vclock.Clock{"g": 1}}, time.Now())
+
+	_, err := gateway.BatchGetItem(context.Background(), map[string]BatchGetRequest{
+		"orders": {Keys: []item.Item{{"id": item.String("order-1")}, {"id": item.String("order-2")}}},
+	}, ConsistencyDefault)
+
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestBatchWriteItemLeavesConflictsUnprocessed(t *testing.T) {
+	gateway, s3, table := newStreamedGateway(t)
+	lockItem(t, s3, table, "order-1", "txn-1", false)
+	locked := WriteRequest{Put: item.Item{"id": item.String("order-1")}}
+
+	unprocessed, err := gateway.BatchWriteItem(context.Background(), map[string][]WriteRequest{
+		"orders": {locked, {Put: item.Item{"id": item.String("order-2")}}},
+	}, ConsistencyDefault)
+
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]WriteRequest{"orders": {locked}}, unprocessed)
+	assert.True(t, s3.Has(table.key("order-2")))
+}
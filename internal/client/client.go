@@ -1,37 +1,71 @@
 package client
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/vrnvu/go-dynamolike/internal/discovery"
 	"github.com/vrnvu/go-dynamolike/internal/partition"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
 )
 
 const (
 	bucketName     = "bucket-name"
 	bucketLocation = "us-east-1"
-	useMultipart   = int64(-1)
+
+	defaultReplicationFactor = 1
 )
 
 type MinioGateway struct {
-	registry    discovery.Registry
-	partitioner partition.Partitioner
-	nodes       map[int]*MinioNode
+	id                string
+	registry          discovery.Registry
+	newPartitioner    PartitionerFactory
+	topo              atomic.Pointer[topology]
+	counter           atomic.Uint64
+	membership        sync.Mutex
+	replicationFactor int
+	readQuorum        int
+	writeQuorum       int
+	sloppyQuorum      bool
+	tombstoneGrace    time.Duration
+	catalog           catalogCache
+	streams           streamTails
+	antiEntropy       antiEntropyState
+	rebalancer        rebalancer
+	droppedTables     droppedTables
 }
 
 type MinioGatewayBuilder struct {
-	registry    discovery.Registry
-	partitioner partition.Partitioner
-	nodes       map[int]*MinioNode
+	id                string
+	registry          discovery.Registry
+	newPartitioner    PartitionerFactory
+	replicationFactor int
+	readQuorum        int
+	writeQuorum       int
+	sloppyQuorum      bool
+	tombstoneGrace    time.Duration
 }
 
 func NewMinioGatewayFixed() *MinioGatewayBuilder {
-	return &MinioGatewayBuilder{}
+	return &MinioGatewayBuilder{replicationFactor: defaultReplicationFactor, tombstoneGrace: defaultTombstoneGrace}
+}
+
+// WithID sets the identifier the gateway records in vector clocks. It
+// defaults to the hostname.
+func (b *MinioGatewayBuilder) WithID(id string) *MinioGatewayBuilder {
+	b.id = id
+	return b
 }
 
 func (b *MinioGatewayBuilder) WithRegistry(registry discovery.Registry) *MinioGatewayBuilder {
@@ -39,15 +73,71 @@ func (b *MinioGatewayBuilder) WithRegistry(registry discovery.Registry) *MinioGa
 	return b
 }
 
+// WithPartitioner uses partitioner whatever the membership is.
 func (b *MinioGatewayBuilder) WithPartitioner(partitioner partition.Partitioner) *MinioGatewayBuilder {
-	b.partitioner = partitioner
+	b.newPartitioner = func([]string) partition.Partitioner { return partitioner }
+	return b
+}
+
+func (b *MinioGatewayBuilder) WithPartitionerFactory(factory PartitionerFactory) *MinioGatewayBuilder {
+	b.newPartitioner = factory
+	return b
+}
+
+// WithReplicationFactor sets how many distinct nodes each object is written to.
+func (b *MinioGatewayBuilder) WithReplicationFactor(n int) *MinioGatewayBuilder {
+	b.replicationFactor = n
+	return b
+}
+
+// WithQuorum sets the default read and write quorums. Zero selects a
+// majority of the replication factor.
+func (b *MinioGatewayBuilder) WithQuorum(r, w int) *MinioGatewayBuilder {
+	b.readQuorum = r
+	b.writeQuorum = w
+	return b
+}
+
+// WithSloppyQuorum stores writes for unhealthy replicas as hints on the next
+// healthy node.
+func (b *MinioGatewayBuilder) WithSloppyQuorum(enabled bool) *MinioGatewayBuilder {
+	b.sloppyQuorum = enabled
+	return b
+}
+
+// WithTombstoneGrace sets how long deleted objects keep their tombstone.
+func (b *MinioGatewayBuilder) WithTombstoneGrace(grace time.Duration) *MinioGatewayBuilder {
+	b.tombstoneGrace = grace
 	return b
 }
 
 func (b *MinioGatewayBuilder) build() (*MinioGateway, error) {
-	if b.registry == nil || b.partitioner == nil {
+	if b.registry == nil || b.newPartitioner == nil {
 		return nil, fmt.Errorf("registry and partitioner must be set")
 	}
+	if b.replicationFactor < 1 {
+		return nil, fmt.Errorf("replication factor must be at least 1, got %d", b.replicationFactor)
+	}
+	if b.tombstoneGrace <= 0 {
+		return nil, fmt.Errorf("tombstone grace period must be positive, got %s", b.tombstoneGrace)
+	}
+	if b.id == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("gateway id not set and hostname unavailable: %w", err)
+		}
+		b.id = hostname
+	}
+	if b.readQuorum == 0 {
+		b.readQuorum = majority(b.replicationFactor)
+	}
+	if b.writeQuorum == 0 {
+		b.writeQuorum = majority(b.replicationFactor)
+	}
+	if b.readQuorum < 1 || b.readQuorum > b.replicationFactor || b.writeQuorum < 1 || b.writeQuorum > b.replicationFactor {
+		return nil, fmt.Errorf("read quorum %d and write quorum %d must be between 1 and replication factor %d",
+			b.readQuorum, b.writeQuorum, b.replicationFactor)
+	}
 
 	instances := b.registry.GetInstances()
 	if len(instances) == 0 {
@@ -56,28 +146,23 @@ func (b *MinioGatewayBuilder) build() (*MinioGateway, error) {
 	}
 
 	slog.Info("Minio instances found", slog.Int("instance_count", len(instances)), slog.Any("instances", instances))
-	b.nodes = make(map[int]*MinioNode)
-	for i, instance := range instances {
-		node, err := New(
-			context.TODO(),
-			MinioNodeConfig{
-				NodeID:          instance.ID,
-				IPAddress:       instance.IP,
-				ContainerPort:   instance.ContainerPort,
-				AccessKeyID:     instance.User,
-				SecretAccessKey: instance.Password,
-				UseSSL:          false,
-			})
-		if err != nil {
-			slog.Error("Failed to create Minio node",
-				slog.String("node_id", instance.ID),
-				slog.String("error", err.Error()))
-			continue
-		}
-		b.nodes[i] = node
-	}
+	nodes, _ := buildNodes(context.TODO(), instances, nil, nil)
 
-	return &MinioGateway{registry: b.registry, partitioner: b.partitioner, nodes: b.nodes}, nil
+	gateway := &MinioGateway{
+		id:                b.id,
+		registry:          b.registry,
+		newPartitioner:    b.newPartitioner,
+		replicationFactor: b.replicationFactor,
+		readQuorum:        b.readQuorum,
+		writeQuorum:       b.writeQuorum,
+		sloppyQuorum:      b.sloppyQuorum,
+		tombstoneGrace:    b.tombstoneGrace,
+	}
+	gateway.topo.Store(&topology{nodes: nodes, partitioner: b.newPartitioner(sortedKeys(nodes))})
+	// Seeding from the wall clock keeps a restarted gateway from reusing the
+	// counters of writes lost in a crash.
+	gateway.counter.Store(uint64(time.Now().UnixMicro()))
+	return gateway, nil
 }
 
 func (b *MinioGatewayBuilder) InitializeBuckets() (*MinioGateway, error) {
@@ -86,7 +171,7 @@ func (b *MinioGatewayBuilder) InitializeBuckets() (*MinioGateway, error) {
 		return nil, err
 	}
 
-	for _, node := range gateway.nodes {
+	for _, node := range gateway.topology().nodes {
 		err := node.createBucket(context.Background())
 		if err != nil {
 			return nil, err
@@ -96,39 +181,398 @@ func (b *MinioGatewayBuilder) InitializeBuckets() (*MinioGateway, error) {
 	return gateway, nil
 }
 
-func (m *MinioGateway) Get(ctx context.Context, objectName string) (io.ReadCloser, error) {
-	nodeKey := m.partitioner.Hash(objectName)
-	node, ok := m.nodes[nodeKey]
-	if !ok {
-		slog.Error("Minio node not found",
-			slog.Int("node_key", nodeKey),
-			slog.String("object_name", objectName),
-			slog.Any("available_nodes", m.nodes))
-		return nil, fmt.Errorf("node %d not found for object %s", nodeKey, objectName)
+type versionsReply struct {
+	node     *MinioNode
+	versions []Version
+	locks    []string
+	err      error
+}
+
+type replicaRead struct {
+	answered []versionsReply
+	replies  <-chan versionsReply
+	pending  int
+}
+
+func (r *replicaRead) late() []versionsReply {
+	var late []versionsReply
+	for range r.pending {
+		if reply := <-r.replies; reply.err == nil {
+			late = append(late, reply)
+		}
+	}
+	return late
+}
+
+// readVersions asks every node for its versions of objectName and waits for
+// r of them to answer.
+func (m *MinioGateway) readVersions(ctx context.Context, objectName string, nodes []*MinioNode, r int) (*replicaRead, error) {
+	replies := make(chan versionsReply, len(nodes))
+	for _, node := range nodes {
+		go func() {
+			versions, locks, err := node.versions(ctx, objectName)
+			replies <- versionsReply{node: node, versions: versions, locks: locks, err: err}
+		}()
+	}
+
+	var answered []versionsReply
+	var errs []error
+	for i := range nodes {
+		reply := <-replies
+		if reply.err != nil {
+			slog.Warn("Failed to read replica",
+				slog.String("node_id", reply.node.ID),
+				slog.String("object_name", objectName),
+				slog.String("error", reply.err.Error()))
+			errs = append(errs, fmt.Errorf("node %s: %w", reply.node.ID, reply.err))
+			continue
+		}
+		answered = append(answered, reply)
+		if len(answered) == r {
+			return &replicaRead{answered: answered, replies: replies, pending: len(nodes) - i - 1}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: read quorum not met for object %s: %d of %d replicas answered: %w",
+		ErrNodeUnavailable, objectName, len(answered), r, errors.Join(errs...))
+}
+
+func collectVersions(replies []versionsReply) []Version {
+	var versions []Version
+	for _, reply := range replies {
+		versions = append(versions, reply.versions...)
 	}
-	return node.Get(ctx, objectName)
+	return versions
+}
+
+// Get waits for R replicas of objectName and returns the versions that are
+// not superseded by any other one seen on them.
+func (m *MinioGateway) Get(ctx context.Context, objectName string, opts GetOptions) (*Object, error) {
+	return m.lookup(ctx, objectName, opts, true)
+}
+
+// Stat is Get without read repair.
+func (m *MinioGateway) Stat(ctx context.Context, objectName string, opts GetOptions) (*Object, error) {
+	return m.lookup(ctx, objectName, opts, false)
 }
 
-func (m *MinioGateway) Put(ctx context.Context, objectName string, objectBody io.Reader) (minio.UploadInfo, error) {
-	nodeKey := m.partitioner.Hash(objectName)
-	node, ok := m.nodes[nodeKey]
-	if !ok {
-		slog.Error("Minio node not found",
-			slog.Int("node_key", nodeKey),
-			slog.String("object_name", objectName),
-			slog.Any("available_nodes", m.nodes))
-		return minio.UploadInfo{}, fmt.Errorf("node %d not found for object %s", nodeKey, objectName)
+func (m *MinioGateway) lookup(ctx context.Context, name string, opts GetOptions, readRepair bool) (*Object, error) {
+	siblings, err := m.readSiblings(ctx, name, opts, readRepair)
+	if err != nil {
+		return nil, err
+	}
+	values := visible(siblings)
+	if len(values) == 0 {
+		return nil, fmt.Errorf("object %s: %w", name, ErrNotFound)
+	}
+	return &Object{Name: name, Siblings: values, Context: mergeClocks(siblings)}, nil
+}
+
+func (m *MinioGateway) readSiblings(ctx context.Context, name string, opts GetOptions, readRepair bool) ([]Version, error) {
+	objectName, rep, err := m.locate(ctx, opts.Table, opts.index, name)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := m.topology().preferenceList(objectName, rep.factor)
+	if err != nil {
+		return nil, err
+	}
+	r := opts.Consistency.replicas(len(nodes), rep.read)
+
+	// Late replicas still feed read repair.
+	readCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), readRepairTimeout)
+	read, err := m.readVersions(readCtx, objectName, nodes, r)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if previous := m.previousOwners(objectName, nodes); len(previous) > 0 {
+		fallback, err := m.readVersions(readCtx, objectName, previous, 1)
+		if err == nil {
+			read.answered = append(read.answered, fallback.answered...)
+		}
+	}
+
+	siblings := reconcile(collectVersions(read.answered))
+	if readRepair {
+		go func() {
+			defer cancel()
+			replies := append(read.answered, read.late()...)
+			m.repair(readCtx, objectName, reconcile(collectVersions(replies)), replies, readRepairs)
+		}()
+	} else {
+		cancel()
+	}
+	return siblings, nil
+}
+
+type PutResult struct {
+	minio.UploadInfo
+	Clock vclock.Clock
+}
+
+type writeReply struct {
+	node *MinioNode
+	info minio.UploadInfo
+	err  error
+}
+
+// Put writes objectName to every replica in its preference list and returns
+// once W of them acknowledged. The new version descends from opts.Context,
+// or from whatever the replicas hold when there is none. size is -1 when
+// unknown; bodies over 16 MiB are staged on a replica instead of buffered.
+func (m *MinioGateway) Put(ctx context.Context, objectName string, objectBody io.Reader, size int64, opts PutOptions) (PutResult, error) {
+	if size > maxBufferedBody {
+		return m.putStaged(ctx, objectName, objectBody, size, opts)
+	}
+	if size >= 0 {
+		body := make([]byte, size)
+		if _, err := io.ReadFull(objectBody, body); err != nil {
+			return PutResult{}, fmt.Errorf("%w: failed to read object body of %d bytes: %w", ErrInvalidArgument, size, err)
+		}
+		return m.write(ctx, objectName, body, false, opts)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(objectBody, maxBufferedBody+1))
+	if err != nil {
+		return PutResult{}, fmt.Errorf("failed to read object body: %w", err)
+	}
+	if len(body) > maxBufferedBody {
+		return m.putStaged(ctx, objectName, io.MultiReader(bytes.NewReader(body), objectBody), -1, opts)
+	}
+	return m.write(ctx, objectName, body, false, opts)
+}
+
+// Delete replaces objectName with a tombstone.
+func (m *MinioGateway) Delete(ctx context.Context, objectName string, opts PutOptions) (PutResult, error) {
+	return m.write(ctx, objectName, nil, true, opts)
+}
+
+func (m *MinioGateway) write(ctx context.Context, name string, body []byte, tombstone bool, opts PutOptions) (PutResult, error) {
+	return m.writePayload(ctx, name, bytesPayload(body), tombstone, opts)
+}
+
+// writePayload is write for a body that may not be held in memory. A
+// conditional write missing its quorum is undone on the replicas that
+// accepted it.
+func (m *MinioGateway) writePayload(ctx context.Context, name string, body payload, tombstone bool, opts PutOptions) (PutResult, error) {
+	writing := false
+	defer func() {
+		if !writing {
+			body.release(context.WithoutCancel(ctx), false)
+		}
+	}()
+
+	objectName, rep, err := m.locate(ctx, opts.Table, opts.index, name)
+	if err != nil {
+		return PutResult{}, err
+	}
+	topo := m.topology()
+	nodes, err := topo.preferenceList(objectName, rep.factor)
+	if err != nil {
+		return PutResult{}, err
+	}
+	w := opts.Consistency.replicas(len(nodes), rep.write)
+
+	conditional := opts.conditional()
+	clock := opts.Context
+	table, isItem := m.tableOf(objectName)
+	streamed := isItem && table.Stream
+	var values []Version
+	if clock == nil || conditional || isItem {
+		r := min(rep.read, len(nodes))
+		if conditional {
+			r = opts.Consistency.replicas(len(nodes), rep.read)
+		}
+		read, err := m.readVersions(ctx, objectName, nodes, r)
+		if err != nil {
+			return PutResult{}, err
+		}
+		versions := collectVersions(read.answered)
+		values = visible(reconcile(versions))
+		if tombstone && !conditional && clock == nil && len(values) == 0 {
+			return PutResult{}, fmt.Errorf("object %s: %w", name, ErrNotFound)
+		}
+		if err := checkCondition(opts, values); err != nil {
+			return PutResult{}, fmt.Errorf("object %s: %w", name, err)
+		}
+		// Items locked by a transaction are only written by it.
+		if isItem && opts.version == nil {
+			var err error
+			if r < min(rep.read, len(nodes)) {
+				err = m.checkUnlocked(ctx, lockKey(objectName))
+			} else {
+				err = m.checkMarkers(ctx, objectName, read.answered)
+			}
+			if err != nil {
+				return PutResult{}, fmt.Errorf("object %s: %w", name, err)
+			}
+		}
+		if clock == nil || conditional {
+			clock = clock.Merge(mergeClocks(versions))
+		}
+	}
+	meta := VersionMeta{Clock: m.nextClock(clock), Tombstone: tombstone, UserMetadata: opts.UserMetadata}
+	if opts.version != nil {
+		meta.Clock = opts.version
+	}
+	if !tombstone {
+		meta.ExpiresAt = opts.ExpiresAt
+	}
+
+	var change *pendingChange
+	if streamed {
+		if change, err = m.markPending(ctx, table, objectName, values, meta); err != nil {
+			return PutResult{}, err
+		}
+	}
+
+	writeCtx := context.WithoutCancel(ctx)
+	replies := make(chan writeReply, len(nodes))
+	var fb *fallbacks
+	if m.sloppyQuorum && !conditional {
+		fb = &fallbacks{nodes: topo.fallbackNodes(objectName, nodes)}
+	}
+	writing = true
+	for _, node := range nodes {
+		go func() {
+			replies <- m.writeReplica(writeCtx, node, objectName, body, meta, conditional, fb)
+		}()
+	}
+
+	var acked []writeReply
+	var errs []error
+	received := 0
+	for len(acked) < w && len(errs) <= len(nodes)-w {
+		reply := <-replies
+		received++
+		if reply.err != nil {
+			logWriteFailure(objectName, reply)
+			errs = append(errs, fmt.Errorf("node %s: %w", reply.node.ID, reply.err))
+			continue
+		}
+		acked = append(acked, reply)
+	}
+	succeeded := len(acked) >= w
+	if conditional && !succeeded {
+		m.undoWrite(writeCtx, objectName, meta.Clock, replyNodes(acked))
+	}
+	go func() {
+		late := drainWriteReplies(objectName, replies, len(nodes)-received)
+		switch {
+		case conditional && succeeded:
+			m.commitWrite(writeCtx, objectName, body, meta, slices.Concat(acked, late))
+		case conditional:
+			m.undoWrite(writeCtx, objectName, meta.Clock, nodes)
+		}
+		body.release(writeCtx, succeeded)
+	}()
+
+	if !succeeded {
+		cause := ErrNodeUnavailable
+		if slices.ContainsFunc(errs, func(err error) bool { return errors.Is(err, ErrPreconditionFailed) }) {
+			cause = ErrPreconditionFailed
+		}
+		return PutResult{}, fmt.Errorf("%w: write quorum not met for object %s: %d of %d replicas acknowledged: %w",
+			cause, objectName, len(acked), w, errors.Join(errs...))
+	}
+	if streamed {
+		m.appendChange(ctx, table, change, acked[0].info)
+	}
+	return PutResult{UploadInfo: acked[0].info, Clock: meta.Clock}, nil
+}
+
+// nextClock returns clock advanced by a write the gateway coordinates. Its
+// counter is unique, so two writes reading the same clock never write the
+// same one.
+func (m *MinioGateway) nextClock(clock vclock.Clock) vclock.Clock {
+	for {
+		last := m.counter.Load()
+		next := max(last, clock[m.id]) + 1
+		if m.counter.CompareAndSwap(last, next) {
+			return clock.Merge(vclock.Clock{m.id: next})
+		}
+	}
+}
+
+func logWriteFailure(objectName string, reply writeReply) {
+	slog.Error("Failed to write replica",
+		slog.String("node_id", reply.node.ID),
+		slog.String("object_name", objectName),
+		slog.String("error", reply.err.Error()))
+}
+
+// drainWriteReplies logs the failures of the replicas still writing when
+// the quorum was reached and returns the replies of those that wrote.
+func drainWriteReplies(objectName string, replies <-chan writeReply, pending int) []writeReply {
+	var written []writeReply
+	for range pending {
+		reply := <-replies
+		if reply.err != nil {
+			logWriteFailure(objectName, reply)
+			continue
+		}
+		written = append(written, reply)
+	}
+	return written
+}
+
+func replyNodes(replies []writeReply) []*MinioNode {
+	nodes := make([]*MinioNode, 0, len(replies))
+	for _, reply := range replies {
+		nodes = append(nodes, reply.node)
+	}
+	return nodes
+}
+
+// commitWrite moves the version of a successful conditional write from its
+// sibling key to the object key.
+func (m *MinioGateway) commitWrite(ctx context.Context, objectName string, body payload, meta VersionMeta, replies []writeReply) {
+	for _, reply := range replies {
+		if _, err := reply.node.putVersion(ctx, objectName, body, meta, false); err != nil {
+			slog.Warn("Failed to commit conditional write",
+				slog.String("node_id", reply.node.ID),
+				slog.String("object_name", objectName),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+// undoWrite removes the versions written with clock from nodes.
+func (m *MinioGateway) undoWrite(ctx context.Context, objectName string, clock vclock.Clock, nodes []*MinioNode) {
+	for _, node := range nodes {
+		versions, err := node.Versions(ctx, objectName)
+		if err != nil {
+			slog.Warn("Failed to read replica to undo write",
+				slog.String("node_id", node.ID),
+				slog.String("object_name", objectName),
+				slog.String("error", err.Error()))
+			continue
+		}
+		for _, v := range versions {
+			if v.Clock.Compare(clock) != vclock.Equal {
+				continue
+			}
+			if _, err := node.removeVersion(ctx, v); err != nil {
+				slog.Warn("Failed to undo write",
+					slog.String("node_id", node.ID),
+					slog.String("key", v.Info.Key),
+					slog.String("error", err.Error()))
+			}
+		}
 	}
-	return node.Put(ctx, objectName, objectBody)
 }
 
 type MinioNode struct {
 	ID          string
+	instanceID  string
+	endpoint    string
 	minioClient *minio.Client
 }
 
 type MinioNodeConfig struct {
 	NodeID          string
+	InstanceID      string
 	IPAddress       string
 	ContainerPort   string
 	AccessKeyID     string
@@ -149,7 +593,7 @@ func New(ctx context.Context, config MinioNodeConfig) (*MinioNode, error) {
 		return nil, err
 	}
 
-	return &MinioNode{config.NodeID, minioClient}, nil
+	return &MinioNode{ID: config.NodeID, instanceID: config.InstanceID, endpoint: endpoint, minioClient: minioClient}, nil
 }
 
 func (m *MinioNode) createBucket(ctx context.Context) error {
@@ -177,10 +621,6 @@ func (m *MinioNode) createBucket(ctx context.Context) error {
 	return nil
 }
 
-func (m *MinioNode) Get(ctx context.Context, objectName string) (io.ReadCloser, error) {
+func (m *MinioNode) Get(ctx context.Context, objectName string) (*minio.Object, error) {
 	return m.minioClient.GetObject(ctx, bucketName, objectName, minio.GetObjectOptions{})
 }
-
-func (m *MinioNode) Put(ctx context.Context, objectName string, objectBody io.Reader) (minio.UploadInfo, error) {
-	return m.minioClient.PutObject(ctx, bucketName, objectName, objectBody, useMultipart, minio.PutObjectOptions{})
-}
@@ -1,11 +1,22 @@
 package client
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"github.com/vrnvu/go-dynamolike/internal/discovery"
+	"github.com/vrnvu/go-dynamolike/internal/partition"
+	"github.com/vrnvu/go-dynamolike/internal/s3test"
 )
 
 type mockRegistry struct {
@@ -35,13 +46,23 @@ func (m *mockRegistry) PollNetwork() error {
 	return args.Error(0)
 }
 
+func (m *mockRegistry) Subscribe() <-chan struct{} {
+	args := m.Called()
+	return args.Get(0).(<-chan struct{})
+}
+
 type mockPartitioner struct {
 	mock.Mock
 }
 
-func (m *mockPartitioner) Hash(key string) int {
+func (m *mockPartitioner) Hash(key string) string {
 	args := m.Called(key)
-	return args.Int(0)
+	return args.String(0)
+}
+
+func (m *mockPartitioner) PreferenceList(key string, n int) []string {
+	args := m.Called(key, n)
+	return args.Get(0).([]string)
 }
 
 func TestNewMinioGatewayFixedWithNoInstances(t *testing.T) {
@@ -49,7 +70,7 @@ func TestNewMinioGatewayFixedWithNoInstances(t *testing.T) {
 	mockRegistry.On("GetInstances").Return([]discovery.MinioInstance{})
 
 	mockPartitioner := new(mockPartitioner)
-	mockPartitioner.On("Hash", mock.Anything).Return(0)
+	mockPartitioner.On("Hash", mock.Anything).Return("minio1")
 
 	gateway, err := NewMinioGatewayFixed().WithRegistry(mockRegistry).WithPartitioner(mockPartitioner).build()
 
@@ -61,16 +82,239 @@ func TestNewMinioGatewayFixedWithNoInstances(t *testing.T) {
 func TestNewMinioGatewayFixedWithInstances(t *testing.T) {
 	mockRegistry := new(mockRegistry)
 	mockRegistry.On("GetInstances").Return([]discovery.MinioInstance{
-		{ID: "1", Name: "minio1", IP: "192.168.1.1", ContainerPort: "9000", HostPort: "9000", User: "minio", Password: "minio"},
-		{ID: "2", Name: "minio2", IP: "192.168.1.2", ContainerPort: "9000", HostPort: "9000", User: "minio", Password: "minio"},
+		{ID: "1", NodeID: "minio1", Name: "minio1", IP: "192.168.1.1", ContainerPort: "9000", HostPort: "9000", User: "minio", Password: "minio"},
+		{ID: "2", NodeID: "minio2", Name: "minio2", IP: "192.168.1.2", ContainerPort: "9000", HostPort: "9000", User: "minio", Password: "minio"},
 	})
 
 	mockPartitioner := new(mockPartitioner)
-	mockPartitioner.On("Hash", mock.Anything).Return(0)
+	mockPartitioner.On("Hash", mock.Anything).Return("minio1")
 
 	gateway, err := NewMinioGatewayFixed().WithRegistry(mockRegistry).WithPartitioner(mockPartitioner).build()
 
 	assert.NoError(t, err)
 	assert.NotNil(t, gateway)
-	assert.Equal(t, 2, len(gateway.nodes))
+	assert.Equal(t, 2, len(gateway.topology().nodes))
+}
+
+func TestNewMinioGatewayFixedWithInvalidReplicationFactor(t *testing.T) {
+	mockRegistry := new(mockRegistry)
+	mockPartitioner := new(mockPartitioner)
+
+	gateway, err := NewMinioGatewayFixed().
+		WithRegistry(mockRegistry).
+		WithPartitioner(mockPartitioner).
+		WithReplicationFactor(0).
+		build()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "replication factor must be at least 1")
+	assert.Nil(t, gateway)
+}
+
+func TestPreferenceListSkipsUnknownNodes(t *testing.T) {
+	mockRegistry := new(mockRegistry)
+	mockRegistry.On("GetInstances").Return([]discovery.MinioInstance{
+		{ID: "1", NodeID: "minio1", Name: "minio1", IP: "192.168.1.1", ContainerPort: "9000", HostPort: "9000", User: "minio", Password: "minio"},
+		{ID: "2", NodeID: "minio2", Name: "minio2", IP: "192.168.1.2", ContainerPort: "9000", HostPort: "9000", User: "minio", Password: "minio"},
+	})
+
+	mockPartitioner := new(mockPartitioner)
+	mockPartitioner.On("PreferenceList", "key", 3).Return([]string{"minio2", "minio5", "minio1"})
+
+	gateway, err := NewMinioGatewayFixed().
+		WithRegistry(mockRegistry).
+		WithPartitioner(mockPartitioner).
+		WithReplicationFactor(3).
+		build()
+	assert.NoError(t, err)
+
+	nodes, err := gateway.topology().preferenceList("key", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(nodes))
+	assert.Equal(t, gateway.topology().nodes["minio2"], nodes[0])
+	assert.Equal(t, gateway.topology().nodes["minio1"], nodes[1])
+}
+
+func TestNewMinioGatewayFixedDefaultsToMajorityQuorum(t *testing.T) {
+	mockRegistry := new(mockRegistry)
+	mockRegistry.On("GetInstances").Return([]discovery.MinioInstance{
+		{ID: "1", NodeID: "minio1", Name: "minio1", IP: "192.168.1.1", ContainerPort: "9000", HostPort: "9000", User: "minio", Password: "minio"},
+	})
+	mockPartitioner := new(mockPartitioner)
+
+	gateway, err := NewMinioGatewayFixed().
+		WithRegistry(mockRegistry).
+		WithPartitioner(mockPartitioner).
+		WithReplicationFactor(3).
+		build()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, gateway.readQuorum)
+	assert.Equal(t, 2, gateway.writeQuorum)
+}
+
+func TestNewMinioGatewayFixedWithInvalidQuorum(t *testing.T) {
+	mockRegistry := new(mockRegistry)
+	mockPartitioner := new(mockPartitioner)
+
+	gateway, err := NewMinioGatewayFixed().
+		WithRegistry(mockRegistry).
+		WithPartitioner(mockPartitioner).
+		WithReplicationFactor(2).
+		WithQuorum(1, 3).
+		build()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "must be between 1 and replication factor 2")
+	assert.Nil(t, gateway)
+}
+
+func TestNewMinioGatewayFixedIndexesNodesByNodeID(t *testing.T) {
+	mockRegistry := new(mockRegistry)
+	mockRegistry.On("GetInstances").Return([]discovery.MinioInstance{
+		{ID: "container-b", NodeID: "minio2", Name: "minio2", IP: "192.168.1.2", ContainerPort: "9000"},
+		{ID: "container-a", NodeID: "minio1", Name: "minio1", IP: "192.168.1.1", ContainerPort: "9000"},
+		{ID: "container-c", NodeID: "minio1", Name: "minio1", IP: "192.168.1.3", ContainerPort: "9000"},
+		{ID: "container-d", Name: "minio4", IP: "192.168.1.4", ContainerPort: "9000"},
+	})
+	mockPartitioner := new(mockPartitioner)
+
+	gateway, err := NewMinioGatewayFixed().WithRegistry(mockRegistry).WithPartitioner(mockPartitioner).build()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(gateway.topology().nodes), "duplicated and empty node IDs are skipped")
+	assert.Equal(t, "container-a", gateway.topology().nodes["minio1"].instanceID)
+	assert.Equal(t, "container-b", gateway.topology().nodes["minio2"].instanceID)
+}
+
+func TestRefreshDropsRemovedNodesAndKeepsExistingOnes(t *testing.T) {
+	mockRegistry := new(mockRegistry)
+	mockRegistry.On("GetInstances").Return([]discovery.MinioInstance{
+		{ID: "1", NodeID: "minio1", Name: "minio1", IP: "192.168.1.1", ContainerPort: "9000"},
+		{ID: "2", NodeID: "minio2", Name: "minio2", IP: "192.168.1.2", ContainerPort: "9000"},
+	}).Once()
+	mockRegistry.On("GetInstances").Return([]discovery.MinioInstance{
+		{ID: "1", NodeID: "minio1", Name: "minio1", IP: "192.168.1.1", ContainerPort: "9000"},
+	})
+
+	var partitionedFor [][]string
+	gateway, err := NewMinioGatewayFixed().
+		WithRegistry(mockRegistry).
+		WithPartitionerFactory(func(nodeIDs []string) partition.Partitioner {
+			partitionedFor = append(partitionedFor, nodeIDs)
+			return partition.NewRing()
+		}).
+		build()
+	assert.NoError(t, err)
+	before := gateway.topology()
+
+	err = gateway.Refresh(context.Background())
+
+	assert.NoError(t, err)
+	after := gateway.topology()
+	assert.Equal(t, 1, len(after.nodes))
+	assert.Same(t, before.nodes["minio1"], after.nodes["minio1"])
+	assert.Equal(t, 2, len(before.nodes), "the previous topology is left untouched for in-flight requests")
+	assert.Equal(t, [][]string{{"minio1", "minio2"}, {"minio1"}}, partitionedFor)
+
+	assert.NoError(t, gateway.Refresh(context.Background()))
+	assert.Same(t, after, gateway.topology(), "an unchanged membership keeps the topology")
+}
+
+func TestWatchMembershipMovesObjectsToJoiningNodes(t *testing.T) {
+	instanceOf := func(id string, s3 *s3test.Server) discovery.MinioInstance {
+		server := httptest.NewServer(s3)
+		t.Cleanup(server.Close)
+		endpoint, _ := url.Parse(server.URL)
+		host, port, _ := net.SplitHostPort(endpoint.Host)
+		return discovery.MinioInstance{ID: id, NodeID: id, IP: host, ContainerPort: port, User: "minio", Password: "minio123", Healthy: true}
+	}
+	s3a, s3b := s3test.NewServer(), s3test.NewServer()
+	a, b := instanceOf("a", s3a), instanceOf("b", s3b)
+	changes := make(chan struct{}, 1)
+	mockRegistry := new(mockRegistry)
+	mockRegistry.On("GetInstances").Return([]discovery.MinioInstance{a}).Twice()
+	mockRegistry.On("GetInstances").Return([]discovery.MinioInstance{a, b})
+	mockRegistry.On("GetInstance", mock.Anything).Return(discovery.MinioInstance{Healthy: true}, nil)
+	mockRegistry.On("Subscribe").Return((<-chan struct{})(changes))
+
+	gateway, err := NewMinioGatewayFixed().
+		WithID("gateway").
+		WithRegistry(mockRegistry).
+		WithPartitionerFactory(func(nodeIDs []string) partition.Partitioner {
+			var nodes []partition.Node
+			for _, id := range nodeIDs {
+				nodes = append(nodes, partition.Node{ID: id})
+			}
+			return partition.NewRing(nodes...)
+		}).
+		WithReplicationFactor(1).
+		InitializeBuckets()
+	require.NoError(t, err)
+	var names []string
+	for i := range 10 {
+		name := fmt.Sprintf("object-%d", i)
+		_, err := gateway.Put(context.Background(), name, strings.NewReader(name), int64(len(name)), PutOptions{})
+		require.NoError(t, err)
+		names = append(names, name)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go gateway.WatchMembership(ctx)
+
+	changes <- struct{}{}
+
+	assert.Eventually(t, func() bool {
+		status := gateway.RebalanceStatus()
+		return len(gateway.topology().nodes) == 2 && !status.Active && !status.Finished.IsZero()
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"b"}, gateway.RebalanceStatus().Joined)
+	moved := 0
+	for _, name := range names {
+		owners, err := gateway.topology().preferenceList(name, 1)
+		require.NoError(t, err)
+		if owners[0].ID == "b" {
+			moved++
+			assert.True(t, s3b.Has(name), name)
+			assert.False(t, s3a.Has(name), name)
+		}
+		_, err = gateway.Get(context.Background(), name, GetOptions{})
+		assert.NoError(t, err, name)
+	}
+	assert.NotZero(t, moved)
+}
+
+func TestBuildNodesRecreatesNodesThatChangedAddress(t *testing.T) {
+	instances := []discovery.MinioInstance{
+		{ID: "1", NodeID: "minio1", IP: "192.168.1.1", ContainerPort: "9000"},
+		{ID: "2", NodeID: "minio2", IP: "192.168.1.2", ContainerPort: "9000"},
+	}
+	current, err := buildNodes(context.Background(), instances, nil, nil)
+	assert.NoError(t, err)
+
+	instances[1].IP = "192.168.1.7"
+	nodes, err := buildNodes(context.Background(), instances, current, nil)
+
+	assert.NoError(t, err)
+	assert.Same(t, current["minio1"], nodes["minio1"])
+	assert.NotSame(t, current["minio2"], nodes["minio2"])
+	assert.Equal(t, "192.168.1.7:9000", nodes["minio2"].endpoint)
+	assert.True(t, sameNodeIDs(current, nodes))
+}
+
+func TestBuildNodesResolvesDuplicatedNodeIDsByInstanceID(t *testing.T) {
+	instances := []discovery.MinioInstance{
+		{ID: "b", NodeID: "minio1", IP: "192.168.1.2", ContainerPort: "9000"},
+		{ID: "a", NodeID: "minio1", IP: "192.168.1.1", ContainerPort: "9000"},
+		{ID: "c", NodeID: "minio2", IP: "192.168.1.3", ContainerPort: "9000"},
+	}
+
+	for range 2 {
+		nodes, err := buildNodes(context.Background(), instances, nil, nil)
+
+		assert.NoError(t, err)
+		assert.Len(t, nodes, 2)
+		assert.Equal(t, "a", nodes["minio1"].instanceID)
+		slices.Reverse(instances)
+	}
 }
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+func checkCondition(opts PutOptions, values []Version) error {
+	switch {
+	case opts.IfNoneMatch && len(values) > 0:
+		return fmt.Errorf("%w: object exists", ErrPreconditionFailed)
+	case opts.ifSiblings != nil:
+		if !sameSiblings(values, opts.ifSiblings) {
+			return fmt.Errorf("%w: object changed since it was read", ErrPreconditionFailed)
+		}
+		return nil
+	case opts.IfMatch == "":
+		return nil
+	case len(values) == 0:
+		return fmt.Errorf("%w: object doesn't exist", ErrPreconditionFailed)
+	case len(values) > 1:
+		return fmt.Errorf("%w: object has %d concurrent versions", ErrPreconditionFailed, len(values))
+	case !values[0].matches(opts.IfMatch):
+		return fmt.Errorf("%w: current version is %s", ErrPreconditionFailed, values[0].ID())
+	default:
+		return nil
+	}
+}
+
+func sameSiblings(values []Version, ids []string) bool {
+	if len(values) != len(ids) {
+		return false
+	}
+	for _, v := range values {
+		if !slices.Contains(ids, v.ID()) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether tag is the ETag or the version ID of v.
+func (v Version) matches(tag string) bool {
+	tag = strings.Trim(tag, `"`)
+	return tag == v.ID() || tag == strings.Trim(v.Info.ETag, `"`)
+}
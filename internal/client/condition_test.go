@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestCheckCondition(t *testing.T) {
+	current := Version{VersionMeta: VersionMeta{Clock: vclock.Clock{"a": 1}}, Info: minio.ObjectInfo{ETag: "etag-1", LastModified: time.Now()}}
+	concurrent := version("b", vclock.Clock{"b": 1}, time.Now())
+
+	tests := []struct {
+		name   string
+		opts   PutOptions
+		values []Version
+		ok     bool
+	}{
+		{"unconditional", PutOptions{}, []Version{current}, true},
+		{"create missing", PutOptions{IfNoneMatch: true}, nil, true},
+		{"create existing", PutOptions{IfNoneMatch: true}, []Version{current}, false},
+		{"match etag", PutOptions{IfMatch: `"etag-1"`}, []Version{current}, true},
+		{"match version", PutOptions{IfMatch: current.ID()}, []Version{current}, true},
+		{"stale etag", PutOptions{IfMatch: "etag-0"}, []Version{current}, false},
+		{"match missing", PutOptions{IfMatch: "etag-1"}, nil, false},
+		{"match siblings", PutOptions{IfMatch: "etag-1"}, []Version{current, concurrent}, false},
+		{"same siblings", PutOptions{ifSiblings: []string{concurrent.ID(), current.ID()}}, []Version{current, concurrent}, true},
+		{"sibling replaced", PutOptions{ifSiblings: []string{current.ID(), vclock.Clock{"b": 2}.Version()}}, []Version{current, concurrent}, false},
+		{"sibling added", PutOptions{ifSiblings: []string{current.ID()}}, []Version{current, concurrent}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCondition(tt.opts, tt.values)
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrPreconditionFailed)
+			}
+		})
+	}
+}
+
+func TestConditionalWriteIsMovedToTheObjectKey(t *testing.T) {
+	node, s3 := newFakeNode(t, "a")
+	gateway := newFakeGateway(node)
+	current := vclock.Clock{"g": 1}
+	s3.put("key", []byte("v1"), VersionMeta{Clock: current}, time.Now())
+
+	_, err := gateway.write(context.Background(), "key", []byte("v2"), false, PutOptions{IfMatch: current.Version()})
+
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		keys := s3.Keys()
+		return len(keys) == 1 && keys[0] == "key"
+	}, time.Second, 10*time.Millisecond)
+	versions, err := node.Versions(context.Background(), "key")
+	assert.NoError(t, err)
+	body, err := versions[0].read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", string(body))
+}
+
+func TestConditionalWriteMissingQuorumIsUndone(t *testing.T) {
+	a, aS3 := newFakeNode(t, "a")
+	b, bS3 := newFakeNode(t, "b")
+	c, cS3 := newFakeNode(t, "c")
+	gateway := newFakeGateway(a, b, c)
+	current := vclock.Clock{"g": 1}
+	for _, s3 := range []*fakeS3{aS3, bS3, cS3} {
+		s3.put("key", []byte("v1"), VersionMeta{Clock: current}, time.Now())
+	}
+
+	// Another gateway writes to b and c while the conditional write does,
+	// after the condition was checked.
+	for _, s3 := range []*fakeS3{bS3, cS3} {
+		var once sync.Once
+		s3.Before = func(method, key string) {
+			if method == "PUT" {
+				once.Do(func() {
+					s3.put("key", []byte("other"), VersionMeta{Clock: vclock.Clock{"g": 1, "other": 1}}, time.Now())
+				})
+			}
+		}
+	}
+
+	_, err := gateway.write(context.Background(), "key", []byte("v2"), false, PutOptions{IfMatch: current.Version()})
+
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	// a may still be writing when b and c fail the write, it is undone once done.
+	assert.Eventually(t, func() bool {
+		return len(aS3.RemovedKeys()) == 1 && len(aS3.Keys()) == 1
+	}, time.Second, 10*time.Millisecond)
+	for _, s3 := range []*fakeS3{aS3, bS3, cS3} {
+		assert.Equal(t, []string{"key"}, s3.Keys())
+	}
+	versions, err := a.Versions(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+	assert.Equal(t, current, versions[0].Clock)
+}
+
+func TestReadModifyWriteRetriesWhenSiblingsChange(t *testing.T) {
+	node, s3 := newFakeNode(t, "a")
+	gateway := newFakeGateway(node)
+	s3.put("key", []byte("v1"), VersionMeta{Clock: vclock.Clock{"a": 1}}, time.Now())
+	s3.put(siblingKey("key", vclock.Clock{"b": 1}), []byte("v2"), VersionMeta{Clock: vclock.Clock{"b": 1}}, time.Now())
+
+	var seen []int
+	_, err := gateway.readModifyWrite(context.Background(), "key", PutOptions{}, func(object *Object, _ *PutOptions) ([]byte, bool, error) {
+		seen = append(seen, len(object.Siblings))
+		if len(seen) == 1 {
+			// Another gateway resolves the siblings first.
+			s3.put("key", []byte("v3"), VersionMeta{Clock: vclock.Clock{"a": 1, "b": 1, "c": 1}}, time.Now())
+		}
+		return []byte("resolved"), false, nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, seen)
+	object, err := gateway.Get(context.Background(), "key", GetOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, object.Siblings, 1)
+	assert.True(t, object.Context.Descends(vclock.Clock{"a": 1, "b": 1, "c": 1}))
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Increment adds by to the integer stored in objectName as decimal text and
+// returns the new value. A missing object counts as zero. Concurrent
+// increments through any gateway are never lost.
+func (m *MinioGateway) Increment(ctx context.Context, objectName string, by int64, opts PutOptions) (int64, PutResult, error) {
+	switch opts.Consistency {
+	case ConsistencyDefault:
+		opts.Consistency = ConsistencyQuorum
+	case ConsistencyOne:
+		return 0, PutResult{}, fmt.Errorf("%w: increments need quorum or all consistency, got %s", ErrInvalidArgument, opts.Consistency)
+	}
+	var value int64
+	result, err := m.readModifyWrite(ctx, objectName, opts, func(object *Object, writeOpts *PutOptions) ([]byte, bool, error) {
+		current, err := counterValue(ctx, object)
+		if err != nil {
+			return nil, false, err
+		}
+		if value, err = addCounter(current, by); err != nil {
+			return nil, false, err
+		}
+		if object != nil {
+			writeOpts.UserMetadata = object.Siblings[0].UserMetadata
+			writeOpts.ExpiresAt = object.Siblings[0].ExpiresAt
+		}
+		return []byte(strconv.FormatInt(value, 10)), false, nil
+	})
+	return value, result, err
+}
+
+// counterValue returns the value of object, zero when there is none.
+// Concurrent values fail with ErrConflict rather than being merged.
+func counterValue(ctx context.Context, object *Object) (int64, error) {
+	if object == nil {
+		return 0, nil
+	}
+	if len(object.Siblings) > 1 {
+		return 0, fmt.Errorf("%w: counter %s has %d concurrent values, write it with its context to resolve them",
+			ErrConflict, object.Name, len(object.Siblings))
+	}
+	data, err := object.Siblings[0].read(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n, err := parseCounter(data)
+	if err != nil {
+		return 0, fmt.Errorf("object %s: %w", object.Name, err)
+	}
+	return n, nil
+}
+
+func parseCounter(data []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(bytes.TrimSpace(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: not a counter: %w", ErrInvalidArgument, err)
+	}
+	return n, nil
+}
+
+func addCounter(current, by int64) (int64, error) {
+	if by > 0 && current > math.MaxInt64-by || by < 0 && current < math.MinInt64-by {
+		return 0, fmt.Errorf("%w: incrementing %d by %d overflows", ErrInvalidArgument, current, by)
+	}
+	return current + by, nil
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestParseCounter(t *testing.T) {
+	n, err := parseCounter([]byte("-42\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-42), n)
+
+	_, err = parseCounter([]byte("4.2"))
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestAddCounterRejectsOverflows(t *testing.T) {
+	n, err := addCounter(math.MaxInt64-1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64), n)
+
+	_, err = addCounter(math.MaxInt64, 1)
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	_, err = addCounter(math.MinInt64, -1)
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestCounterOfMissingObjectIsZero(t *testing.T) {
+	n, err := counterValue(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestIncrementRejectsWeakConsistency(t *testing.T) {
+	gateway := &MinioGateway{}
+
+	_, _, err := gateway.Increment(context.Background(), "visits", 1, PutOptions{Consistency: ConsistencyOne})
+
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestIncrementOfConcurrentValuesFailsWithConflict(t *testing.T) {
+	node, s3 := newFakeNode(t, "a")
+	gateway := newFakeGateway(node)
+	s3.put("visits", []byte("5"), VersionMeta{Clock: vclock.Clock{"a": 1}}, time.Now())
+	s3.put(siblingKey("visits", vclock.Clock{"b": 1}), []byte("3"), VersionMeta{Clock: vclock.Clock{"b": 1}}, time.Now())
+
+	_, _, err := gateway.Increment(context.Background(), "visits", -1, PutOptions{})
+
+	assert.ErrorIs(t, err, ErrConflict)
+	object, err := gateway.Get(context.Background(), "visits", GetOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, object.Siblings, 2, "nothing is written")
+}
+
+func TestIncrementAddsToTheValueRead(t *testing.T) {
+	node, s3 := newFakeNode(t, "a")
+	gateway := newFakeGateway(node)
+	s3.put("visits", []byte("5"), VersionMeta{Clock: vclock.Clock{"a": 1}}, time.Now())
+
+	value, _, err := gateway.Increment(context.Background(), "visits", -2, PutOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), value)
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Errors returned by the gateway wrap one of these.
+var (
+	ErrNotFound           = errors.New("object not found")
+	ErrNodeUnavailable    = errors.New("node unavailable")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrConflict is returned when concurrent writes kept a request from
+	// completing. Retrying it may succeed.
+	ErrConflict        = errors.New("conflict")
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+func nodeError(err error) error {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return nil
+	case isNotFound(err):
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	case isPreconditionFailed(err):
+		return fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+	case errors.As(err, &netErr), minio.ToErrorResponse(err).StatusCode == http.StatusServiceUnavailable:
+		return fmt.Errorf("%w: %w", ErrNodeUnavailable, err)
+	default:
+		return err
+	}
+}
+
+func isNotFound(err error) bool {
+	return minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
+
+func isPreconditionFailed(err error) bool {
+	return err != nil && minio.ToErrorResponse(err).StatusCode == http.StatusPreconditionFailed
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var objectsExpired = expvar.NewMap("objects_expired")
+
+// SweepExpired replaces the expired objects owned by every healthy node with
+// a tombstone, so their space is reclaimed like any other delete.
+func (m *MinioGateway) SweepExpired(ctx context.Context) error {
+	topo := m.topology()
+	return m.scanNodes(ctx, topo, m.healthySet(topo), m.newExpiryScan(topo))
+}
+
+type expiryScan struct {
+	m       *MinioGateway
+	topo    *topology
+	now     time.Time
+	expired map[*MinioNode]map[string]bool
+}
+
+func (m *MinioGateway) newExpiryScan(topo *topology) *expiryScan {
+	return &expiryScan{m: m, topo: topo, now: time.Now(), expired: make(map[*MinioNode]map[string]bool)}
+}
+
+func (s *expiryScan) visit(_ context.Context, node *MinioNode, object minio.ObjectInfo) {
+	if nodeLocal(object.Key) {
+		return
+	}
+	expiresAt, ok := listedExpiry(object)
+	if !ok || s.now.Before(expiresAt) {
+		return
+	}
+	objectName := objectNameOf(object.Key)
+	replicas, err := s.m.replicasOf(s.topo, objectName)
+	if err != nil || replicas[0] != node {
+		return
+	}
+	if s.expired[node] == nil {
+		s.expired[node] = make(map[string]bool)
+	}
+	s.expired[node][objectName] = true
+}
+
+func (s *expiryScan) finish(ctx context.Context, _ error) error {
+	var errs []error
+	for _, nodeID := range sortedKeys(s.topo.nodes) {
+		node := s.topo.nodes[nodeID]
+		for _, objectName := range sortedKeys(s.expired[node]) {
+			if err := s.m.sweep(ctx, node, objectName); err != nil {
+				errs = append(errs, fmt.Errorf("object %s: %w", objectName, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sweep replaces objectName with a tombstone if every version a read quorum
+// holds expired.
+func (m *MinioGateway) sweep(ctx context.Context, owner *MinioNode, objectName string) error {
+	nodes, err := m.replicasOf(m.topology(), objectName)
+	if err != nil {
+		return err
+	}
+	read, err := m.readVersions(ctx, objectName, nodes, min(m.replicationOf(objectName).read, len(nodes)))
+	if err != nil {
+		return err
+	}
+	siblings := reconcile(collectVersions(read.answered))
+	deleted := slices.ContainsFunc(siblings, func(v Version) bool { return v.Tombstone })
+	if len(visible(siblings)) > 0 || deleted || len(siblings) == 0 {
+		return nil
+	}
+	if _, err := m.write(ctx, objectName, nil, true, PutOptions{Context: mergeClocks(siblings)}); err != nil {
+		return err
+	}
+	objectsExpired.Add(owner.ID, 1)
+	slog.Debug("Expired object", slog.String("node_id", owner.ID), slog.String("object_name", objectName))
+	return nil
+}
+
+// listedExpiry returns the expiry of a listed object. Listings return user
+// metadata under the names of their headers.
+func listedExpiry(info minio.ObjectInfo) (time.Time, bool) {
+	for key, value := range info.UserMetadata {
+		key = strings.TrimPrefix(http.CanonicalHeaderKey(key), "X-Amz-Meta-")
+		if key != expiresAtMetadataKey {
+			continue
+		}
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(seconds, 0), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestSweepExpiredReplacesExpiredObjectsWithTombstones(t *testing.T) {
+	a, s3a := newFakeNode(t, "a")
+	b, s3b := newFakeNode(t, "b")
+	gateway := newFakeGateway(a, b)
+	now := time.Now()
+	for _, s3 := range []*fakeS3{s3a, s3b} {
+		s3.put("expired", []byte("v"), VersionMeta{Clock: vclock.Clock{"g": 1}, ExpiresAt: now.Add(-time.Minute)}, now)
+		s3.put("expiring", []byte("v"), VersionMeta{Clock: vclock.Clock{"g": 1}, ExpiresAt: now.Add(time.Hour)}, now)
+	}
+
+	require.NoError(t, gateway.SweepExpired(context.Background()))
+
+	for _, node := range []*MinioNode{a, b} {
+		versions, err := node.Versions(context.Background(), "expired")
+		require.NoError(t, err)
+		require.Len(t, versions, 1)
+		assert.True(t, versions[0].Tombstone, node.ID)
+		assert.True(t, versions[0].Clock.Descends(vclock.Clock{"g": 1}), "the tombstone descends from the expired version")
+
+		versions, err = node.Versions(context.Background(), "expiring")
+		require.NoError(t, err)
+		require.Len(t, versions, 1)
+		assert.False(t, versions[0].Tombstone, node.ID)
+	}
+}
+
+func TestSweepKeepsValueWrittenAfterTheExpiredOne(t *testing.T) {
+	node, s3 := newFakeNode(t, "a")
+	gateway := newFakeGateway(node)
+	now := time.Now()
+	s3.put("key", []byte("v1"), VersionMeta{Clock: vclock.Clock{"g": 1}, ExpiresAt: now.Add(-time.Minute)}, now)
+	// The key is rewritten without an expiry between the listing and the sweep.
+	var once sync.Once
+	s3.Before = func(method, key string) {
+		if method == "HEAD" && key == "key" {
+			once.Do(func() { s3.put("key", []byte("v2"), VersionMeta{Clock: vclock.Clock{"g": 2}}, time.Now()) })
+		}
+	}
+
+	require.NoError(t, gateway.SweepExpired(context.Background()))
+
+	object, err := gateway.Get(context.Background(), "key", GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, object.Siblings, 1)
+	assert.Equal(t, vclock.Clock{"g": 2}, object.Siblings[0].Clock)
+}
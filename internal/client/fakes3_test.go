@@ -0,0 +1,74 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/mock"
+	"github.com/vrnvu/go-dynamolike/internal/discovery"
+	"github.com/vrnvu/go-dynamolike/internal/partition"
+	"github.com/vrnvu/go-dynamolike/internal/s3test"
+)
+
+// fakeS3 is the S3 server behind a fake node.
+type fakeS3 struct {
+	*s3test.Server
+}
+
+// newFakeNode returns a node backed by a fakeS3.
+func newFakeNode(t *testing.T, id string) (*MinioNode, *fakeS3) {
+	t.Helper()
+	fake := &fakeS3{s3test.NewServer()}
+	server := httptest.NewTLSServer(fake)
+	t.Cleanup(server.Close)
+
+	endpoint, _ := url.Parse(server.URL)
+	minioClient, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:     credentials.NewStaticV4("minio", "minio123", ""),
+		Secure:    true,
+		Region:    bucketLocation,
+		Transport: server.Client().Transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &MinioNode{ID: id, instanceID: id, endpoint: endpoint.Host, minioClient: minioClient}, fake
+}
+
+// newFakeGateway returns a gateway replicating every object on all of
+// nodes, which are healthy.
+func newFakeGateway(nodes ...*MinioNode) *MinioGateway {
+	registry := new(mockRegistry)
+	registry.On("GetInstance", mock.Anything).Return(discovery.MinioInstance{Healthy: true}, nil)
+
+	byID := make(map[string]*MinioNode, len(nodes))
+	var ring []partition.Node
+	for _, node := range nodes {
+		byID[node.ID] = node
+		ring = append(ring, partition.Node{ID: node.ID})
+	}
+	m := &MinioGateway{
+		id:                "gateway",
+		registry:          registry,
+		replicationFactor: len(nodes),
+		readQuorum:        majority(len(nodes)),
+		writeQuorum:       majority(len(nodes)),
+		tombstoneGrace:    defaultTombstoneGrace,
+	}
+	m.topo.Store(&topology{nodes: byID, partitioner: partition.NewRing(ring...)})
+	return m
+}
+
+// put stores an object as last modified at modified.
+func (f *fakeS3) put(key string, body []byte, meta VersionMeta, modified time.Time) {
+	header := make(http.Header)
+	for name, value := range meta.userMetadata() {
+		header.Set("X-Amz-Meta-"+name, value)
+	}
+	f.Put(key, body, header, modified)
+}
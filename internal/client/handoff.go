@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+const hintsPrefix = reservedPrefix + "hints/"
+
+var (
+	hintsStored   = expvar.NewMap("hints_stored")
+	hintsReplayed = expvar.NewMap("hints_replayed")
+)
+
+// hintKey names a hint for objectName held on behalf of owner.
+func hintKey(owner, objectName string, clock vclock.Clock) string {
+	return hintsPrefix + owner + "/" + objectName + "/" + clock.Version()
+}
+
+func parseHintKey(key string) (owner, objectName string, ok bool) {
+	rest, ok := strings.CutPrefix(key, hintsPrefix)
+	if !ok {
+		return "", "", false
+	}
+	owner, rest, ok = strings.Cut(rest, "/")
+	if !ok {
+		return "", "", false
+	}
+	i := strings.LastIndex(rest, "/")
+	if i <= 0 {
+		return "", "", false
+	}
+	return owner, rest[:i], true
+}
+
+// fallbacks hands out the nodes after the preference list to the replicas
+// that could not be written.
+type fallbacks struct {
+	mu    sync.Mutex
+	nodes []*MinioNode
+}
+
+func (f *fallbacks) take(isHealthy func(*MinioNode) bool) *MinioNode {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.nodes) > 0 {
+		node := f.nodes[0]
+		f.nodes = f.nodes[1:]
+		if isHealthy(node) {
+			return node
+		}
+	}
+	return nil
+}
+
+func (m *MinioGateway) isHealthy(node *MinioNode) bool {
+	instance, err := m.registry.GetInstance(node.instanceID)
+	return err == nil && instance.Healthy
+}
+
+// writeReplica writes one replica of objectName. With a sloppy quorum a
+// replica that can't be written is replaced by a hint on a fallback node.
+func (m *MinioGateway) writeReplica(ctx context.Context, node *MinioNode, objectName string, body payload, meta VersionMeta, conditional bool, fb *fallbacks) writeReply {
+	if fb == nil || m.isHealthy(node) {
+		info, err := node.putVersion(ctx, objectName, body, meta, conditional)
+		if err == nil || fb == nil {
+			return writeReply{node: node, info: info, err: err}
+		}
+		logWriteFailure(objectName, writeReply{node: node, err: err})
+	}
+
+	for {
+		fallback := fb.take(m.isHealthy)
+		if fallback == nil {
+			return writeReply{node: node, err: fmt.Errorf("%w: no healthy fallback node for hint", ErrNodeUnavailable)}
+		}
+		info, err := fallback.putHint(ctx, node.ID, objectName, body, meta)
+		if err != nil {
+			slog.Warn("Failed to store hint",
+				slog.String("node_id", fallback.ID),
+				slog.String("owner_id", node.ID),
+				slog.String("object_name", objectName),
+				slog.String("error", err.Error()))
+			continue
+		}
+		hintsStored.Add(node.ID, 1)
+		slog.Info("Stored hinted write",
+			slog.String("node_id", fallback.ID),
+			slog.String("owner_id", node.ID),
+			slog.String("object_name", objectName))
+		return writeReply{node: node, info: info}
+	}
+}
+
+func (m *MinioNode) putHint(ctx context.Context, owner, objectName string, body payload, meta VersionMeta) (minio.UploadInfo, error) {
+	reader, err := body.open(ctx)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	defer reader.Close()
+	return m.minioClient.PutObject(ctx, bucketName, hintKey(owner, objectName, meta.Clock), reader, body.size(),
+		minio.PutObjectOptions{UserMetadata: meta.userMetadata()})
+}
+
+// ReplayHints delivers the hints held by every healthy node to their owners
+// once they are healthy again.
+func (m *MinioGateway) ReplayHints(ctx context.Context) error {
+	var errs []error
+	topo := m.topology()
+	for _, node := range topo.nodes {
+		if !m.isHealthy(node) {
+			continue
+		}
+		if err := m.replayHintsFrom(ctx, topo, node); err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", node.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *MinioGateway) replayHintsFrom(ctx context.Context, topo *topology, node *MinioNode) error {
+	for object := range node.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:    hintsPrefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		ownerID, objectName, ok := parseHintKey(object.Key)
+		if !ok {
+			slog.Warn("Ignoring malformed hint", slog.String("node_id", node.ID), slog.String("key", object.Key))
+			continue
+		}
+		owners := []*MinioNode{topo.nodes[ownerID]}
+		if owners[0] == nil {
+			// The owner left the cluster.
+			var err error
+			if owners, err = m.replicasOf(topo, objectName); err != nil {
+				return err
+			}
+		}
+		if slices.ContainsFunc(owners, func(owner *MinioNode) bool { return !m.isHealthy(owner) }) {
+			continue
+		}
+
+		hint, err := node.statVersion(ctx, object.Key)
+		if err != nil {
+			return err
+		}
+		delivered := true
+		for _, owner := range owners {
+			if err := replicate(ctx, objectName, hint, owner); err != nil {
+				slog.Warn("Failed to replay hint",
+					slog.String("node_id", node.ID),
+					slog.String("owner_id", owner.ID),
+					slog.String("object_name", objectName),
+					slog.String("error", err.Error()))
+				delivered = false
+			}
+		}
+		if !delivered {
+			continue
+		}
+		if err := node.minioClient.RemoveObject(ctx, bucketName, object.Key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+		hintsReplayed.Add(ownerID, 1)
+		slog.Info("Replayed hinted write",
+			slog.String("node_id", node.ID),
+			slog.String("owner_id", ownerID),
+			slog.String("object_name", objectName))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestHintKeyRoundTrip(t *testing.T) {
+	key := hintKey("node-1", "dir/object", vclock.Clock{"a": 1})
+
+	owner, objectName, ok := parseHintKey(key)
+
+	assert.True(t, ok)
+	assert.True(t, IsReserved(key))
+	assert.Equal(t, "node-1", owner)
+	assert.Equal(t, "dir/object", objectName)
+
+	_, _, ok = parseHintKey(hintsPrefix + "node-1/")
+	assert.False(t, ok)
+	_, _, ok = parseHintKey(siblingsPrefix + "node-1/object/version")
+	assert.False(t, ok)
+}
+
+func TestFallbacksSkipUnhealthyAndAreUsedOnce(t *testing.T) {
+	down := &MinioNode{ID: "down"}
+	up1 := &MinioNode{ID: "up-1"}
+	up2 := &MinioNode{ID: "up-2"}
+	fb := &fallbacks{nodes: []*MinioNode{down, up1, up2}}
+	isHealthy := func(node *MinioNode) bool { return node != down }
+
+	assert.Equal(t, up1, fb.take(isHealthy))
+	assert.Equal(t, up2, fb.take(isHealthy))
+	assert.Nil(t, fb.take(isHealthy))
+}
+
+func TestReplayHintsOfDepartedOwnerGoToCurrentOwners(t *testing.T) {
+	a, aS3 := newFakeNode(t, "a")
+	b, bS3 := newFakeNode(t, "b")
+	gateway := newFakeGateway(a, b)
+	key := hintKey("gone", "report", vclock.Clock{"g": 1})
+	aS3.put(key, []byte("v1"), VersionMeta{Clock: vclock.Clock{"g": 1}}, time.Now())
+
+	require.NoError(t, gateway.ReplayHints(context.Background()))
+
+	assert.True(t, aS3.Has("report"))
+	assert.True(t, bS3.Has("report"))
+	assert.False(t, aS3.Has(key), "delivered hints are removed")
+}
@@ -0,0 +1,265 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/vrnvu/go-dynamolike/internal/item"
+	"github.com/vrnvu/go-dynamolike/internal/partition"
+)
+
+const (
+	IndexCreating = "CREATING"
+	IndexActive   = "ACTIVE"
+
+	indexUpdateTimeout = 30 * time.Second
+
+	// baseKeySeparator ends the sort key in the names of index entries. Key
+	// segments never contain it.
+	baseKeySeparator = ","
+)
+
+var indexUpdateFailures = expvar.NewInt("index_update_failures")
+
+// Index is a global secondary index of a table. Its entries are updated
+// after the items are written, so queries on it are eventually consistent.
+type Index struct {
+	Name         string        `json:"name"`
+	ID           string        `json:"id"`
+	Created      time.Time     `json:"created"`
+	PartitionKey KeyAttribute  `json:"partition_key"`
+	SortKey      *KeyAttribute `json:"sort_key,omitempty"`
+	Status       string        `json:"status"`
+}
+
+type IndexOptions struct {
+	PartitionKey KeyAttribute  `json:"partition_key"`
+	SortKey      *KeyAttribute `json:"sort_key,omitempty"`
+}
+
+func (i Index) key(entryName string) string {
+	return tablesPrefix + i.ID + "/" + entryName
+}
+
+func (i Index) keyAttributes() []KeyAttribute {
+	keys := []KeyAttribute{i.PartitionKey}
+	if i.SortKey != nil {
+		keys = append(keys, *i.SortKey)
+	}
+	return keys
+}
+
+// entryName returns the name of the entry of the item named name, false when
+// it lacks the index key attributes.
+func (i Index) entryName(name string, it item.Item) (string, bool) {
+	partitionKey, err := keySegment(i.PartitionKey, it[i.PartitionKey.Name])
+	if err != nil {
+		return "", false
+	}
+	if i.SortKey == nil {
+		return partition.CompositeKey(partitionKey, name), true
+	}
+	sortKey, err := sortSegment(*i.SortKey, it[i.SortKey.Name])
+	if err != nil {
+		return "", false
+	}
+	return partition.CompositeKey(partitionKey, sortKey+baseKeySeparator+name), true
+}
+
+// CreateIndex adds an index to a table. BackfillIndexes writes its entries
+// for existing items, the index can't be queried until then.
+func (m *MinioGateway) CreateIndex(ctx context.Context, table, name string, opts IndexOptions) (Index, error) {
+	if !tableNamePattern.MatchString(name) {
+		return Index{}, fmt.Errorf("%w: index name %q, expected 3 to 255 letters, digits, '_', '-' or '.'", ErrInvalidArgument, name)
+	}
+	index := Index{
+		Name:         name,
+		ID:           uuid.NewString(),
+		Created:      time.Now().UTC(),
+		PartitionKey: opts.PartitionKey,
+		SortKey:      opts.SortKey,
+		Status:       IndexCreating,
+	}
+	if err := index.PartitionKey.validate("index partition key"); err != nil {
+		return Index{}, err
+	}
+	if index.SortKey != nil {
+		if err := index.SortKey.validate("index sort key"); err != nil {
+			return Index{}, err
+		}
+	}
+
+	err := m.updateCatalog(ctx, func(cat *catalog) error {
+		t, ok := cat.Tables[table]
+		if !ok {
+			return fmt.Errorf("table %s: %w", table, ErrNotFound)
+		}
+		if _, ok := t.index(name); ok {
+			return fmt.Errorf("%w: index %s of table %s exists", ErrConflict, name, table)
+		}
+		t.Indexes = append(slices.Clone(t.Indexes), index)
+		cat.Tables[table] = t
+		return nil
+	})
+	if err != nil {
+		return Index{}, err
+	}
+	slog.Info("Created index", slog.String("table", table), slog.String("index", name), slog.String("index_id", index.ID))
+	return index, nil
+}
+
+func (m *MinioGateway) DeleteIndex(ctx context.Context, table, name string) error {
+	return m.updateCatalog(ctx, func(cat *catalog) error {
+		t, ok := cat.Tables[table]
+		if !ok {
+			return fmt.Errorf("table %s: %w", table, ErrNotFound)
+		}
+		index, ok := t.index(name)
+		if !ok {
+			return fmt.Errorf("index %s of table %s: %w", name, table, ErrNotFound)
+		}
+		t.Indexes = slices.DeleteFunc(slices.Clone(t.Indexes), func(other Index) bool { return other.ID == index.ID })
+		cat.Tables[table] = t
+		cat.drop(index.ID)
+		return nil
+	})
+}
+
+func (m *MinioGateway) updateIndexes(ctx context.Context, t Table, name string, old item.Item) {
+	if len(t.Indexes) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), indexUpdateTimeout)
+		defer cancel()
+		if err := m.syncIndexEntries(ctx, t, t.Indexes, name, old); err != nil {
+			indexUpdateFailures.Add(1)
+			slog.Error("Failed to update index entries",
+				slog.String("table", t.Name),
+				slog.String("object_name", name),
+				slog.String("error", err.Error()))
+		}
+	}()
+}
+
+// syncIndexEntries writes the entries of the item named name as it is now
+// and removes the ones of old that no longer apply.
+func (m *MinioGateway) syncIndexEntries(ctx context.Context, t Table, indexes []Index, name string, old item.Item) error {
+	var current item.Item
+	object, err := m.Get(ctx, name, GetOptions{Table: t.Name})
+	if err == nil {
+		current, err = decodeItem(ctx, object)
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	body, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, index := range indexes {
+		opts := PutOptions{Table: t.Name, index: index.Name, ExpiresAt: t.expiresAt(current)}
+		entry, indexed := index.entryName(name, current)
+		if indexed {
+			if _, err := m.write(ctx, entry, body, false, opts); err != nil {
+				errs = append(errs, fmt.Errorf("index %s: %w", index.Name, err))
+			}
+		}
+		if stale, ok := index.entryName(name, old); ok && (!indexed || stale != entry) {
+			if _, err := m.write(ctx, stale, nil, true, opts); err != nil && !errors.Is(err, ErrNotFound) {
+				errs = append(errs, fmt.Errorf("index %s: %w", index.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// BackfillIndexes writes the entries of the items of every table with
+// indexes being created, then marks the indexes active.
+func (m *MinioGateway) BackfillIndexes(ctx context.Context) error {
+	cat, err := m.readCatalog(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, name := range sortedKeys(cat.Tables) {
+		t := cat.Tables[name]
+		// Gateways with a catalog from before the index don't update it yet.
+		creating := slices.DeleteFunc(slices.Clone(t.Indexes), func(index Index) bool {
+			return index.Status != IndexCreating || time.Since(index.Created) < catalogTTL
+		})
+		if len(creating) == 0 {
+			continue
+		}
+		if err := m.backfill(ctx, t, creating); err != nil {
+			errs = append(errs, fmt.Errorf("table %s: %w", t.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *MinioGateway) backfill(ctx context.Context, t Table, indexes []Index) error {
+	names, err := m.listTable(ctx, t)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		err := m.syncIndexEntries(ctx, t, indexes, name, nil)
+		if errors.Is(err, ErrInvalidArgument) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = m.updateCatalog(ctx, func(cat *catalog) error {
+		current, ok := cat.Tables[t.Name]
+		if !ok || current.ID != t.ID {
+			return nil
+		}
+		current.Indexes = slices.Clone(current.Indexes)
+		for i, index := range current.Indexes {
+			if slices.ContainsFunc(indexes, func(done Index) bool { return done.ID == index.ID }) {
+				current.Indexes[i].Status = IndexActive
+			}
+		}
+		cat.Tables[t.Name] = current
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		slog.Info("Backfilled index",
+			slog.String("table", t.Name),
+			slog.String("index", index.Name),
+			slog.Int("items", len(names)))
+	}
+	return nil
+}
+
+func (m *MinioGateway) listTable(ctx context.Context, t Table) ([]string, error) {
+	prefix := t.key("")
+	seen := make(map[string]bool)
+	for _, node := range m.healthyNodes(mapValues(m.topology().nodes)) {
+		for object := range node.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+			if object.Err != nil {
+				return nil, fmt.Errorf("%w: listing node %s: %w", ErrNodeUnavailable, node.ID, object.Err)
+			}
+			seen[strings.TrimPrefix(object.Key, prefix)] = true
+		}
+	}
+	return sortedKeys(seen), nil
+}
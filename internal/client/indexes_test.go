@@ -0,0 +1,63 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vrnvu/go-dynamolike/internal/item"
+	"github.com/vrnvu/go-dynamolike/internal/partition"
+)
+
+func TestIndexEntries(t *testing.T) {
+	table := Table{Name: "orders", ID: "1234"}
+	index := Index{
+		Name:         "by-status",
+		ID:           "5678",
+		PartitionKey: KeyAttribute{Name: "status", Type: "S"},
+		SortKey:      &KeyAttribute{Name: "created", Type: "S"},
+		Status:       IndexActive,
+	}
+	table.Indexes = []Index{index}
+	order := item.Item{"id": item.String("order-1"), "status": item.String("open"), "created": item.String("2024-01-01")}
+
+	entry, ok := index.entryName("order-1", order)
+	require.True(t, ok)
+	assert.Equal(t, index.key("open"), placementKey(index.key(entry)), "entries are placed by the index partition key")
+
+	space, err := queryKeySpace(table, "by-status")
+	require.NoError(t, err)
+	parsed, err := space.parseEntry(entry, entry[len(partition.SortKeyPrefix("open")):])
+	require.NoError(t, err)
+	assert.Equal(t, "order-1", parsed.base)
+	assert.True(t, item.String("2024-01-01").Equal(parsed.sortKey))
+
+	_, ok = index.entryName("order-2", item.Item{"id": item.String("order-2")})
+	assert.False(t, ok, "items without the index key are left out")
+}
+
+func TestQueryKeySpaceRejectsIndexesBeingBackfilled(t *testing.T) {
+	table := Table{Name: "orders", Indexes: []Index{{Name: "by-status", Status: IndexCreating}}}
+
+	_, err := queryKeySpace(table, "by-status")
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	_, err = queryKeySpace(table, "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCatalogMergeKeepsIndexes(t *testing.T) {
+	now := time.Now()
+	creating := Index{Name: "a", ID: "1", Status: IndexCreating}
+	a := catalog{Tables: map[string]Table{"users": {Name: "users", ID: "t", Created: now, Indexes: []Index{creating}}}}
+	active := creating
+	active.Status = IndexActive
+	b := catalog{
+		Tables:  map[string]Table{"users": {Name: "users", ID: "t", Created: now, Indexes: []Index{active, {Name: "b", ID: "2"}}}},
+		Dropped: []string{"2"},
+	}
+
+	a.merge(b)
+
+	assert.Equal(t, []Index{active}, a.Tables["users"].Indexes)
+}
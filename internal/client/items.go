@@ -0,0 +1,354 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/vrnvu/go-dynamolike/internal/item"
+	"github.com/vrnvu/go-dynamolike/internal/partition"
+)
+
+// GetItemOptions configure GetItem.
+type GetItemOptions struct {
+	Consistency Consistency
+	Projection  string
+	Params      item.Params
+}
+
+// WriteItemOptions configure PutItem, UpdateItem and DeleteItem.
+type WriteItemOptions struct {
+	Consistency Consistency
+	Condition   string
+	Params      item.Params
+}
+
+func keySegment(attr KeyAttribute, v item.Value) (string, error) {
+	switch {
+	case attr.Type == "S" && v.Kind() == item.KindString && v.Text() != "",
+		attr.Type == "N" && v.Kind() == item.KindNumber:
+		return url.PathEscape(v.Text()), nil
+	case attr.Type == "B" && v.Kind() == item.KindBinary && len(v.Bytes()) > 0:
+		return base64.RawURLEncoding.EncodeToString(v.Bytes()), nil
+	default:
+		return "", fmt.Errorf("%w: key attribute %s must be a non-empty %s value", ErrInvalidArgument, attr.Name, attr.Type)
+	}
+}
+
+const numberExponentBias = 500
+
+// sortSegment encodes a sort key attribute for object names so that names
+// sort like the values do.
+func sortSegment(attr KeyAttribute, v item.Value) (string, error) {
+	if _, err := keySegment(attr, v); err != nil {
+		return "", err
+	}
+	switch attr.Type {
+	case "S":
+		return hex.EncodeToString([]byte(v.Text())), nil
+	case "B":
+		return hex.EncodeToString(v.Bytes()), nil
+	default:
+		return numberSegment(v.Text()), nil
+	}
+}
+
+// numberSegment encodes a number as 0.digits×10^exponent, so that encoded
+// numbers sort like the numbers.
+func numberSegment(text string) string {
+	integer, fraction, _ := strings.Cut(strings.TrimPrefix(text, "-"), ".")
+	digits := strings.TrimLeft(integer+fraction, "0")
+	if digits == "" {
+		return "1"
+	}
+	exponent := len(integer) - (len(integer) + len(fraction) - len(digits))
+	digits = strings.TrimRight(digits, "0")
+	if !strings.HasPrefix(text, "-") {
+		return fmt.Sprintf("2%03d%s", exponent+numberExponentBias, digits)
+	}
+	return fmt.Sprintf("0%03d%s~", 999-exponent-numberExponentBias, complementDigits(digits))
+}
+
+func complementDigits(digits string) string {
+	complement := []byte(digits)
+	for i, d := range complement {
+		complement[i] = '9' - d + '0'
+	}
+	return string(complement)
+}
+
+func parseSortSegment(attr KeyAttribute, segment string) (item.Value, error) {
+	switch attr.Type {
+	case "S":
+		b, err := hex.DecodeString(segment)
+		return item.String(string(b)), err
+	case "B":
+		b, err := hex.DecodeString(segment)
+		return item.Binary(b), err
+	default:
+		text, err := parseNumberSegment(segment)
+		if err != nil {
+			return item.Value{}, err
+		}
+		return item.Number(text)
+	}
+}
+
+func parseNumberSegment(segment string) (string, error) {
+	if segment == "1" {
+		return "0", nil
+	}
+	if len(segment) < 5 {
+		return "", fmt.Errorf("invalid number segment %q", segment)
+	}
+	exponent, err := strconv.Atoi(segment[1:4])
+	if err != nil {
+		return "", fmt.Errorf("invalid number segment %q: %w", segment, err)
+	}
+	digits, sign := segment[4:], ""
+	switch segment[0] {
+	case '2':
+		exponent -= numberExponentBias
+	case '0':
+		var ok bool
+		if digits, ok = strings.CutSuffix(digits, "~"); !ok {
+			return "", fmt.Errorf("invalid number segment %q", segment)
+		}
+		exponent = 999 - exponent - numberExponentBias
+		digits, sign = complementDigits(digits), "-"
+	default:
+		return "", fmt.Errorf("invalid number segment %q", segment)
+	}
+	switch {
+	case exponent <= 0:
+		return sign + "0." + strings.Repeat("0", -exponent) + digits, nil
+	case exponent >= len(digits):
+		return sign + digits + strings.Repeat("0", exponent-len(digits)), nil
+	default:
+		return sign + digits[:exponent] + "." + digits[exponent:], nil
+	}
+}
+
+// itemName returns the object name of the item of t with the key attributes
+// of it.
+func itemName(t Table, it item.Item) (string, error) {
+	attrs := t.keyAttributes()
+	name, err := keySegment(attrs[0], it[attrs[0].Name])
+	if err != nil || len(attrs) == 1 {
+		return name, err
+	}
+	sortKey, err := sortSegment(attrs[1], it[attrs[1].Name])
+	if err != nil {
+		return "", err
+	}
+	return partition.CompositeKey(name, sortKey), nil
+}
+
+func keyName(t Table, key item.Item) (string, error) {
+	if attrs := t.keyAttributes(); len(key) != len(attrs) {
+		return "", fmt.Errorf("%w: key of table %s must have %d attributes, got %d", ErrInvalidArgument, t.Name, len(attrs), len(key))
+	}
+	return itemName(t, key)
+}
+
+func keyOf(t Table, it item.Item) item.Item {
+	key := make(item.Item)
+	for _, attr := range t.keyAttributes() {
+		key[attr.Name] = it[attr.Name]
+	}
+	return key
+}
+
+// decodeItem returns the item stored in object, nil when there is none. Of
+// concurrent versions the newest one wins.
+func decodeItem(ctx context.Context, object *Object) (item.Item, error) {
+	if object == nil {
+		return nil, nil
+	}
+	data, err := object.Siblings[0].read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var it item.Item
+	if err := json.Unmarshal(data, &it); err != nil {
+		return nil, fmt.Errorf("%w: object %s is not an item: %w", ErrInvalidArgument, object.Name, err)
+	}
+	return it, nil
+}
+
+func parseCondition(opts WriteItemOptions) (*item.Condition, error) {
+	condition, err := item.ParseCondition(opts.Condition, opts.Params)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+	}
+	return condition, nil
+}
+
+func checkItemCondition(condition *item.Condition, current item.Item) error {
+	if !condition.Holds(current) {
+		return fmt.Errorf("%w: condition check failed", ErrPreconditionFailed)
+	}
+	return nil
+}
+
+// PutItem replaces the item of table with the same key as it.
+func (m *MinioGateway) PutItem(ctx context.Context, table string, it item.Item, opts WriteItemOptions) error {
+	t, err := m.DescribeTable(ctx, table)
+	if err != nil {
+		return err
+	}
+	name, err := itemName(t, it)
+	if err != nil {
+		return err
+	}
+	condition, err := parseCondition(opts)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(it)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+	}
+
+	putOpts := PutOptions{Consistency: opts.Consistency, Table: table, ExpiresAt: t.expiresAt(it)}
+	if opts.Condition == "" && len(t.Indexes) == 0 {
+		_, err = m.write(ctx, name, body, false, putOpts)
+		return err
+	}
+	var old item.Item
+	_, err = m.readModifyWrite(ctx, name, putOpts, func(object *Object, _ *PutOptions) ([]byte, bool, error) {
+		var err error
+		if old, err = decodeItem(ctx, object); err != nil {
+			return nil, false, err
+		}
+		return body, false, checkItemCondition(condition, old)
+	})
+	if err != nil {
+		return err
+	}
+	m.updateIndexes(ctx, t, name, old)
+	return nil
+}
+
+// GetItem returns the item of table with the given key.
+func (m *MinioGateway) GetItem(ctx context.Context, table string, key item.Item, opts GetItemOptions) (item.Item, error) {
+	t, err := m.DescribeTable(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	name, err := keyName(t, key)
+	if err != nil {
+		return nil, err
+	}
+	projection, err := item.ParseProjection(opts.Projection, opts.Params)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+	}
+	object, err := m.Get(ctx, name, GetOptions{Consistency: opts.Consistency, Table: table})
+	if err != nil {
+		return nil, err
+	}
+	it, err := decodeItem(ctx, object)
+	if err != nil {
+		return nil, err
+	}
+	return projection.Apply(it), nil
+}
+
+// UpdateItem applies an update expression to the item of table with the
+// given key, creating it when it doesn't exist, and returns the updated item.
+func (m *MinioGateway) UpdateItem(ctx context.Context, table string, key item.Item, expr string, opts WriteItemOptions) (item.Item, error) {
+	update, err := item.ParseUpdate(expr, opts.Params)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+	}
+	t, err := m.DescribeTable(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	name, err := keyName(t, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, attr := range t.keyAttributes() {
+		if update.Touches(attr.Name) {
+			return nil, fmt.Errorf("%w: key attribute %s can't be updated", ErrInvalidArgument, attr.Name)
+		}
+	}
+	condition, err := parseCondition(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var old, updated item.Item
+	putOpts := PutOptions{Consistency: opts.Consistency, Table: table}
+	_, err = m.readModifyWrite(ctx, name, putOpts, func(object *Object, writeOpts *PutOptions) ([]byte, bool, error) {
+		var err error
+		if old, err = decodeItem(ctx, object); err != nil {
+			return nil, false, err
+		}
+		if err := checkItemCondition(condition, old); err != nil {
+			return nil, false, err
+		}
+		if updated, err = update.Apply(old); err != nil {
+			return nil, false, fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+		}
+		for name, v := range key {
+			updated[name] = v
+		}
+		writeOpts.ExpiresAt = t.expiresAt(updated)
+		body, err := json.Marshal(updated)
+		return body, false, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.updateIndexes(ctx, t, name, old)
+	return updated, nil
+}
+
+// DeleteItem deletes the item of table with the given key.
+func (m *MinioGateway) DeleteItem(ctx context.Context, table string, key item.Item, opts WriteItemOptions) error {
+	t, err := m.DescribeTable(ctx, table)
+	if err != nil {
+		return err
+	}
+	name, err := keyName(t, key)
+	if err != nil {
+		return err
+	}
+	condition, err := parseCondition(opts)
+	if err != nil {
+		return err
+	}
+
+	putOpts := PutOptions{Consistency: opts.Consistency, Table: table}
+	if opts.Condition == "" && len(t.Indexes) == 0 {
+		_, err = m.write(ctx, name, nil, true, putOpts)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	var old item.Item
+	_, err = m.readModifyWrite(ctx, name, putOpts, func(object *Object, _ *PutOptions) ([]byte, bool, error) {
+		var err error
+		if old, err = decodeItem(ctx, object); err != nil {
+			return nil, false, err
+		}
+		return nil, true, checkItemCondition(condition, old)
+	})
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		m.updateIndexes(ctx, t, name, old)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vrnvu/go-dynamolike/internal/item"
+	"github.com/vrnvu/go-dynamolike/internal/partition"
+)
+
+func TestItemName(t *testing.T) {
+	table := Table{Name: "users"}
+
+	name, err := itemName(table, item.Item{"id": item.String("orders/#42"), "name": item.String("alice")})
+	assert.NoError(t, err)
+	assert.Equal(t, "orders%2F%2342", name, "keys must map to a single path segment")
+
+	_, err = itemName(table, item.Item{"id": item.String("")})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	_, err = itemName(table, item.Item{"id": item.Bool(true)})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	_, err = keyName(table, item.Item{"id": item.String("a"), "name": item.String("alice")})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestCompositeItemNamesShareThePartition(t *testing.T) {
+	table := Table{
+		Name:         "orders",
+		ID:           "1234",
+		PartitionKey: KeyAttribute{Name: "customer", Type: "S"},
+		SortKey:      &KeyAttribute{Name: "total", Type: "N"},
+	}
+	total, err := item.Number("10.5")
+	require.NoError(t, err)
+
+	name, err := keyName(table, item.Item{"customer": item.String("alice"), "total": total})
+	require.NoError(t, err)
+
+	assert.Equal(t, table.key("alice"), placementKey(table.key(name)))
+	sortKey, err := parseSortSegment(*table.SortKey, name[len(partition.SortKeyPrefix("alice")):])
+	require.NoError(t, err)
+	assert.True(t, total.Equal(sortKey))
+}
+
+func TestPlacementKeyKeepsPlainObjectNamesWhole(t *testing.T) {
+	assert.Equal(t, "report#2024", placementKey("report#2024"))
+	assert.Equal(t, ".dynamolike/tables/1234/alice", placementKey(".dynamolike/tables/1234/alice#10"))
+}
+
+func TestUpdateItemRejectsInvalidUpdates(t *testing.T) {
+	gateway := &MinioGateway{}
+	gateway.catalog.store(catalog{Tables: map[string]Table{"users": {Name: "users", ID: "1234"}}})
+	ctx := context.Background()
+	key := item.Item{"id": item.String("alice")}
+
+	_, err := gateway.UpdateItem(ctx, "users", key, "SET id = :other", WriteItemOptions{
+		Params: item.Params{Values: map[string]item.Value{":other": item.String("bob")}},
+	})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+
+	_, err = gateway.UpdateItem(ctx, "users", key, "SET visits = :missing", WriteItemOptions{})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestQueryRequiresPartitionKeyEquality(t *testing.T) {
+	gateway := &MinioGateway{}
+	gateway.catalog.store(catalog{Tables: map[string]Table{"users": {Name: "users", ID: "1234"}}})
+	params := item.Params{Values: map[string]item.Value{":a": item.String("alice")}}
+
+	for _, expr := range []string{"id > :a", "name = :a", "id = :a AND name = :a"} {
+		_, err := gateway.Query(context.Background(), "users", QueryOptions{KeyCondition: expr, Params: params})
+		assert.ErrorIs(t, err, ErrInvalidArgument, expr)
+	}
+}
+
+func TestSortSegmentsSortLikeTheirValues(t *testing.T) {
+	tests := map[string][]item.Value{
+		"N": {},
+		"S": {item.String("a"), item.String("a b"), item.String("a!"), item.String("a$"), item.String("ab"), item.String("z"), item.String("é")},
+		"B": {item.Binary([]byte{0}), item.Binary([]byte{0, 0}), item.Binary([]byte{1}), item.Binary([]byte{255})},
+	}
+	for _, text := range []string{"-1e20", "-1000", "-12.5", "-12.25", "-1.5", "-1", "-0.5", "-0.05", "0", "0.001", "0.5", "1", "1.5", "9", "10", "12.25", "12.5", "100", "1e20"} {
+		n, err := item.Number(text)
+		require.NoError(t, err)
+		tests["N"] = append(tests["N"], n)
+	}
+
+	for kind, values := range tests {
+		attr := KeyAttribute{Name: "sk", Type: kind}
+		previous := ""
+		for i, v := range values {
+			segment, err := sortSegment(attr, v)
+			require.NoError(t, err)
+			if i > 0 {
+				assert.Less(t, previous, segment, "%s value %d sorts after the one before", kind, i)
+			}
+			previous = segment
+
+			parsed, err := parseSortSegment(attr, segment)
+			require.NoError(t, err)
+			assert.True(t, v.Equal(parsed), "%s value %d is decoded back", kind, i)
+		}
+	}
+}
+
+// newSortedGateway returns a gateway with a table keyed by customer and
+// created, holding the orders of alice created from 0 to n-1.
+func newSortedGateway(t *testing.T, n int) (*MinioGateway, *fakeS3) {
+	t.Helper()
+	node, s3 := newFakeNode(t, "a")
+	gateway := newFakeGateway(node)
+	table := Table{
+		Name: "orders", ID: "1234", ReplicationFactor: 1, ReadQuorum: 1, WriteQuorum: 1,
+		PartitionKey: KeyAttribute{Name: "customer", Type: "S"},
+		SortKey:      &KeyAttribute{Name: "created", Type: "N"},
+	}
+	gateway.catalog.store(catalog{Tables: map[string]Table{"orders": table}})
+	for i := range n {
+		created, err := item.Number(strconv.Itoa(i))
+		require.NoError(t, err)
+		err = gateway.PutItem(context.Background(), "orders", item.Item{"customer": item.String("alice"), "created": created}, WriteItemOptions{})
+		require.NoError(t, err)
+	}
+	return gateway, s3
+}
+
+func createdOf(t *testing.T, items []item.Item) []string {
+	t.Helper()
+	var created []string
+	for _, it := range items {
+		created = append(created, it["created"].Text())
+	}
+	return created
+}
+
+func TestQueryReadsOnlyTheItemsOfThePage(t *testing.T) {
+	gateway, s3 := newSortedGateway(t, 12)
+	var mu sync.Mutex
+	read := make(map[string]bool)
+	s3.Before = func(method, key string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if method == http.MethodHead && strings.HasPrefix(key, tablesPrefix) {
+			read[key] = true
+		}
+	}
+	two, err := item.Number("2")
+	require.NoError(t, err)
+	opts := QueryOptions{
+		KeyCondition: "customer = :c AND created >= :from",
+		Params:       item.Params{Values: map[string]item.Value{":c": item.String("alice"), ":from": two}},
+		Limit:        3,
+	}
+
+	page, err := gateway.Query(context.Background(), "orders", opts)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2", "3", "4"}, createdOf(t, page.Items), "numbers sort by value, not as text")
+	require.NotNil(t, page.LastEvaluatedKey)
+	mu.Lock()
+	assert.Len(t, read, 3)
+	mu.Unlock()
+
+	opts.ExclusiveStartKey = page.LastEvaluatedKey
+	page, err = gateway.Query(context.Background(), "orders", opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5", "6", "7"}, createdOf(t, page.Items))
+}
+
+func TestQueryDescendingSkipsDeletedItems(t *testing.T) {
+	gateway, _ := newSortedGateway(t, 12)
+	ten, err := item.Number("10")
+	require.NoError(t, err)
+	err = gateway.DeleteItem(context.Background(), "orders", item.Item{"customer": item.String("alice"), "created": ten}, WriteItemOptions{})
+	require.NoError(t, err)
+	opts := QueryOptions{
+		KeyCondition: "customer = :c",
+		Params:       item.Params{Values: map[string]item.Value{":c": item.String("alice")}},
+		Descending:   true,
+		Limit:        3,
+	}
+
+	page, err := gateway.Query(context.Background(), "orders", opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"11", "9", "8"}, createdOf(t, page.Items))
+
+	opts.ExclusiveStartKey = page.LastEvaluatedKey
+	page, err = gateway.Query(context.Background(), "orders", opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"7", "6", "5"}, createdOf(t, page.Items))
+}
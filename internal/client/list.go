@@ -0,0 +1,184 @@
+package client
+
+import (
+	"container/heap"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	defaultListLimit = 1000
+	maxListLimit     = 1000
+)
+
+type ListOptions struct {
+	Prefix            string
+	StartAfter        string
+	ContinuationToken string
+	Limit             int
+}
+
+type ObjectSummary struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+type ListResult struct {
+	Objects           []ObjectSummary `json:"objects"`
+	ContinuationToken string          `json:"continuation_token,omitempty"`
+}
+
+// List returns the objects of every node in name order, keeping the most
+// recently written copy of objects stored on several replicas. Deleted and
+// expired objects are hidden. Listing is eventually consistent.
+func (m *MinioGateway) List(ctx context.Context, opts ListOptions) (ListResult, error) {
+	limit := opts.Limit
+	switch {
+	case limit < 0 || limit > maxListLimit:
+		return ListResult{}, fmt.Errorf("%w: limit %d, expected between 1 and %d", ErrInvalidArgument, limit, maxListLimit)
+	case limit == 0:
+		limit = defaultListLimit
+	}
+	startAfter := opts.StartAfter
+	if opts.ContinuationToken != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(opts.ContinuationToken)
+		if err != nil {
+			return ListResult{}, fmt.Errorf("%w: continuation token: %w", ErrInvalidArgument, err)
+		}
+		startAfter = string(decoded)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	now := time.Now()
+	var cursors listHeap
+	for _, node := range m.healthyNodes(mapValues(m.topology().nodes)) {
+		cursor := &listCursor{
+			node: node,
+			objects: node.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+				Prefix:       opts.Prefix,
+				StartAfter:   startAfter,
+				Recursive:    true,
+				WithMetadata: true,
+			}),
+		}
+		if err := cursor.next(); err != nil {
+			return ListResult{}, err
+		}
+		if !cursor.done {
+			cursors = append(cursors, cursor)
+		}
+	}
+	heap.Init(&cursors)
+
+	result := ListResult{Objects: []ObjectSummary{}}
+	for cursors.Len() > 0 {
+		name := cursors[0].head.Key
+		var newest *listCursor
+		var newestInfo minio.ObjectInfo
+		for cursors.Len() > 0 && cursors[0].head.Key == name {
+			cursor := cursors[0]
+			if newest == nil || cursor.head.LastModified.After(newestInfo.LastModified) {
+				newest, newestInfo = cursor, cursor.head
+			}
+			if err := cursor.next(); err != nil {
+				return ListResult{}, err
+			}
+			if cursor.done {
+				heap.Pop(&cursors)
+			} else {
+				heap.Fix(&cursors, 0)
+			}
+		}
+
+		if IsReserved(name) {
+			continue
+		}
+		if len(result.Objects) == limit {
+			last := result.Objects[limit-1].Name
+			result.ContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+			break
+		}
+		if expiresAt, ok := listedExpiry(newestInfo); ok && !now.Before(expiresAt) {
+			continue
+		}
+		deleted, err := newest.node.isTombstone(ctx, newestInfo)
+		if err != nil {
+			return ListResult{}, err
+		}
+		if deleted {
+			continue
+		}
+		result.Objects = append(result.Objects, ObjectSummary{
+			Name:         name,
+			Size:         newestInfo.Size,
+			ETag:         newestInfo.ETag,
+			LastModified: newestInfo.LastModified,
+		})
+	}
+	return result, nil
+}
+
+// isTombstone reports whether a listed object is a tombstone. Only objects
+// without a body are looked up.
+func (m *MinioNode) isTombstone(ctx context.Context, info minio.ObjectInfo) (bool, error) {
+	if info.Size != 0 {
+		return false, nil
+	}
+	v, err := m.statVersion(ctx, info.Key)
+	if isNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("node %s: %w", m.ID, nodeError(err))
+	}
+	return v.Tombstone, nil
+}
+
+type listCursor struct {
+	node    *MinioNode
+	objects <-chan minio.ObjectInfo
+	head    minio.ObjectInfo
+	done    bool
+}
+
+func (c *listCursor) next() error {
+	object, ok := <-c.objects
+	if !ok {
+		c.done = true
+		return nil
+	}
+	if object.Err != nil {
+		return fmt.Errorf("%w: listing node %s: %w", ErrNodeUnavailable, c.node.ID, object.Err)
+	}
+	c.head = object
+	return nil
+}
+
+type listHeap []*listCursor
+
+func (h listHeap) Len() int           { return len(h) }
+func (h listHeap) Less(i, j int) bool { return h[i].head.Key < h[j].head.Key }
+func (h listHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *listHeap) Push(x any)        { *h = append(*h, x.(*listCursor)) }
+func (h *listHeap) Pop() any {
+	old := *h
+	cursor := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return cursor
+}
+
+func mapValues[K comparable, V any](m map[K]V) []V {
+	values := make([]V, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	return values
+}
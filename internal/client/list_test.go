@@ -0,0 +1,105 @@
+package client
+
+import (
+	"container/heap"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestListHeapOrdersByNextName(t *testing.T) {
+	h := listHeap{
+		{head: minio.ObjectInfo{Key: "c"}},
+		{head: minio.ObjectInfo{Key: "a"}},
+		{head: minio.ObjectInfo{Key: "b"}},
+	}
+	heap.Init(&h)
+
+	var names []string
+	for h.Len() > 0 {
+		names = append(names, heap.Pop(&h).(*listCursor).head.Key)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, names)
+}
+
+func TestListRejectsInvalidOptions(t *testing.T) {
+	gateway := &MinioGateway{}
+	gateway.topo.Store(&topology{})
+
+	_, err := gateway.List(context.Background(), ListOptions{Limit: maxListLimit + 1})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+
+	_, err = gateway.List(context.Background(), ListOptions{ContinuationToken: "not base64!"})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+
+	result, err := gateway.List(context.Background(), ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Objects)
+}
+
+func TestListMergesNodesKeepingTheNewestCopy(t *testing.T) {
+	a, s3a := newFakeNode(t, "a")
+	b, s3b := newFakeNode(t, "b")
+	gateway := newFakeGateway(a, b)
+	now := time.Now()
+	s3a.put("apple", []byte("old"), VersionMeta{Clock: vclock.Clock{"g": 1}}, now.Add(-time.Minute))
+	s3b.put("apple", []byte("newer"), VersionMeta{Clock: vclock.Clock{"g": 2}}, now)
+	s3a.put("banana", []byte("a"), VersionMeta{Clock: vclock.Clock{"g": 1}}, now)
+	s3b.put("cherry", []byte("b"), VersionMeta{Clock: vclock.Clock{"g": 1}}, now)
+
+	result, err := gateway.List(context.Background(), ListOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"apple", "banana", "cherry"}, listedNames(result))
+	assert.Equal(t, int64(len("newer")), result.Objects[0].Size)
+	assert.Empty(t, result.ContinuationToken)
+}
+
+func TestListHidesDeletedAndExpiredObjects(t *testing.T) {
+	node, s3 := newFakeNode(t, "a")
+	gateway := newFakeGateway(node)
+	now := time.Now()
+	s3.put("deleted", nil, VersionMeta{Clock: vclock.Clock{"g": 1}, Tombstone: true}, now)
+	s3.put("expired", []byte("v"), VersionMeta{Clock: vclock.Clock{"g": 1}, ExpiresAt: now.Add(-time.Minute)}, now)
+	s3.put("expiring", []byte("v"), VersionMeta{Clock: vclock.Clock{"g": 1}, ExpiresAt: now.Add(time.Hour)}, now)
+
+	result, err := gateway.List(context.Background(), ListOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"expiring"}, listedNames(result))
+}
+
+func TestListContinuesWhereThePageStopped(t *testing.T) {
+	a, s3a := newFakeNode(t, "a")
+	b, s3b := newFakeNode(t, "b")
+	gateway := newFakeGateway(a, b)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		s3a.put(name, []byte(name), VersionMeta{Clock: vclock.Clock{"g": 1}}, time.Now())
+		s3b.put(name, []byte(name), VersionMeta{Clock: vclock.Clock{"g": 1}}, time.Now())
+	}
+
+	var names []string
+	opts := ListOptions{Limit: 2}
+	for {
+		result, err := gateway.List(context.Background(), opts)
+		assert.NoError(t, err)
+		names = append(names, listedNames(result)...)
+		if result.ContinuationToken == "" {
+			break
+		}
+		opts.ContinuationToken = result.ContinuationToken
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, names)
+}
+
+func listedNames(result ListResult) []string {
+	var names []string
+	for _, object := range result.Objects {
+		names = append(names, object.Name)
+	}
+	return names
+}
@@ -0,0 +1,196 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/vrnvu/go-dynamolike/internal/discovery"
+	"github.com/vrnvu/go-dynamolike/internal/partition"
+)
+
+// PartitionerFactory builds the partitioner for a set of node IDs.
+type PartitionerFactory func(nodeIDs []string) partition.Partitioner
+
+// topology is the set of nodes and the partitioner placing keys on them. It
+// is replaced as a whole when membership changes.
+type topology struct {
+	nodes       map[string]*MinioNode
+	partitioner partition.Partitioner
+}
+
+func (m *MinioGateway) topology() *topology {
+	return m.topo.Load()
+}
+
+func (t *topology) preferenceList(objectName string, n int) ([]*MinioNode, error) {
+	nodeIDs := t.partitioner.PreferenceList(placementKey(objectName), n)
+	nodes := make([]*MinioNode, 0, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		node, ok := t.nodes[nodeID]
+		if !ok {
+			slog.Error("Minio node not found",
+				slog.String("node_id", nodeID),
+				slog.String("object_name", objectName),
+				slog.Any("available_nodes", sortedKeys(t.nodes)))
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("%w: no nodes found for object %s", ErrNodeUnavailable, objectName)
+	}
+	return nodes, nil
+}
+
+// placementKey returns the key objectName is placed on the ring by, the
+// partition key for items, index entries and stream records.
+func placementKey(objectName string) string {
+	if strings.HasPrefix(objectName, tablesPrefix) || strings.HasPrefix(objectName, streamsPrefix) {
+		return partition.PartitionKey(objectName)
+	}
+	return objectName
+}
+
+func (t *topology) fallbackNodes(objectName string, preferred []*MinioNode) []*MinioNode {
+	skip := make(map[*MinioNode]bool, len(preferred))
+	for _, node := range preferred {
+		skip[node] = true
+	}
+	var nodes []*MinioNode
+	for _, nodeID := range t.partitioner.PreferenceList(placementKey(objectName), len(t.nodes)) {
+		if node, ok := t.nodes[nodeID]; ok && !skip[node] {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// buildNodes returns a node per registry instance keyed by node ID, reusing
+// the nodes in current that still point at the same address. A node ID
+// claimed by several instances goes to the smallest instance ID.
+func buildNodes(ctx context.Context, instances []discovery.MinioInstance, current map[string]*MinioNode, init func(context.Context, *MinioNode) error) (map[string]*MinioNode, error) {
+	instances = slices.Clone(instances)
+	slices.SortFunc(instances, func(a, b discovery.MinioInstance) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	nodes := make(map[string]*MinioNode, len(instances))
+	claimed := make(map[string]bool, len(instances))
+	var errs []error
+	for _, instance := range instances {
+		if instance.NodeID == "" {
+			slog.Error("Minio instance has no node ID", slog.String("instance_id", instance.ID))
+			continue
+		}
+		if claimed[instance.NodeID] {
+			slog.Error("Duplicated Minio node ID",
+				slog.String("node_id", instance.NodeID),
+				slog.String("instance_id", instance.ID))
+			continue
+		}
+		claimed[instance.NodeID] = true
+		if node, ok := current[instance.NodeID]; ok && node.instanceID == instance.ID && node.endpoint == endpoint(instance) {
+			nodes[instance.NodeID] = node
+			continue
+		}
+
+		node, err := New(
+			ctx,
+			MinioNodeConfig{
+				NodeID:          instance.NodeID,
+				InstanceID:      instance.ID,
+				IPAddress:       instance.IP,
+				ContainerPort:   instance.ContainerPort,
+				AccessKeyID:     instance.User,
+				SecretAccessKey: instance.Password,
+				UseSSL:          false,
+			})
+		if err == nil && init != nil {
+			err = init(ctx, node)
+		}
+		if err != nil {
+			slog.Error("Failed to create Minio node",
+				slog.String("node_id", instance.NodeID),
+				slog.String("error", err.Error()))
+			errs = append(errs, fmt.Errorf("node %s: %w", instance.NodeID, err))
+			continue
+		}
+		nodes[instance.NodeID] = node
+	}
+	return nodes, errors.Join(errs...)
+}
+
+func endpoint(instance discovery.MinioInstance) string {
+	return fmt.Sprintf("%s:%s", instance.IP, instance.ContainerPort)
+}
+
+func sameNodes(a, b map[string]*MinioNode) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id, node := range a {
+		if b[id] != node {
+			return false
+		}
+	}
+	return true
+}
+
+func sameNodeIDs(a, b map[string]*MinioNode) bool {
+	return len(missingFrom(a, b)) == 0 && len(missingFrom(b, a)) == 0
+}
+
+// Refresh rebuilds the topology from the registry and swaps it in
+// atomically.
+func (m *MinioGateway) Refresh(ctx context.Context) error {
+	m.membership.Lock()
+	defer m.membership.Unlock()
+
+	current := m.topology()
+	nodes, err := buildNodes(ctx, m.registry.GetInstances(), current.nodes, func(ctx context.Context, node *MinioNode) error {
+		return node.createBucket(ctx)
+	})
+	if sameNodes(current.nodes, nodes) {
+		return err
+	}
+	if len(nodes) == 0 {
+		return errors.Join(err, fmt.Errorf("refusing to drop every Minio node"))
+	}
+
+	m.topo.Store(&topology{nodes: nodes, partitioner: m.newPartitioner(sortedKeys(nodes))})
+	for id := range nodes {
+		if _, ok := current.nodes[id]; !ok {
+			slog.Info("Minio node joined", slog.String("node_id", id))
+		}
+	}
+	for id := range current.nodes {
+		if _, ok := nodes[id]; !ok {
+			slog.Info("Minio node left", slog.String("node_id", id))
+		}
+	}
+	return err
+}
+
+// WatchMembership refreshes the topology every time the registry reports a
+// change and rebalances data onto the new owners, until ctx is cancelled.
+func (m *MinioGateway) WatchMembership(ctx context.Context) {
+	changes := m.registry.Subscribe()
+	for {
+		previous := m.topology()
+		if err := m.Refresh(ctx); err != nil {
+			slog.Error("Failed to refresh membership", slog.String("error", err.Error()))
+		}
+		// Placement only depends on node IDs.
+		if next := m.topology(); next != previous && !sameNodeIDs(previous.nodes, next.nodes) {
+			m.startRebalance(ctx, previous, next)
+		}
+		select {
+		case <-changes:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
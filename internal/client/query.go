@@ -0,0 +1,395 @@
+package client
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/vrnvu/go-dynamolike/internal/item"
+	"github.com/vrnvu/go-dynamolike/internal/partition"
+)
+
+type QueryOptions struct {
+	Consistency Consistency
+	// Index names the index to query instead of the table. Such queries are
+	// eventually consistent.
+	Index string
+	// KeyCondition selects a partition key and optionally a range of sort
+	// keys, such as customer = :c AND created BETWEEN :from AND :to.
+	KeyCondition      string
+	Params            item.Params
+	Descending        bool
+	Limit             int
+	ExclusiveStartKey item.Item
+}
+
+type QueryResult struct {
+	Items            []item.Item `json:"items"`
+	LastEvaluatedKey item.Item   `json:"last_evaluated_key,omitempty"`
+}
+
+type keySpace struct {
+	table   Table
+	index   string
+	key     func(name string) string
+	keys    []KeyAttribute
+	sortKey *KeyAttribute
+}
+
+func queryKeySpace(t Table, indexName string) (keySpace, error) {
+	if indexName == "" {
+		keys := t.keyAttributes()
+		return keySpace{table: t, key: t.key, keys: keys, sortKey: t.SortKey}, nil
+	}
+	index, ok := t.index(indexName)
+	if !ok {
+		return keySpace{}, fmt.Errorf("index %s of table %s: %w", indexName, t.Name, ErrNotFound)
+	}
+	if index.Status != IndexActive {
+		return keySpace{}, fmt.Errorf("%w: index %s of table %s is being backfilled", ErrInvalidArgument, indexName, t.Name)
+	}
+	return keySpace{table: t, index: indexName, key: index.key, keys: index.keyAttributes(), sortKey: index.SortKey}, nil
+}
+
+type queryEntry struct {
+	name    string
+	sortKey item.Value
+	base    string
+}
+
+func (s keySpace) compare(a, b queryEntry) int {
+	c, _ := a.sortKey.Compare(b.sortKey)
+	return cmp.Or(c, strings.Compare(a.base, b.base))
+}
+
+func (s keySpace) startEntry(key item.Item) (queryEntry, error) {
+	base, err := itemName(s.table, key)
+	if err != nil {
+		return queryEntry{}, fmt.Errorf("exclusive start key: %w", err)
+	}
+	start := queryEntry{name: base, base: base}
+	if s.index != "" {
+		index, _ := s.table.index(s.index)
+		var ok bool
+		if start.name, ok = index.entryName(base, key); !ok {
+			return queryEntry{}, fmt.Errorf("%w: exclusive start key lacks the key attributes of index %s", ErrInvalidArgument, s.index)
+		}
+	}
+	if s.sortKey != nil {
+		start.sortKey = key[s.sortKey.Name]
+	}
+	return start, nil
+}
+
+func (s keySpace) lastKey(it item.Item) item.Item {
+	key := keyOf(s.table, it)
+	for _, attr := range s.keys {
+		key[attr.Name] = it[attr.Name]
+	}
+	return key
+}
+
+// Query returns the items of a partition in sort key order. Items are named
+// so that names sort like sort keys, so the replicas of the partition are
+// listed from the start key on until a page of items was read.
+func (m *MinioGateway) Query(ctx context.Context, table string, opts QueryOptions) (QueryResult, error) {
+	limit := opts.Limit
+	switch {
+	case limit < 0 || limit > maxListLimit:
+		return QueryResult{}, fmt.Errorf("%w: limit %d, expected between 1 and %d", ErrInvalidArgument, limit, maxListLimit)
+	case limit == 0:
+		limit = defaultListLimit
+	}
+	condition, err := item.ParseKeyCondition(opts.KeyCondition, opts.Params)
+	if err != nil {
+		return QueryResult{}, fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+	}
+	t, err := m.DescribeTable(ctx, table)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	space, err := queryKeySpace(t, opts.Index)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	partitionKey := space.keys[0]
+	partitionValue, ok := condition.Equals(partitionKey.Name)
+	if !ok {
+		return QueryResult{}, fmt.Errorf("%w: key condition must be an equality on partition key %s", ErrInvalidArgument, partitionKey.Name)
+	}
+	for _, name := range condition.Keys() {
+		if name != partitionKey.Name && (space.sortKey == nil || name != space.sortKey.Name) {
+			return QueryResult{}, fmt.Errorf("%w: %s is not a key attribute of %s", ErrInvalidArgument, name, cmp.Or(opts.Index, t.Name))
+		}
+	}
+	partitionSegment, err := keySegment(partitionKey, partitionValue)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	result := QueryResult{Items: []item.Item{}}
+	if space.index == "" && space.sortKey == nil {
+		if opts.ExclusiveStartKey != nil {
+			return result, nil
+		}
+		it, err := m.GetItem(ctx, table, item.Item{partitionKey.Name: partitionValue}, GetItemOptions{Consistency: opts.Consistency})
+		if errors.Is(err, ErrNotFound) {
+			return result, nil
+		}
+		if err != nil {
+			return QueryResult{}, err
+		}
+		result.Items = append(result.Items, it)
+		return result, nil
+	}
+
+	var start *queryEntry
+	startAfter := ""
+	if opts.ExclusiveStartKey != nil {
+		entry, err := space.startEntry(opts.ExclusiveStartKey)
+		if err != nil {
+			return QueryResult{}, err
+		}
+		start = &entry
+		if !opts.Descending {
+			startAfter = space.key(entry.name)
+		}
+	}
+	matches := func(e queryEntry) bool {
+		if space.sortKey != nil && !condition.Matches(space.sortKey.Name, e.sortKey) {
+			return false
+		}
+		return start == nil || !opts.Descending || space.compare(e, *start) < 0
+	}
+
+	listing, err := m.listPartition(ctx, space, partitionSegment, opts.Consistency, startAfter, matches)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	defer listing.close()
+	next := listing.next
+	if opts.Descending {
+		var entries []queryEntry
+		for {
+			entry, ok, err := listing.next()
+			if err != nil {
+				return QueryResult{}, err
+			}
+			if !ok {
+				break
+			}
+			entries = append(entries, entry)
+		}
+		slices.Reverse(entries)
+		next = func() (queryEntry, bool, error) {
+			if len(entries) == 0 {
+				return queryEntry{}, false, nil
+			}
+			entry := entries[0]
+			entries = entries[1:]
+			return entry, true, nil
+		}
+	}
+
+	for {
+		var page []queryEntry
+		for len(page) < limit-len(result.Items) {
+			entry, ok, err := next()
+			if err != nil {
+				return QueryResult{}, err
+			}
+			if !ok {
+				break
+			}
+			page = append(page, entry)
+		}
+		if len(page) == 0 {
+			return result, nil
+		}
+		items, err := m.readEntries(ctx, space, page, opts.Consistency)
+		if err != nil {
+			return QueryResult{}, err
+		}
+		result.Items = append(result.Items, items...)
+		if len(result.Items) == limit {
+			_, more, err := next()
+			if err != nil {
+				return QueryResult{}, err
+			}
+			if more {
+				result.LastEvaluatedKey = space.lastKey(result.Items[limit-1])
+			}
+			return result, nil
+		}
+	}
+}
+
+// readEntries reads the items of entries in order, leaving out the ones
+// deleted since they were listed.
+func (m *MinioGateway) readEntries(ctx context.Context, space keySpace, entries []queryEntry, consistency Consistency) ([]item.Item, error) {
+	items := make([]item.Item, len(entries))
+	errs := make([]error, len(entries))
+	slots := make(chan struct{}, batchParallelism)
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			object, err := m.Get(ctx, entry.name, GetOptions{Consistency: consistency, Table: space.table.Name, index: space.index})
+			if err == nil {
+				items[i], err = decodeItem(ctx, object)
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	var found []item.Item
+	for i, it := range items {
+		switch {
+		case errors.Is(errs[i], ErrNotFound):
+		case errs[i] != nil:
+			return nil, errs[i]
+		default:
+			found = append(found, it)
+		}
+	}
+	return found, nil
+}
+
+func (s keySpace) parseEntry(name, rest string) (queryEntry, error) {
+	entry := queryEntry{name: name, base: name}
+	if s.index != "" {
+		entry.base = rest
+	}
+	if s.sortKey == nil {
+		return entry, nil
+	}
+	segment := rest
+	if s.index != "" {
+		var ok bool
+		if segment, entry.base, ok = strings.Cut(rest, baseKeySeparator); !ok {
+			return entry, fmt.Errorf("entry %s has no item name", name)
+		}
+	}
+	var err error
+	entry.sortKey, err = parseSortSegment(*s.sortKey, segment)
+	return entry, err
+}
+
+// partitionListing merges the listings of the replicas of a partition in
+// sort key order.
+type partitionListing struct {
+	space     keySpace
+	partition string
+	prefix    string
+	cursors   listHeap
+	cancel    context.CancelFunc
+	matches   func(queryEntry) bool
+	matched   bool
+	nodes     int
+	r         int
+	errs      []error
+}
+
+func (m *MinioGateway) listPartition(ctx context.Context, space keySpace, partitionSegment string, consistency Consistency, startAfter string, matches func(queryEntry) bool) (*partitionListing, error) {
+	prefix := space.key(partition.SortKeyPrefix(partitionSegment))
+	rep := space.table.replication()
+	nodes, err := m.topology().preferenceList(prefix, rep.factor)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	l := &partitionListing{
+		space:     space,
+		partition: partitionSegment,
+		prefix:    prefix,
+		cancel:    cancel,
+		matches:   matches,
+		nodes:     len(nodes),
+		r:         consistency.replicas(len(nodes), rep.read),
+	}
+	for _, node := range nodes {
+		cursor := &listCursor{
+			node: node,
+			objects: node.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+				Prefix:     prefix,
+				StartAfter: startAfter,
+				Recursive:  true,
+			}),
+		}
+		if err := cursor.next(); err != nil {
+			l.errs = append(l.errs, err)
+			continue
+		}
+		if !cursor.done {
+			l.cursors = append(l.cursors, cursor)
+		}
+	}
+	heap.Init(&l.cursors)
+	if err := l.quorum(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *partitionListing) next() (queryEntry, bool, error) {
+	for l.cursors.Len() > 0 {
+		key := l.cursors[0].head.Key
+		for l.cursors.Len() > 0 && l.cursors[0].head.Key == key {
+			cursor := l.cursors[0]
+			err := cursor.next()
+			switch {
+			case err != nil:
+				l.errs = append(l.errs, err)
+				heap.Pop(&l.cursors)
+			case cursor.done:
+				heap.Pop(&l.cursors)
+			default:
+				heap.Fix(&l.cursors, 0)
+			}
+		}
+		if err := l.quorum(); err != nil {
+			return queryEntry{}, false, err
+		}
+
+		name := strings.TrimPrefix(key, l.space.key(""))
+		entry, err := l.space.parseEntry(name, strings.TrimPrefix(key, l.prefix))
+		if err != nil {
+			continue
+		}
+		if !l.matches(entry) {
+			if l.matched {
+				l.close()
+				l.cursors = nil
+				return queryEntry{}, false, nil
+			}
+			continue
+		}
+		l.matched = true
+		return entry, true, nil
+	}
+	return queryEntry{}, false, nil
+}
+
+func (l *partitionListing) quorum() error {
+	if answered := l.nodes - len(l.errs); answered < l.r {
+		return fmt.Errorf("%w: read quorum not met listing partition %s: %d of %d replicas answered: %w",
+			ErrNodeUnavailable, l.partition, answered, l.r, errors.Join(l.errs...))
+	}
+	return nil
+}
+
+func (l *partitionListing) close() {
+	l.cancel()
+}
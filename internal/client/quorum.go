@@ -0,0 +1,92 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+// Consistency selects how many replicas a single request waits for,
+// overriding the gateway's default R and W quorums.
+type Consistency int
+
+const (
+	ConsistencyDefault Consistency = iota
+	ConsistencyOne
+	ConsistencyQuorum
+	ConsistencyAll
+)
+
+func (c Consistency) String() string {
+	switch c {
+	case ConsistencyOne:
+		return "one"
+	case ConsistencyQuorum:
+		return "quorum"
+	case ConsistencyAll:
+		return "all"
+	default:
+		return "default"
+	}
+}
+
+// ParseConsistency parses the values accepted by the X-Consistency header.
+// An empty string selects the gateway defaults.
+func ParseConsistency(s string) (Consistency, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return ConsistencyDefault, nil
+	case "one":
+		return ConsistencyOne, nil
+	case "quorum":
+		return ConsistencyQuorum, nil
+	case "all":
+		return ConsistencyAll, nil
+	default:
+		return ConsistencyDefault, fmt.Errorf("%w: consistency level %q, expected one, quorum or all", ErrInvalidArgument, s)
+	}
+}
+
+func (c Consistency) replicas(n, defaultQuorum int) int {
+	switch c {
+	case ConsistencyOne:
+		return 1
+	case ConsistencyQuorum:
+		return majority(n)
+	case ConsistencyAll:
+		return n
+	default:
+		return min(defaultQuorum, n)
+	}
+}
+
+func majority(n int) int {
+	return n/2 + 1
+}
+
+type GetOptions struct {
+	Consistency Consistency
+	Table       string
+	index       string
+}
+
+type PutOptions struct {
+	Consistency Consistency
+	Table       string
+	index       string
+	// Context is the clock the client read before writing, nil for a blind write.
+	Context      vclock.Clock
+	version      vclock.Clock
+	UserMetadata map[string]string
+	ExpiresAt    time.Time
+	IfNoneMatch  bool
+	// IfMatch is the ETag or version ID of the single version to replace.
+	IfMatch    string
+	ifSiblings []string
+}
+
+func (o PutOptions) conditional() bool {
+	return o.IfNoneMatch || o.IfMatch != "" || o.ifSiblings != nil
+}
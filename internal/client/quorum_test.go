@@ -0,0 +1,31 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConsistency(t *testing.T) {
+	for input, expected := range map[string]Consistency{
+		"":       ConsistencyDefault,
+		"one":    ConsistencyOne,
+		"QUORUM": ConsistencyQuorum,
+		" all ":  ConsistencyAll,
+	} {
+		c, err := ParseConsistency(input)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, c, "input %q", input)
+	}
+
+	_, err := ParseConsistency("eventual")
+	assert.Error(t, err)
+}
+
+func TestConsistencyReplicas(t *testing.T) {
+	assert.Equal(t, 1, ConsistencyOne.replicas(3, 2))
+	assert.Equal(t, 2, ConsistencyQuorum.replicas(3, 1))
+	assert.Equal(t, 3, ConsistencyAll.replicas(3, 2))
+	assert.Equal(t, 2, ConsistencyDefault.replicas(3, 2))
+	assert.Equal(t, 2, ConsistencyDefault.replicas(2, 3), "default quorum is capped by available replicas")
+}
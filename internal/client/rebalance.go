@@ -0,0 +1,305 @@
+package client
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var rebalanceCopies = expvar.NewMap("rebalance_copies")
+
+const rebalancePasses = 3
+
+// RangeProgress describes the handoff of one key range to its new owners.
+type RangeProgress struct {
+	Range string `json:"range"`
+	Keys  int    `json:"keys"`
+	Moved int    `json:"moved"`
+	Done  bool   `json:"done"`
+}
+
+type RebalanceStatus struct {
+	Active   bool            `json:"active"`
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	Joined   []string        `json:"joined"`
+	Left     []string        `json:"left"`
+	Failed   int             `json:"failed"`
+	Ranges   []RangeProgress `json:"ranges"`
+}
+
+// rebalance moves the objects stored on nodes that are no longer among their
+// owners after a membership change.
+type rebalance struct {
+	previous *topology
+	next     *topology
+	cancel   context.CancelFunc
+	finished chan struct{}
+
+	mu     sync.RWMutex
+	status RebalanceStatus
+	ranges map[string]*RangeProgress
+}
+
+type rebalancer struct {
+	mu      sync.Mutex
+	current *rebalance
+}
+
+type misplaced struct {
+	objectName string
+	sources    []*MinioNode
+}
+
+// RebalanceStatus returns the progress of the last rebalance.
+func (m *MinioGateway) RebalanceStatus() RebalanceStatus {
+	m.rebalancer.mu.Lock()
+	r := m.rebalancer.current
+	m.rebalancer.mu.Unlock()
+	if r == nil {
+		return RebalanceStatus{}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	status := r.status
+	status.Ranges = make([]RangeProgress, 0, len(r.ranges))
+	for _, name := range sortedKeys(r.ranges) {
+		status.Ranges = append(status.Ranges, *r.ranges[name])
+	}
+	return status
+}
+
+// startRebalance moves data from previous to next in the background,
+// cancelling a rebalance still running first.
+func (m *MinioGateway) startRebalance(ctx context.Context, previous, next *topology) {
+	m.rebalancer.mu.Lock()
+	defer m.rebalancer.mu.Unlock()
+	running := m.rebalancer.current
+	if running != nil {
+		running.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &rebalance{
+		previous: previous,
+		next:     next,
+		cancel:   cancel,
+		finished: make(chan struct{}),
+		ranges:   make(map[string]*RangeProgress),
+		status: RebalanceStatus{
+			Active:  true,
+			Started: time.Now(),
+			Joined:  missingFrom(next.nodes, previous.nodes),
+			Left:    missingFrom(previous.nodes, next.nodes),
+		},
+	}
+	m.rebalancer.current = r
+	go func() {
+		if running != nil {
+			<-running.finished
+		}
+		m.runRebalance(ctx, r)
+	}()
+}
+
+func missingFrom(nodes, other map[string]*MinioNode) []string {
+	var missing []string
+	for _, id := range sortedKeys(nodes) {
+		if _, ok := other[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+func (m *MinioGateway) runRebalance(ctx context.Context, r *rebalance) {
+	defer close(r.finished)
+	defer r.cancel()
+	slog.Info("Rebalance started", slog.Any("joined", r.status.Joined), slog.Any("left", r.status.Left))
+
+	// Gateways that didn't see the membership change yet write to the old
+	// owners, so nodes are scanned until one scan finds nothing left.
+	for range rebalancePasses {
+		ranges := m.findMisplaced(ctx, r)
+		if len(ranges) == 0 {
+			break
+		}
+		for _, name := range sortedKeys(ranges) {
+			for _, object := range ranges[name] {
+				if ctx.Err() != nil {
+					break
+				}
+				if m.moveObject(ctx, r.next, object) {
+					r.mu.Lock()
+					r.ranges[name].Moved++
+					r.mu.Unlock()
+				} else {
+					r.mu.Lock()
+					r.status.Failed++
+					r.mu.Unlock()
+				}
+			}
+			r.mu.Lock()
+			r.ranges[name].Done = ctx.Err() == nil
+			r.mu.Unlock()
+		}
+	}
+
+	r.mu.Lock()
+	r.status.Active = false
+	r.status.Finished = time.Now()
+	failed := r.status.Failed
+	r.mu.Unlock()
+	slog.Info("Rebalance finished", slog.Int("failed", failed), slog.Bool("cancelled", ctx.Err() != nil))
+}
+
+func (m *MinioGateway) findMisplaced(ctx context.Context, r *rebalance) map[string][]*misplaced {
+	nodes := make(map[string]*MinioNode, len(r.previous.nodes)+len(r.next.nodes))
+	for id, node := range r.previous.nodes {
+		nodes[id] = node
+	}
+	for id, node := range r.next.nodes {
+		nodes[id] = node
+	}
+
+	ranges := make(map[string][]*misplaced)
+	objects := make(map[string]*misplaced)
+	for _, id := range sortedKeys(nodes) {
+		node := nodes[id]
+		for object := range node.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Recursive: true}) {
+			if object.Err != nil {
+				slog.Warn("Failed to list node for rebalance",
+					slog.String("node_id", node.ID),
+					slog.String("error", object.Err.Error()))
+				break
+			}
+			if nodeLocal(object.Key) {
+				continue
+			}
+			objectName := objectNameOf(object.Key)
+			owners, err := m.replicasOf(r.next, objectName)
+			if err != nil || slices.Contains(owners, node) {
+				continue
+			}
+
+			mp, ok := objects[objectName]
+			if !ok {
+				mp = &misplaced{objectName: objectName}
+				objects[objectName] = mp
+				ranges[owners[0].ID] = append(ranges[owners[0].ID], mp)
+			}
+			if !slices.Contains(mp.sources, node) {
+				mp.sources = append(mp.sources, node)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	for name, objects := range ranges {
+		progress, ok := r.ranges[name]
+		if !ok {
+			progress = &RangeProgress{Range: name}
+			r.ranges[name] = progress
+		}
+		progress.Keys += len(objects)
+		progress.Done = false
+	}
+	r.mu.Unlock()
+	return ranges
+}
+
+// moveObject copies the versions of an object held by its old owners to its
+// new owners and deletes them from the old owners once every new owner has
+// all of them.
+func (m *MinioGateway) moveObject(ctx context.Context, next *topology, object *misplaced) bool {
+	owners, err := m.replicasOf(next, object.objectName)
+	if err != nil {
+		return false
+	}
+
+	for range maxCASAttempts {
+		var sources, targets []versionsReply
+		for _, nodes := range [][]*MinioNode{object.sources, owners} {
+			for _, node := range nodes {
+				versions, err := node.Versions(ctx, object.objectName)
+				if err != nil {
+					slog.Warn("Failed to read replica for rebalance",
+						slog.String("node_id", node.ID),
+						slog.String("object_name", object.objectName),
+						slog.String("error", err.Error()))
+					if slices.Contains(owners, node) {
+						return false
+					}
+					continue
+				}
+				reply := versionsReply{node: node, versions: versions}
+				if slices.Contains(owners, node) {
+					targets = append(targets, reply)
+				} else if len(versions) > 0 {
+					sources = append(sources, reply)
+				}
+			}
+		}
+		if len(sources) == 0 {
+			return true
+		}
+
+		siblings := reconcile(collectVersions(append(sources, targets...)))
+		missing := 0
+		for _, reply := range targets {
+			missing += len(missingVersions(reply, siblings))
+		}
+		if m.repair(ctx, object.objectName, siblings, targets, rebalanceCopies) < missing {
+			return false
+		}
+
+		for _, reply := range sources {
+			for _, version := range reply.versions {
+				if _, err := reply.node.removeVersion(ctx, version); err != nil {
+					slog.Warn("Failed to remove moved object",
+						slog.String("node_id", reply.node.ID),
+						slog.String("key", version.Info.Key),
+						slog.String("error", err.Error()))
+				}
+			}
+		}
+	}
+	return false
+}
+
+// previousOwners returns the owners of objectName before the running
+// rebalance that are not in nodes, until its key range was handed off.
+func (m *MinioGateway) previousOwners(objectName string, nodes []*MinioNode) []*MinioNode {
+	m.rebalancer.mu.Lock()
+	r := m.rebalancer.current
+	m.rebalancer.mu.Unlock()
+	if r == nil || len(nodes) == 0 {
+		return nil
+	}
+
+	r.mu.RLock()
+	progress, ok := r.ranges[nodes[0].ID]
+	inProgress := r.status.Active && (!ok || !progress.Done)
+	r.mu.RUnlock()
+	if !inProgress {
+		return nil
+	}
+
+	previous, err := m.replicasOf(r.previous, objectName)
+	if err != nil {
+		return nil
+	}
+	var owners []*MinioNode
+	for _, node := range previous {
+		if !slices.ContainsFunc(nodes, func(n *MinioNode) bool { return n.ID == node.ID }) {
+			owners = append(owners, node)
+		}
+	}
+	return owners
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestPreviousOwnersUntilRangeIsHandedOff(t *testing.T) {
+	a, b, c := &MinioNode{ID: "a"}, &MinioNode{ID: "b"}, &MinioNode{ID: "c"}
+	previousPartitioner := new(mockPartitioner)
+	previousPartitioner.On("PreferenceList", "key", 2).Return([]string{"a", "b"})
+	previous := &topology{nodes: map[string]*MinioNode{"a": a, "b": b}, partitioner: previousPartitioner}
+
+	gateway := &MinioGateway{replicationFactor: 2}
+	assert.Nil(t, gateway.previousOwners("key", []*MinioNode{c, a}), "no rebalance, no fallback")
+
+	r := &rebalance{
+		previous: previous,
+		status:   RebalanceStatus{Active: true},
+		ranges:   map[string]*RangeProgress{"c": {Range: "c", Keys: 1}},
+	}
+	gateway.rebalancer.current = r
+
+	assert.Equal(t, []*MinioNode{b}, gateway.previousOwners("key", []*MinioNode{c, a}))
+
+	r.ranges["c"].Done = true
+	assert.Nil(t, gateway.previousOwners("key", []*MinioNode{c, a}))
+
+	status := gateway.RebalanceStatus()
+	assert.True(t, status.Active)
+	assert.Equal(t, []RangeProgress{{Range: "c", Keys: 1, Done: true}}, status.Ranges)
+}
+
+func TestStartRebalanceDoesntBlockReadsWhileTheRunningOneStops(t *testing.T) {
+	node, _ := newFakeNode(t, "a")
+	gateway := newFakeGateway(node)
+	cancelled := make(chan struct{})
+	running := &rebalance{cancel: func() { close(cancelled) }, finished: make(chan struct{}), ranges: map[string]*RangeProgress{}}
+	gateway.rebalancer.current = running
+	topo := gateway.topology()
+
+	started := make(chan struct{})
+	go func() {
+		gateway.startRebalance(context.Background(), topo, topo)
+		close(started)
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("startRebalance waits for the running rebalance")
+	}
+	<-cancelled
+	assert.Nil(t, gateway.previousOwners("key", []*MinioNode{node}))
+
+	close(running.finished)
+	select {
+	case <-gateway.rebalancer.current.finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the new rebalance never ran")
+	}
+	assert.False(t, gateway.RebalanceStatus().Active)
+}
+
+func TestMissingFrom(t *testing.T) {
+	previous := map[string]*MinioNode{"a": nil, "b": nil}
+	next := map[string]*MinioNode{"b": nil, "c": nil}
+
+	assert.Equal(t, []string{"c"}, missingFrom(next, previous))
+	assert.Equal(t, []string{"a"}, missingFrom(previous, next))
+}
+
+func TestMoveObjectKeepsWritesLandingOnTheOldOwner(t *testing.T) {
+	source, sourceS3 := newFakeNode(t, "a")
+	owner, ownerS3 := newFakeNode(t, "b")
+	gateway := newFakeGateway(owner)
+	sourceS3.put("key", []byte("v1"), VersionMeta{Clock: vclock.Clock{"g": 1}}, time.Now())
+
+	// A gateway that didn't see the membership change writes to the old
+	// owner while the object is copied to the new one.
+	var once sync.Once
+	ownerS3.Before = func(method, key string) {
+		if method == "PUT" {
+			once.Do(func() {
+				sourceS3.put("key", []byte("v2"), VersionMeta{Clock: vclock.Clock{"g": 2}}, time.Now())
+			})
+		}
+	}
+
+	moved := gateway.moveObject(context.Background(), gateway.topology(), &misplaced{objectName: "key", sources: []*MinioNode{source}})
+
+	assert.True(t, moved)
+	assert.Empty(t, sourceS3.Keys())
+	versions, err := owner.Versions(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+	body, err := versions[0].read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", string(body))
+}
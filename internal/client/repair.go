@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"expvar"
+	"io"
+	"log/slog"
+	"time"
+)
+
+const readRepairTimeout = 30 * time.Second
+
+var readRepairs = expvar.NewMap("read_repairs")
+
+// versionPayload is a version streamed from the node holding it.
+type versionPayload struct {
+	version Version
+}
+
+func (p versionPayload) open(ctx context.Context) (io.ReadCloser, error) {
+	body, err := p.version.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (p versionPayload) size() int64 { return p.version.Info.Size }
+
+func (p versionPayload) release(context.Context, bool) {}
+
+func replicate(ctx context.Context, objectName string, version Version, dst *MinioNode) error {
+	_, err := dst.putVersion(ctx, objectName, versionPayload{version: version}, version.VersionMeta, false)
+	return err
+}
+
+func missingVersions(reply versionsReply, siblings []Version) []Version {
+	held := make(map[string]bool, len(reply.versions))
+	for _, v := range reply.versions {
+		held[v.ID()] = true
+	}
+	var missing []Version
+	for _, sibling := range siblings {
+		if !held[sibling.ID()] {
+			missing = append(missing, sibling)
+		}
+	}
+	return missing
+}
+
+// repair writes the reconciled siblings back to every replica in replies
+// that lacks one of them.
+func (m *MinioGateway) repair(ctx context.Context, objectName string, siblings []Version, replies []versionsReply, repairs *expvar.Map) int {
+	repaired := 0
+	for _, reply := range replies {
+		for _, version := range missingVersions(reply, siblings) {
+			if err := replicate(ctx, objectName, version, reply.node); err != nil {
+				slog.Warn("Failed to repair replica",
+					slog.String("node_id", reply.node.ID),
+					slog.String("object_name", objectName),
+					slog.String("version", version.ID()),
+					slog.String("error", err.Error()))
+				continue
+			}
+			repaired++
+			repairs.Add(reply.node.ID, 1)
+			slog.Info("Repaired replica",
+				slog.String("node_id", reply.node.ID),
+				slog.String("object_name", objectName),
+				slog.String("version", version.ID()))
+		}
+	}
+	return repaired
+}
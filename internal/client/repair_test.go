@@ -0,0 +1,54 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestMissingVersions(t *testing.T) {
+	now := time.Now()
+	stale := version("key", vclock.Clock{"a": 1}, now.Add(-time.Minute))
+	left := version("key", vclock.Clock{"a": 2}, now)
+	right := version(siblingKey("key", vclock.Clock{"b": 1}), vclock.Clock{"b": 1}, now)
+	siblings := []Version{left, right}
+
+	assert.Equal(t, siblings, missingVersions(versionsReply{}, siblings), "a replica without the object misses every sibling")
+	assert.Equal(t, siblings, missingVersions(versionsReply{versions: []Version{stale}}, siblings))
+	assert.Equal(t, []Version{right}, missingVersions(versionsReply{versions: []Version{left}}, siblings))
+	assert.Empty(t, missingVersions(versionsReply{versions: []Version{right, left}}, siblings))
+}
+
+func TestReplicateStreamsLargeVersions(t *testing.T) {
+	source, sourceS3 := newFakeNode(t, "a")
+	dst, dstS3 := newFakeNode(t, "b")
+	body := bytes.Repeat([]byte("x"), 3*maxBufferedBody)
+	sourceS3.put("key", body, VersionMeta{Clock: vclock.Clock{"g": 1}}, time.Now())
+
+	// A buffered copy reads the whole version before writing any of it.
+	var servedAtFirstWrite int64
+	var once sync.Once
+	dstS3.Before = func(method, key string) {
+		if method == "PUT" {
+			once.Do(func() { servedAtFirstWrite = sourceS3.Served.Load() })
+		}
+	}
+
+	version, err := source.statVersion(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.NoError(t, replicate(context.Background(), "key", version, dst))
+
+	assert.Less(t, servedAtFirstWrite, int64(len(body)), "the version was read whole before being written")
+	versions, err := dst.Versions(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+	copied, err := versions[0].read(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(body, copied), "the copy differs from the version")
+	assert.Equal(t, vclock.Clock{"g": 1}, versions[0].Clock)
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// scanTask is a background task fed by a listing of every healthy node.
+type scanTask interface {
+	visit(ctx context.Context, node *MinioNode, object minio.ObjectInfo)
+	finish(ctx context.Context, listErr error) error
+}
+
+// RunMaintenance lists every healthy node once and runs anti-entropy,
+// tombstone collection, the expiry sweep and the collection of dropped
+// tables on what it found.
+func (m *MinioGateway) RunMaintenance(ctx context.Context) error {
+	topo := m.topology()
+	healthy := m.healthySet(topo)
+	tasks := []scanTask{
+		m.newAntiEntropyScan(topo, healthy),
+		m.newTombstoneScan(topo),
+		m.newExpiryScan(topo),
+	}
+	dropped, err := m.newDroppedTablesScan(ctx, topo, healthy)
+	if dropped != nil {
+		tasks = append(tasks, dropped)
+	}
+	return errors.Join(err, m.scanNodes(ctx, topo, healthy, tasks...))
+}
+
+func (m *MinioGateway) healthySet(topo *topology) map[*MinioNode]bool {
+	healthy := make(map[*MinioNode]bool, len(topo.nodes))
+	for _, node := range m.healthyNodes(mapValues(topo.nodes)) {
+		healthy[node] = true
+	}
+	return healthy
+}
+
+func (m *MinioGateway) scanNodes(ctx context.Context, topo *topology, healthy map[*MinioNode]bool, tasks ...scanTask) error {
+	var listErrs []error
+	for _, nodeID := range sortedKeys(topo.nodes) {
+		node := topo.nodes[nodeID]
+		if !healthy[node] {
+			continue
+		}
+		for object := range node.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Recursive: true, WithMetadata: true}) {
+			if object.Err != nil {
+				listErrs = append(listErrs, fmt.Errorf("node %s: %w", node.ID, object.Err))
+				break
+			}
+			for _, task := range tasks {
+				task.visit(ctx, node, object)
+			}
+		}
+	}
+
+	listErr := errors.Join(listErrs...)
+	errs := []error{listErr}
+	for _, task := range tasks {
+		errs = append(errs, task.finish(ctx, listErr))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestRunMaintenanceListsEachNodeOnce(t *testing.T) {
+	a, aS3 := newFakeNode(t, "a")
+	b, bS3 := newFakeNode(t, "b")
+	gateway := newFakeGateway(a, b)
+	now := time.Now()
+	aS3.put("diverged", []byte("v1"), VersionMeta{Clock: vclock.Clock{"g": 1}}, now)
+	for _, s3 := range []*fakeS3{aS3, bS3} {
+		s3.put("deleted", nil, VersionMeta{Clock: vclock.Clock{"g": 1}, Tombstone: true}, now.Add(-2*defaultTombstoneGrace))
+		s3.put("expired", []byte("v1"), VersionMeta{Clock: vclock.Clock{"g": 1}, ExpiresAt: now.Add(-time.Minute)}, now)
+	}
+
+	require.NoError(t, gateway.RunMaintenance(context.Background()))
+
+	assert.Equal(t, int64(1), aS3.Listed.Load())
+	assert.Equal(t, int64(1), bS3.Listed.Load())
+	assert.Equal(t, 1, gateway.AntiEntropyStatus().Repaired)
+	assert.True(t, bS3.Has("diverged"))
+	for _, node := range []*MinioNode{a, b} {
+		versions, err := node.Versions(context.Background(), "deleted")
+		require.NoError(t, err)
+		assert.Empty(t, versions, node.ID)
+
+		versions, err = node.Versions(context.Background(), "expired")
+		require.NoError(t, err)
+		require.Len(t, versions, 1)
+		assert.True(t, versions[0].Tombstone, node.ID)
+	}
+}
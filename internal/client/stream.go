@@ -0,0 +1,541 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/vrnvu/go-dynamolike/internal/partition"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+const (
+	// StreamShards is the number of shards of the change stream of a table.
+	// The changes of an item always go to the same shard.
+	StreamShards = 4
+
+	streamsPrefix        = reservedPrefix + "streams/"
+	pendingPrefix        = streamsPrefix + "pending/"
+	streamPendingTimeout = time.Minute
+	// streamRetention is how long change records are kept.
+	streamRetention = 24 * time.Hour
+	// streamGapTimeout is how long readers wait for a sequence number taken
+	// by a write still in flight before skipping it.
+	streamGapTimeout   = 10 * time.Second
+	streamPollInterval = 500 * time.Millisecond
+
+	defaultStreamLimit = 100
+	maxStreamWait      = 20 * time.Second
+)
+
+// Event names of change records, as in DynamoDB Streams.
+const (
+	EventInsert = "INSERT"
+	EventModify = "MODIFY"
+	EventRemove = "REMOVE"
+)
+
+var streamAppendFailures = expvar.NewInt("stream_append_failures")
+
+// pendingChange is the change a write of an item makes, recorded before the
+// write starts and cleared once its record was appended.
+type pendingChange struct {
+	ObjectName string       `json:"object_name"`
+	Clock      string       `json:"clock"`
+	Record     StreamRecord `json:"record"`
+
+	context vclock.Clock
+}
+
+// streamTableOf returns the ID of the table a stream record belongs to.
+func streamTableOf(objectName string) (string, bool) {
+	rest, ok := strings.CutPrefix(objectName, streamsPrefix)
+	if !ok || strings.HasPrefix(objectName, pendingPrefix) {
+		return "", false
+	}
+	id, _, ok := strings.Cut(rest, "/")
+	return id, ok
+}
+
+func pendingKey(clock vclock.Clock) string {
+	return pendingPrefix + clock.Version()
+}
+
+// StreamRecord is a change of an item of a table.
+type StreamRecord struct {
+	Sequence  uint64    `json:"sequence"`
+	Timestamp time.Time `json:"timestamp"`
+	EventName string    `json:"event_name"`
+	Key       string    `json:"key"`
+	// OldImages are the versions the write replaced.
+	OldImages []ImageRef `json:"old_images,omitempty"`
+	NewImage  *ImageRef  `json:"new_image,omitempty"`
+}
+
+// ImageRef identifies a version of an item.
+type ImageRef struct {
+	Version string `json:"version"`
+	ETag    string `json:"etag"`
+	Size    int64  `json:"size"`
+}
+
+type StreamOptions struct {
+	Shard int
+	After uint64
+	Limit int
+	Wait  time.Duration
+}
+
+type StreamPage struct {
+	Records []StreamRecord `json:"records"`
+	Next    uint64         `json:"next"`
+}
+
+// streamTails caches the last sequence number of each shard this gateway
+// reserved. Gateways racing for one are told apart by a conditional write.
+type streamTails struct {
+	mu     sync.Mutex
+	shards map[string]*shardTail
+}
+
+type shardTail struct {
+	mu       sync.Mutex
+	sequence uint64
+	known    bool
+}
+
+func (s *streamTails) shard(shardKey string) *shardTail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shards == nil {
+		s.shards = make(map[string]*shardTail)
+	}
+	tail, ok := s.shards[shardKey]
+	if !ok {
+		tail = &shardTail{}
+		s.shards[shardKey] = tail
+	}
+	return tail
+}
+
+func shardKey(t Table, shard int) string {
+	return streamsPrefix + t.ID + "/" + strconv.Itoa(shard)
+}
+
+func recordName(shardKey string, sequence uint64) string {
+	return partition.CompositeKey(shardKey, fmt.Sprintf("%020d", sequence))
+}
+
+func streamShard(itemName string) int {
+	h := fnv.New32a()
+	h.Write([]byte(partition.PartitionKey(itemName)))
+	return int(h.Sum32() % StreamShards)
+}
+
+func (m *MinioGateway) streamOf(objectName string) (Table, bool) {
+	t, ok := m.tableOf(objectName)
+	return t, ok && t.Stream
+}
+
+// markPending records that objectName is about to be written with meta.
+// A write is never acknowledged without its change being either appended
+// or left pending for FlushStreams.
+func (m *MinioGateway) markPending(ctx context.Context, t Table, objectName string, values []Version, meta VersionMeta) (*pendingChange, error) {
+	record := StreamRecord{Key: strings.TrimPrefix(objectName, t.key(""))}
+	for _, v := range values {
+		if meta.Clock.Compare(v.Clock) == vclock.After {
+			record.OldImages = append(record.OldImages, ImageRef{Version: v.ID(), ETag: v.Info.ETag, Size: v.Info.Size})
+		}
+	}
+	switch {
+	case meta.Tombstone:
+		record.EventName = EventRemove
+	case len(record.OldImages) == 0:
+		record.EventName = EventInsert
+	default:
+		record.EventName = EventModify
+	}
+	if !meta.Tombstone {
+		record.NewImage = &ImageRef{Version: meta.Clock.Version()}
+	}
+
+	change := &pendingChange{ObjectName: objectName, Clock: meta.Clock.Encode(), Record: record}
+	body, err := json.Marshal(change)
+	if err != nil {
+		return nil, err
+	}
+	result, err := m.write(ctx, pendingKey(meta.Clock), body, false, PutOptions{Consistency: ConsistencyQuorum})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record pending change of %s: %w", objectName, err)
+	}
+	change.context = result.Clock
+	return change, nil
+}
+
+// appendChange appends the record of a successful write and clears its
+// pending change. Failures are left for FlushStreams.
+func (m *MinioGateway) appendChange(ctx context.Context, t Table, change *pendingChange, info minio.UploadInfo) {
+	ctx = context.WithoutCancel(ctx)
+	change.Record.Timestamp = time.Now().UTC()
+	if change.Record.NewImage != nil {
+		change.Record.NewImage.ETag, change.Record.NewImage.Size = info.ETag, info.Size
+	}
+	if err := m.appendRecord(ctx, shardKey(t, streamShard(change.Record.Key)), &change.Record); err != nil {
+		streamAppendFailures.Add(1)
+		slog.Error("Failed to append change record, left pending",
+			slog.String("table", t.Name),
+			slog.String("object_name", change.ObjectName),
+			slog.String("error", err.Error()))
+		return
+	}
+	m.clearPending(ctx, change)
+}
+
+func (m *MinioGateway) clearPending(ctx context.Context, change *pendingChange) {
+	clock, err := vclock.Decode(change.Clock)
+	if err != nil {
+		return
+	}
+	_, err = m.write(ctx, pendingKey(clock), nil, true, PutOptions{Consistency: ConsistencyQuorum, Context: change.context})
+	if err != nil {
+		slog.Warn("Failed to clear pending change",
+			slog.String("object_name", change.ObjectName),
+			slog.String("error", err.Error()))
+	}
+}
+
+// FlushStreams appends the records of the changes left pending for longer
+// than streamPendingTimeout, when their write can be found on the item.
+// Records are appended at least once.
+func (m *MinioGateway) FlushStreams(ctx context.Context) error {
+	keys := make(map[string]bool)
+	var errs []error
+	for _, node := range m.healthyNodes(mapValues(m.topology().nodes)) {
+		for object := range node.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: pendingPrefix, Recursive: true}) {
+			if object.Err != nil {
+				errs = append(errs, fmt.Errorf("node %s: %w", node.ID, object.Err))
+				break
+			}
+			if object.Size > 0 && time.Since(object.LastModified) >= streamPendingTimeout {
+				keys[object.Key] = true
+			}
+		}
+	}
+	for _, key := range sortedKeys(keys) {
+		if err := m.flushChange(ctx, key); err != nil {
+			errs = append(errs, fmt.Errorf("pending change %s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *MinioGateway) flushChange(ctx context.Context, key string) error {
+	object, err := m.Get(ctx, key, GetOptions{Consistency: ConsistencyQuorum})
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var change pendingChange
+	if err := decodeVersion(ctx, object.Siblings[0], &change); err != nil {
+		return err
+	}
+	change.context = object.Context
+	clock, err := vclock.Decode(change.Clock)
+	if err != nil {
+		return err
+	}
+
+	t, streamed := m.streamOf(change.ObjectName)
+	written := false
+	if streamed {
+		siblings, err := m.readSiblings(ctx, change.ObjectName, GetOptions{Consistency: ConsistencyQuorum}, false)
+		if err != nil {
+			return err
+		}
+		for _, v := range siblings {
+			if !v.Clock.Descends(clock) {
+				continue
+			}
+			written = true
+			if v.Clock.Compare(clock) == vclock.Equal && change.Record.NewImage != nil {
+				change.Record.NewImage.ETag, change.Record.NewImage.Size = v.Info.ETag, v.Info.Size
+			}
+		}
+	}
+	if !written {
+		slog.Info("Clearing change of a write that didn't happen",
+			slog.String("object_name", change.ObjectName),
+			slog.String("version", clock.Version()))
+		m.clearPending(ctx, &change)
+		return nil
+	}
+
+	change.Record.Timestamp = time.Now().UTC()
+	if err := m.appendRecord(ctx, shardKey(t, streamShard(change.Record.Key)), &change.Record); err != nil {
+		return err
+	}
+	m.clearPending(ctx, &change)
+	return nil
+}
+
+// appendRecord writes record with the sequence number following the last
+// one of the shard, trying the next number when another gateway took it.
+func (m *MinioGateway) appendRecord(ctx context.Context, shardKey string, record *StreamRecord) error {
+	tail := m.streams.shard(shardKey)
+	for range maxCASAttempts {
+		sequence, err := m.nextSequence(ctx, shardKey, tail)
+		if err != nil {
+			return err
+		}
+		record.Sequence = sequence
+		body, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		_, err = m.write(ctx, recordName(shardKey, record.Sequence), body, false, PutOptions{IfNoneMatch: true})
+		if errors.Is(err, ErrPreconditionFailed) {
+			tail.forget(sequence)
+			continue
+		}
+		return err
+	}
+	return fmt.Errorf("%w: shard %s appended to concurrently %d times", ErrConflict, shardKey, maxCASAttempts)
+}
+
+// nextSequence reserves the sequence number following the tail of a shard,
+// listing the shard first when the tail is not known.
+func (m *MinioGateway) nextSequence(ctx context.Context, shardKey string, tail *shardTail) (uint64, error) {
+	tail.mu.Lock()
+	known, after := tail.known, tail.sequence
+	tail.mu.Unlock()
+	var last uint64
+	if !known {
+		sequences, err := m.listShard(ctx, shardKey, after, 0)
+		if err != nil {
+			return 0, err
+		}
+		if len(sequences) > 0 {
+			last = sequences[len(sequences)-1]
+		}
+	}
+
+	tail.mu.Lock()
+	defer tail.mu.Unlock()
+	if !known {
+		tail.sequence, tail.known = max(tail.sequence, last), true
+	}
+	tail.sequence++
+	return tail.sequence, nil
+}
+
+func (t *shardTail) forget(sequence uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sequence, t.known = max(t.sequence, sequence), false
+}
+
+// listShard returns the sequence numbers following after in a shard, as
+// listed by a read quorum of its replicas. A limit of zero lists them all.
+func (m *MinioGateway) listShard(ctx context.Context, shardKey string, after uint64, limit int) ([]uint64, error) {
+	prefix := partition.SortKeyPrefix(shardKey)
+	rep := m.defaultReplication()
+	nodes, err := m.topology().preferenceList(shardKey, rep.factor)
+	if err != nil {
+		return nil, err
+	}
+	r := min(rep.read, len(nodes))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type listReply struct {
+		node      *MinioNode
+		sequences []uint64
+		err       error
+	}
+	replies := make(chan listReply, len(nodes))
+	for _, node := range nodes {
+		go func() {
+			reply := listReply{node: node}
+			objects := node.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+				Prefix:     prefix,
+				StartAfter: recordName(shardKey, after),
+				Recursive:  true,
+			})
+			for object := range objects {
+				if object.Err != nil {
+					reply.err = nodeError(object.Err)
+					break
+				}
+				sequence, err := strconv.ParseUint(strings.TrimPrefix(object.Key, prefix), 10, 64)
+				if err != nil {
+					continue
+				}
+				reply.sequences = append(reply.sequences, sequence)
+				if len(reply.sequences) == limit {
+					break
+				}
+			}
+			replies <- reply
+		}()
+	}
+
+	var sequences []uint64
+	var errs []error
+	for range nodes {
+		reply := <-replies
+		if reply.err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", reply.node.ID, reply.err))
+			continue
+		}
+		sequences = append(sequences, reply.sequences...)
+	}
+	if answered := len(nodes) - len(errs); answered < r {
+		return nil, fmt.Errorf("%w: read quorum not met listing shard %s: %d of %d replicas answered: %w",
+			ErrNodeUnavailable, shardKey, answered, r, errors.Join(errs...))
+	}
+	slices.Sort(sequences)
+	sequences = slices.Compact(sequences)
+	if limit > 0 && len(sequences) > limit {
+		sequences = sequences[:limit]
+	}
+	return sequences, nil
+}
+
+// ReadStream returns the change records of a shard of the stream of table
+// following opts.After, waiting up to opts.Wait when there are none yet.
+func (m *MinioGateway) ReadStream(ctx context.Context, table string, opts StreamOptions) (StreamPage, error) {
+	limit := opts.Limit
+	switch {
+	case limit < 0 || limit > maxListLimit:
+		return StreamPage{}, fmt.Errorf("%w: limit %d, expected between 1 and %d", ErrInvalidArgument, limit, maxListLimit)
+	case limit == 0:
+		limit = defaultStreamLimit
+	}
+	if opts.Shard < 0 || opts.Shard >= StreamShards {
+		return StreamPage{}, fmt.Errorf("%w: shard %d, expected between 0 and %d", ErrInvalidArgument, opts.Shard, StreamShards-1)
+	}
+	if opts.Wait < 0 || opts.Wait > maxStreamWait {
+		return StreamPage{}, fmt.Errorf("%w: wait %s, expected at most %s", ErrInvalidArgument, opts.Wait, maxStreamWait)
+	}
+	t, err := m.DescribeTable(ctx, table)
+	if err != nil {
+		return StreamPage{}, err
+	}
+	if !t.Stream {
+		return StreamPage{}, fmt.Errorf("stream of table %s: %w", table, ErrNotFound)
+	}
+
+	key := shardKey(t, opts.Shard)
+	deadline := time.Now().Add(opts.Wait)
+	for {
+		page, err := m.readRecords(ctx, key, opts.After, limit)
+		if err != nil || len(page.Records) > 0 || time.Now().After(deadline) {
+			return page, err
+		}
+		select {
+		case <-time.After(streamPollInterval):
+		case <-ctx.Done():
+			return page, nil
+		}
+	}
+}
+
+func (m *MinioGateway) readRecords(ctx context.Context, shardKey string, after uint64, limit int) (StreamPage, error) {
+	page := StreamPage{Records: []StreamRecord{}, Next: after}
+	sequences, err := m.listShard(ctx, shardKey, after, limit)
+	if err != nil {
+		return page, err
+	}
+	for _, sequence := range sequences {
+		object, err := m.Get(ctx, recordName(shardKey, sequence), GetOptions{})
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return page, err
+		}
+		// A slot holding several records is waited for like a gap. Past the
+		// timeout, every reader keeps the one with the smallest version ID.
+		siblings := object.Siblings
+		if len(siblings) > 1 {
+			if time.Since(siblings[0].Info.LastModified) < streamGapTimeout {
+				break
+			}
+			siblings = slices.Clone(siblings)
+			slices.SortFunc(siblings, func(a, b Version) int { return strings.Compare(a.ID(), b.ID()) })
+			slog.Warn("Reading stream slot with concurrent records",
+				slog.String("shard", shardKey),
+				slog.Uint64("sequence", sequence),
+				slog.Int("records", len(siblings)))
+		}
+		var record StreamRecord
+		if err := decodeVersion(ctx, siblings[0], &record); err != nil {
+			return page, err
+		}
+		if sequence != page.Next+1 && time.Since(record.Timestamp) < streamGapTimeout {
+			break
+		}
+		page.Records = append(page.Records, record)
+		page.Next = sequence
+	}
+	return page, nil
+}
+
+// TrimStreams removes change records older than the retention period and
+// the records of dropped tables. The newest record of a shard is kept.
+func (m *MinioGateway) TrimStreams(ctx context.Context) error {
+	cat, err := m.readCatalog(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	topo := m.topology()
+	for _, node := range m.healthyNodes(mapValues(topo.nodes)) {
+		remove := func(key string) {
+			if err := node.minioClient.RemoveObject(ctx, bucketName, key, minio.RemoveObjectOptions{}); err != nil {
+				errs = append(errs, fmt.Errorf("node %s: %w", node.ID, err))
+			}
+		}
+		trim := func(record minio.ObjectInfo, newest bool) {
+			tableID, _, _ := strings.Cut(strings.TrimPrefix(record.Key, streamsPrefix), "/")
+			if slices.Contains(cat.Dropped, tableID) || (!newest && time.Since(record.LastModified) >= streamRetention) {
+				remove(record.Key)
+			}
+		}
+
+		var previous *minio.ObjectInfo
+		for object := range node.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: streamsPrefix, Recursive: true}) {
+			if object.Err != nil {
+				errs = append(errs, fmt.Errorf("node %s: %w", node.ID, object.Err))
+				previous = nil
+				break
+			}
+			if strings.HasPrefix(object.Key, pendingPrefix) {
+				if object.Size == 0 && time.Since(object.LastModified) >= streamRetention {
+					remove(object.Key)
+				}
+				continue
+			}
+			if previous != nil {
+				trim(*previous, partition.PartitionKey(previous.Key) != partition.PartitionKey(object.Key))
+			}
+			previous = &object
+		}
+		if previous != nil {
+			trim(*previous, true)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vrnvu/go-dynamolike/internal/partition"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestStreamShardKeepsPartitionsTogether(t *testing.T) {
+	for _, sortKey := range []string{"a", "b", "c"} {
+		assert.Equal(t, streamShard("alice"), streamShard(partition.CompositeKey("alice", sortKey)))
+	}
+	shard := streamShard("alice")
+	assert.True(t, shard >= 0 && shard < StreamShards)
+}
+
+func TestRecordNamesSortBySequence(t *testing.T) {
+	key := shardKey(Table{ID: "1234"}, 2)
+
+	assert.Less(t, recordName(key, 9), recordName(key, 10))
+	assert.Equal(t, key, placementKey(recordName(key, 9)), "records of a shard share replicas")
+}
+
+func TestStreamOfSkipsIndexes(t *testing.T) {
+	gateway := &MinioGateway{}
+	table := Table{Name: "orders", ID: "1234", Indexes: []Index{{Name: "by-status", ID: "5678"}}, Stream: true}
+	plain := Table{Name: "users", ID: "4321"}
+	gateway.catalog.store(catalog{Tables: map[string]Table{"orders": table, "users": plain}})
+
+	_, ok := gateway.streamOf(table.key("order-1"))
+	assert.True(t, ok)
+	_, ok = gateway.streamOf(table.Indexes[0].key("open#order-1"))
+	assert.False(t, ok)
+	_, ok = gateway.streamOf("order-1")
+	assert.False(t, ok)
+	_, ok = gateway.streamOf(plain.key("alice"))
+	assert.False(t, ok, "tables record their writes only when their stream is on")
+	_, ok = gateway.tableOf(plain.key("alice"))
+	assert.True(t, ok)
+}
+
+func TestReadStreamRejectsInvalidOptions(t *testing.T) {
+	gateway := &MinioGateway{}
+
+	for _, opts := range []StreamOptions{{Shard: StreamShards}, {Shard: -1}, {Limit: 1001}, {Wait: maxStreamWait + 1}} {
+		_, err := gateway.ReadStream(context.Background(), "orders", opts)
+		assert.ErrorIs(t, err, ErrInvalidArgument)
+	}
+}
+
+// newStreamedGateway returns a gateway on a single fake node with the table
+// orders in its catalog.
+func newStreamedGateway(t *testing.T) (*MinioGateway, *fakeS3, Table) {
+	node, s3 := newFakeNode(t, "a")
+	gateway := newFakeGateway(node)
+	table := Table{Name: "orders", ID: "1234", ReplicationFactor: 1, ReadQuorum: 1, WriteQuorum: 1, Stream: true}
+	gateway.catalog.store(catalog{Tables: map[string]Table{"orders": table}})
+	return gateway, s3, table
+}
+
+// readSlot decodes the record at sequence of the shard of key.
+func readSlot(t *testing.T, gateway *MinioGateway, table Table, key string, sequence uint64) (StreamRecord, bool) {
+	t.Helper()
+	object, err := gateway.Get(context.Background(), recordName(shardKey(table, streamShard(key)), sequence), GetOptions{})
+	if err != nil {
+		return StreamRecord{}, false
+	}
+	var record StreamRecord
+	require.NoError(t, decodeVersion(context.Background(), object.Siblings[0], &record))
+	return record, true
+}
+
+func assertCleared(t *testing.T, gateway *MinioGateway, clock vclock.Clock) {
+	t.Helper()
+	_, err := gateway.Get(context.Background(), pendingKey(clock), GetOptions{})
+	assert.ErrorIs(t, err, ErrNotFound, "pending change %s is cleared", clock.Version())
+}
+
+func TestWriteAppendsItsChangeAndClearsIt(t *testing.T) {
+	gateway, _, table := newStreamedGateway(t)
+
+	result, err := gateway.write(context.Background(), table.key("order-1"), []byte(`{"id":{"S":"order-1"}}`), false, PutOptions{})
+
+	require.NoError(t, err)
+	record, ok := readSlot(t, gateway, table, "order-1", 1)
+	require.True(t, ok)
+	assert.Equal(t, EventInsert, record.EventName)
+	assert.Equal(t, result.Clock.Version(), record.NewImage.Version)
+	assertCleared(t, gateway, result.Clock)
+}
+
+func TestFlushStreamsAppendsChangesOfWrittenItemsOnly(t *testing.T) {
+	gateway, s3, table := newStreamedGateway(t)
+	written, lost := vclock.Clock{"g": 1}, vclock.Clock{"h": 1}
+	s3.put(table.key("order-1"), []byte(`{"id":{"S":"order-1"}}`), VersionMeta{Clock: written}, time.Now())
+	for _, clock := range []vclock.Clock{written, lost} {
+		body, err := json.Marshal(pendingChange{
+			ObjectName: table.key("order-1"),
+			Clock:      clock.Encode(),
+			Record:     StreamRecord{Key: "order-1", EventName: EventInsert, NewImage: &ImageRef{Version: clock.Version()}},
+		})
+		require.NoError(t, err)
+		s3.put(pendingKey(clock), body, VersionMeta{Clock: vclock.Clock{"p": 1}}, time.Now().Add(-2*streamPendingTimeout))
+	}
+
+	require.NoError(t, gateway.FlushStreams(context.Background()))
+
+	record, ok := readSlot(t, gateway, table, "order-1", 1)
+	require.True(t, ok)
+	assert.Equal(t, written.Version(), record.NewImage.Version)
+	assert.NotEmpty(t, record.NewImage.ETag)
+	_, ok = readSlot(t, gateway, table, "order-1", 2)
+	assert.False(t, ok, "the write that never happened has no record")
+	assertCleared(t, gateway, written)
+	assertCleared(t, gateway, lost)
+}
+
+func TestTrimStreamsKeepsUnflushedChanges(t *testing.T) {
+	gateway, s3, _ := newStreamedGateway(t)
+	old := time.Now().Add(-2 * streamRetention)
+	unflushed, cleared := vclock.Clock{"g": 1}, vclock.Clock{"h": 1}
+	s3.put(pendingKey(unflushed), []byte(`{"object_name":"order-1"}`), VersionMeta{Clock: vclock.Clock{"p": 1}}, old)
+	s3.put(pendingKey(cleared), nil, VersionMeta{Clock: vclock.Clock{"p": 2}, Tombstone: true}, old)
+
+	require.NoError(t, gateway.TrimStreams(context.Background()))
+
+	assert.True(t, s3.Has(pendingKey(unflushed)), "a change never flushed is kept")
+	assert.False(t, s3.Has(pendingKey(cleared)))
+}
+
+func TestTrimStreamsKeepsTheNewestRecordOfIdleShards(t *testing.T) {
+	gateway, s3, table := newStreamedGateway(t)
+	shard := shardKey(table, 0)
+	old := time.Now().Add(-2 * streamRetention)
+	for sequence := range uint64(2) {
+		s3.put(recordName(shard, sequence+1), []byte(`{}`), VersionMeta{Clock: vclock.Clock{"p": sequence + 1}}, old)
+	}
+
+	require.NoError(t, gateway.TrimStreams(context.Background()))
+	assert.False(t, s3.Has(recordName(shard, 1)))
+	assert.True(t, s3.Has(recordName(shard, 2)), "the newest record of the shard is kept")
+
+	// A restarted gateway numbers records after the newest one left.
+	gateway.streams = streamTails{}
+	record := StreamRecord{Key: "order-1", EventName: EventInsert}
+	require.NoError(t, gateway.appendRecord(context.Background(), shard, &record))
+	assert.Equal(t, uint64(3), record.Sequence)
+}
+
+func TestAppendsToAShardDontWaitForEachOther(t *testing.T) {
+	gateway, s3, table := newStreamedGateway(t)
+	shard := shardKey(table, 0)
+	blocked, unblock := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	s3.Before = func(method, key string) {
+		if method == "PUT" && strings.Contains(key, recordName(shard, 1)) {
+			once.Do(func() {
+				close(blocked)
+				<-unblock
+			})
+		}
+	}
+
+	first := make(chan error)
+	go func() {
+		first <- gateway.appendRecord(context.Background(), shard, &StreamRecord{Key: "order-1"})
+	}()
+	<-blocked
+	second := StreamRecord{Key: "order-2"}
+	require.NoError(t, gateway.appendRecord(context.Background(), shard, &second))
+	close(unblock)
+
+	assert.NoError(t, <-first)
+	assert.Equal(t, uint64(2), second.Sequence)
+}
+
+func TestReadStreamPicksOneOfConcurrentRecordsInASlot(t *testing.T) {
+	gateway, s3, table := newStreamedGateway(t)
+	key := shardKey(table, 0)
+	old := time.Now().Add(-2 * streamGapTimeout)
+	a, b := vclock.Clock{"a": 1}, vclock.Clock{"b": 1}
+	for _, slot := range []string{recordName(key, 1), siblingKey(recordName(key, 1), b)} {
+		clock := a
+		if slot != recordName(key, 1) {
+			clock = b
+		}
+		body, err := json.Marshal(StreamRecord{Sequence: 1, Timestamp: old, EventName: EventInsert, Key: clock.Version()})
+		require.NoError(t, err)
+		s3.put(slot, body, VersionMeta{Clock: clock}, old)
+	}
+
+	page, err := gateway.ReadStream(context.Background(), "orders", StreamOptions{Shard: 0})
+
+	require.NoError(t, err)
+	require.Len(t, page.Records, 1)
+	assert.Equal(t, min(a.Version(), b.Version()), page.Records[0].Key)
+}
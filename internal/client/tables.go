@@ -0,0 +1,571 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/vrnvu/go-dynamolike/internal/item"
+)
+
+const (
+	tablesPrefix = reservedPrefix + "tables/"
+	// catalogKey holds the definition of every table, updated with conditional
+	// writes.
+	catalogKey = reservedPrefix + "catalog"
+
+	catalogTTL = 5 * time.Second
+)
+
+var tableNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,255}$`)
+
+// Table is a namespace of items with its own replication settings.
+type Table struct {
+	Name string `json:"name"`
+	// ID is unique to each creation of a table, so items of a dropped table
+	// never show up in a new one with the same name.
+	ID                string    `json:"id"`
+	Created           time.Time `json:"created"`
+	ReplicationFactor int       `json:"replication_factor"`
+	ReadQuorum        int       `json:"read_quorum"`
+	WriteQuorum       int       `json:"write_quorum"`
+	// PartitionKey places items on the ring. Tables created before key
+	// schemas existed have the zero value, read as defaultPartitionKey.
+	PartitionKey KeyAttribute  `json:"partition_key"`
+	SortKey      *KeyAttribute `json:"sort_key,omitempty"`
+	Indexes      []Index       `json:"indexes,omitempty"`
+	// TTLAttribute names the attribute holding the expiry of items in Unix
+	// seconds.
+	TTLAttribute string `json:"ttl_attribute,omitempty"`
+	Stream       bool   `json:"stream,omitempty"`
+}
+
+// KeyAttribute is an attribute of the primary key of a table's items.
+type KeyAttribute struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+var defaultPartitionKey = KeyAttribute{Name: "id", Type: "S"}
+
+// TableOptions are the settings of a new table. Zero values select the
+// gateway defaults.
+type TableOptions struct {
+	ReplicationFactor int           `json:"replication_factor"`
+	ReadQuorum        int           `json:"read_quorum"`
+	WriteQuorum       int           `json:"write_quorum"`
+	PartitionKey      KeyAttribute  `json:"partition_key"`
+	SortKey           *KeyAttribute `json:"sort_key,omitempty"`
+	TTLAttribute      string        `json:"ttl_attribute,omitempty"`
+	Stream            bool          `json:"stream,omitempty"`
+}
+
+func (a KeyAttribute) validate(role string) error {
+	if a.Name == "" || (a.Type != "S" && a.Type != "N" && a.Type != "B") {
+		return fmt.Errorf("%w: %s needs a name and a type of S, N or B", ErrInvalidArgument, role)
+	}
+	return nil
+}
+
+func (t Table) key(objectName string) string {
+	return tablesPrefix + t.ID + "/" + objectName
+}
+
+func (t Table) keyAttributes() []KeyAttribute {
+	keys := []KeyAttribute{cmp.Or(t.PartitionKey, defaultPartitionKey)}
+	if t.SortKey != nil {
+		keys = append(keys, *t.SortKey)
+	}
+	return keys
+}
+
+func (t Table) expiresAt(it item.Item) time.Time {
+	v, ok := it[t.TTLAttribute]
+	if t.TTLAttribute == "" || !ok || v.Kind() != item.KindNumber {
+		return time.Time{}
+	}
+	seconds, err := strconv.ParseFloat(v.Text(), 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(seconds), 0)
+}
+
+func (t Table) replication() replication {
+	return replication{factor: t.ReplicationFactor, read: t.ReadQuorum, write: t.WriteQuorum}
+}
+
+func (t Table) index(name string) (Index, bool) {
+	i := slices.IndexFunc(t.Indexes, func(index Index) bool { return index.Name == name })
+	if i < 0 {
+		return Index{}, false
+	}
+	return t.Indexes[i], true
+}
+
+func tableIDOf(objectName string) (string, bool) {
+	rest, ok := strings.CutPrefix(objectName, tablesPrefix)
+	if !ok {
+		return "", false
+	}
+	id, _, ok := strings.Cut(rest, "/")
+	return id, ok
+}
+
+type replication struct {
+	factor int
+	read   int
+	write  int
+}
+
+type catalog struct {
+	Tables  map[string]Table `json:"tables"`
+	Dropped []string         `json:"dropped,omitempty"`
+}
+
+// merge adds the tables of other to c. Of two tables created with the same
+// name the oldest one wins and the other is dropped.
+func (c *catalog) merge(other catalog) {
+	for name, table := range other.Tables {
+		current, ok := c.Tables[name]
+		switch {
+		case !ok:
+			c.Tables[name] = table
+		case current.ID == table.ID:
+			c.Tables[name] = mergeIndexes(current, table)
+		case table.Created.Before(current.Created):
+			c.Tables[name] = table
+			c.drop(current.ID)
+		default:
+			c.drop(table.ID)
+		}
+	}
+	for _, id := range other.Dropped {
+		c.drop(id)
+	}
+	for name, table := range c.Tables {
+		if slices.Contains(c.Dropped, table.ID) {
+			delete(c.Tables, name)
+			continue
+		}
+		table.Indexes = slices.DeleteFunc(slices.Clone(table.Indexes), func(index Index) bool {
+			return slices.Contains(c.Dropped, index.ID)
+		})
+		c.Tables[name] = table
+	}
+}
+
+func mergeIndexes(a, b Table) Table {
+	a.Indexes = slices.Clone(a.Indexes)
+	for _, index := range b.Indexes {
+		i := slices.IndexFunc(a.Indexes, func(other Index) bool { return other.ID == index.ID })
+		switch {
+		case i < 0:
+			a.Indexes = append(a.Indexes, index)
+		case index.Status == IndexActive:
+			a.Indexes[i] = index
+		}
+	}
+	return a
+}
+
+func (c *catalog) drop(id string) {
+	if !slices.Contains(c.Dropped, id) {
+		c.Dropped = append(c.Dropped, id)
+	}
+}
+
+type catalogCache struct {
+	mu      sync.RWMutex
+	catalog catalog
+	byID    map[string]Table
+	loaded  time.Time
+}
+
+func (c *catalogCache) store(cat catalog) {
+	byID := make(map[string]Table, len(cat.Tables))
+	for _, table := range cat.Tables {
+		byID[table.ID] = table
+		for _, index := range table.Indexes {
+			byID[index.ID] = table
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.catalog = catalog{Tables: maps.Clone(cat.Tables), Dropped: slices.Clone(cat.Dropped)}
+	c.byID = byID
+	c.loaded = time.Now()
+}
+
+func (c *catalogCache) table(name string) (Table, bool, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	table, ok := c.catalog.Tables[name]
+	return table, ok, time.Since(c.loaded) < catalogTTL
+}
+
+func (m *MinioGateway) defaultReplication() replication {
+	return replication{factor: m.replicationFactor, read: m.readQuorum, write: m.writeQuorum}
+}
+
+// replicationOf returns the replication of the table objectName belongs to,
+// from the cached catalog.
+func (m *MinioGateway) replicationOf(objectName string) replication {
+	id, ok := tableIDOf(objectName)
+	if !ok {
+		return m.defaultReplication()
+	}
+	m.catalog.mu.RLock()
+	defer m.catalog.mu.RUnlock()
+	table, ok := m.catalog.byID[id]
+	if !ok {
+		return m.defaultReplication()
+	}
+	return table.replication()
+}
+
+func (m *MinioGateway) tableOf(objectName string) (Table, bool) {
+	id, ok := tableIDOf(objectName)
+	if !ok {
+		return Table{}, false
+	}
+	m.catalog.mu.RLock()
+	defer m.catalog.mu.RUnlock()
+	t, ok := m.catalog.byID[id]
+	return t, ok && t.ID == id
+}
+
+func (m *MinioGateway) replicasOf(topo *topology, objectName string) ([]*MinioNode, error) {
+	return topo.preferenceList(objectName, m.replicationOf(objectName).factor)
+}
+
+// locate returns the key objectName is stored under and its replication.
+func (m *MinioGateway) locate(ctx context.Context, tableName, indexName, objectName string) (string, replication, error) {
+	if tableName == "" {
+		return objectName, m.replicationOf(objectName), nil
+	}
+	table, err := m.DescribeTable(ctx, tableName)
+	if err != nil {
+		return "", replication{}, err
+	}
+	if indexName == "" {
+		return table.key(objectName), table.replication(), nil
+	}
+	index, ok := table.index(indexName)
+	if !ok {
+		return "", replication{}, fmt.Errorf("index %s of table %s: %w", indexName, tableName, ErrNotFound)
+	}
+	return index.key(objectName), table.replication(), nil
+}
+
+func (m *MinioGateway) readCatalog(ctx context.Context) (catalog, error) {
+	object, err := m.Get(ctx, catalogKey, GetOptions{Consistency: ConsistencyQuorum})
+	if errors.Is(err, ErrNotFound) {
+		object, err = nil, nil
+	}
+	if err != nil {
+		return catalog{}, fmt.Errorf("failed to read table catalog: %w", err)
+	}
+	cat, err := decodeCatalog(ctx, object)
+	if err != nil {
+		return catalog{}, err
+	}
+	m.catalog.store(cat)
+	return cat, nil
+}
+
+func decodeCatalog(ctx context.Context, object *Object) (catalog, error) {
+	cat := catalog{Tables: make(map[string]Table)}
+	if object == nil {
+		return cat, nil
+	}
+	for _, sibling := range object.Siblings {
+		var other catalog
+		if err := decodeVersion(ctx, sibling, &other); err != nil {
+			return cat, fmt.Errorf("failed to read table catalog: %w", err)
+		}
+		cat.merge(other)
+	}
+	return cat, nil
+}
+
+func decodeVersion(ctx context.Context, version Version, v any) error {
+	data, err := version.read(ctx)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (m *MinioGateway) updateCatalog(ctx context.Context, update func(*catalog) error) error {
+	var cat catalog
+	_, err := m.readModifyWrite(ctx, catalogKey, PutOptions{Consistency: ConsistencyQuorum}, func(object *Object, _ *PutOptions) ([]byte, bool, error) {
+		var err error
+		if cat, err = decodeCatalog(ctx, object); err != nil {
+			return nil, false, err
+		}
+		if err := update(&cat); err != nil {
+			return nil, false, err
+		}
+		body, err := json.Marshal(cat)
+		return body, false, err
+	})
+	if err != nil {
+		return err
+	}
+	m.catalog.store(cat)
+	return nil
+}
+
+// CreateTable adds a table to the catalog. It fails with ErrConflict when
+// the table exists.
+func (m *MinioGateway) CreateTable(ctx context.Context, name string, opts TableOptions) (Table, error) {
+	if !tableNamePattern.MatchString(name) {
+		return Table{}, fmt.Errorf("%w: table name %q, expected 3 to 255 letters, digits, '_', '-' or '.'", ErrInvalidArgument, name)
+	}
+	table := Table{
+		Name:              name,
+		ID:                uuid.NewString(),
+		Created:           time.Now().UTC(),
+		ReplicationFactor: cmp.Or(opts.ReplicationFactor, m.replicationFactor),
+		PartitionKey:      cmp.Or(opts.PartitionKey, defaultPartitionKey),
+		SortKey:           opts.SortKey,
+		TTLAttribute:      opts.TTLAttribute,
+		Stream:            opts.Stream,
+	}
+	if err := table.PartitionKey.validate("partition key"); err != nil {
+		return Table{}, err
+	}
+	if table.SortKey != nil {
+		if err := table.SortKey.validate("sort key"); err != nil {
+			return Table{}, err
+		}
+		if table.SortKey.Name == table.PartitionKey.Name {
+			return Table{}, fmt.Errorf("%w: sort key and partition key are both %s", ErrInvalidArgument, table.SortKey.Name)
+		}
+	}
+	table.ReadQuorum = cmp.Or(opts.ReadQuorum, min(m.readQuorum, table.ReplicationFactor))
+	table.WriteQuorum = cmp.Or(opts.WriteQuorum, min(m.writeQuorum, table.ReplicationFactor))
+	if table.ReplicationFactor < 1 || table.ReadQuorum < 1 || table.ReadQuorum > table.ReplicationFactor ||
+		table.WriteQuorum < 1 || table.WriteQuorum > table.ReplicationFactor {
+		return Table{}, fmt.Errorf("%w: replication factor %d, read quorum %d and write quorum %d",
+			ErrInvalidArgument, table.ReplicationFactor, table.ReadQuorum, table.WriteQuorum)
+	}
+
+	err := m.updateCatalog(ctx, func(cat *catalog) error {
+		if _, ok := cat.Tables[name]; ok {
+			return fmt.Errorf("%w: table %s exists", ErrConflict, name)
+		}
+		cat.Tables[name] = table
+		return nil
+	})
+	if err != nil {
+		return Table{}, err
+	}
+	slog.Info("Created table", slog.String("table", name), slog.String("table_id", table.ID))
+	return table, nil
+}
+
+// DeleteTable removes a table from the catalog. CollectDroppedTables
+// removes its items.
+func (m *MinioGateway) DeleteTable(ctx context.Context, name string) error {
+	var id string
+	err := m.updateCatalog(ctx, func(cat *catalog) error {
+		table, ok := cat.Tables[name]
+		if !ok {
+			return fmt.Errorf("table %s: %w", name, ErrNotFound)
+		}
+		id = table.ID
+		delete(cat.Tables, name)
+		cat.drop(table.ID)
+		for _, index := range table.Indexes {
+			cat.drop(index.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Deleted table", slog.String("table", name), slog.String("table_id", id))
+	return nil
+}
+
+// SetTimeToLive changes the TTL attribute of a table, the empty name stops
+// items from expiring.
+func (m *MinioGateway) SetTimeToLive(ctx context.Context, name, attribute string) (Table, error) {
+	var table Table
+	err := m.updateCatalog(ctx, func(cat *catalog) error {
+		var ok bool
+		if table, ok = cat.Tables[name]; !ok {
+			return fmt.Errorf("table %s: %w", name, ErrNotFound)
+		}
+		table.TTLAttribute = attribute
+		cat.Tables[name] = table
+		return nil
+	})
+	return table, err
+}
+
+// SetStream turns the change stream of a table on or off.
+func (m *MinioGateway) SetStream(ctx context.Context, name string, enabled bool) (Table, error) {
+	var table Table
+	err := m.updateCatalog(ctx, func(cat *catalog) error {
+		var ok bool
+		if table, ok = cat.Tables[name]; !ok {
+			return fmt.Errorf("table %s: %w", name, ErrNotFound)
+		}
+		table.Stream = enabled
+		cat.Tables[name] = table
+		return nil
+	})
+	return table, err
+}
+
+// DescribeTable returns the definition of a table, from the cache when it was
+// read recently.
+func (m *MinioGateway) DescribeTable(ctx context.Context, name string) (Table, error) {
+	if table, ok, fresh := m.catalog.table(name); ok && fresh {
+		return table, nil
+	}
+	cat, err := m.readCatalog(ctx)
+	if err != nil {
+		// A stale definition beats failing while the catalog is unavailable.
+		if table, ok, _ := m.catalog.table(name); ok {
+			return table, nil
+		}
+		return Table{}, err
+	}
+	table, ok := cat.Tables[name]
+	if !ok {
+		return Table{}, fmt.Errorf("table %s: %w", name, ErrNotFound)
+	}
+	return table, nil
+}
+
+// Tables returns every table sorted by name.
+func (m *MinioGateway) Tables(ctx context.Context) ([]Table, error) {
+	cat, err := m.readCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]Table, 0, len(cat.Tables))
+	for _, name := range sortedKeys(cat.Tables) {
+		tables = append(tables, cat.Tables[name])
+	}
+	return tables, nil
+}
+
+func (m *MinioGateway) RefreshCatalog(ctx context.Context) error {
+	_, err := m.readCatalog(ctx)
+	return err
+}
+
+// CollectDroppedTables removes the items of dropped tables from every healthy
+// node. A dropped table leaves the catalog once two complete scans in a row
+// found nothing of it, so a gateway with a stale catalog doesn't leave items
+// behind.
+func (m *MinioGateway) CollectDroppedTables(ctx context.Context) error {
+	topo := m.topology()
+	healthy := m.healthySet(topo)
+	scan, err := m.newDroppedTablesScan(ctx, topo, healthy)
+	if scan == nil {
+		return err
+	}
+	return m.scanNodes(ctx, topo, healthy, scan)
+}
+
+type droppedTables struct {
+	mu   sync.Mutex
+	gone map[string]bool
+}
+
+type droppedTablesScan struct {
+	m        *MinioGateway
+	dropped  []string
+	complete bool
+	found    map[string]bool
+	errs     []error
+}
+
+// newDroppedTablesScan returns nil when no table was dropped.
+func (m *MinioGateway) newDroppedTablesScan(ctx context.Context, topo *topology, healthy map[*MinioNode]bool) (*droppedTablesScan, error) {
+	cat, err := m.readCatalog(ctx)
+	if err != nil || len(cat.Dropped) == 0 {
+		return nil, err
+	}
+	return &droppedTablesScan{
+		m:        m,
+		dropped:  cat.Dropped,
+		complete: len(healthy) == len(topo.nodes),
+		found:    make(map[string]bool),
+	}, nil
+}
+
+func (s *droppedTablesScan) visit(ctx context.Context, node *MinioNode, object minio.ObjectInfo) {
+	if !strings.HasPrefix(object.Key, reservedPrefix) {
+		return
+	}
+	objectName := objectNameOf(object.Key)
+	if _, name, ok := parseHintKey(object.Key); ok {
+		objectName = name
+	}
+	if id, ok := streamTableOf(objectName); ok && slices.Contains(s.dropped, id) {
+		s.found[id] = true
+		return
+	}
+	id, ok := tableIDOf(objectName)
+	if !ok || !slices.Contains(s.dropped, id) {
+		return
+	}
+	s.found[id] = true
+	if err := node.minioClient.RemoveObject(ctx, bucketName, object.Key, minio.RemoveObjectOptions{}); err != nil {
+		s.errs = append(s.errs, fmt.Errorf("node %s: %w", node.ID, err))
+	}
+}
+
+func (s *droppedTablesScan) finish(ctx context.Context, listErr error) error {
+	var gone map[string]bool
+	if s.complete && listErr == nil && len(s.errs) == 0 {
+		gone = make(map[string]bool)
+		for _, id := range s.dropped {
+			if !s.found[id] {
+				gone[id] = true
+			}
+		}
+	}
+	state := &s.m.droppedTables
+	state.mu.Lock()
+	var pruned []string
+	for id := range gone {
+		if state.gone[id] {
+			pruned = append(pruned, id)
+		}
+	}
+	state.gone = gone
+	state.mu.Unlock()
+
+	if len(pruned) > 0 {
+		err := s.m.updateCatalog(ctx, func(cat *catalog) error {
+			cat.Dropped = slices.DeleteFunc(cat.Dropped, func(id string) bool { return slices.Contains(pruned, id) })
+			return nil
+		})
+		if err != nil {
+			s.errs = append(s.errs, err)
+		} else {
+			slog.Info("Forgot dropped tables", slog.Any("table_ids", pruned))
+		}
+	}
+	return errors.Join(s.errs...)
+}
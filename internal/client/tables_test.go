@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vrnvu/go-dynamolike/internal/item"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestTableKeys(t *testing.T) {
+	table := Table{Name: "users", ID: "1234"}
+
+	key := table.key("alice/profile")
+
+	assert.True(t, IsReserved(key), "items must be hidden from the object API")
+	id, ok := tableIDOf(key)
+	assert.True(t, ok)
+	assert.Equal(t, "1234", id)
+	_, ok = tableIDOf("alice")
+	assert.False(t, ok)
+}
+
+func TestReplicationOfUsesTableSettings(t *testing.T) {
+	gateway := &MinioGateway{replicationFactor: 3, readQuorum: 2, writeQuorum: 2}
+	table := Table{Name: "sessions", ID: "1234", ReplicationFactor: 1, ReadQuorum: 1, WriteQuorum: 1}
+	gateway.catalog.store(catalog{Tables: map[string]Table{"sessions": table}})
+
+	assert.Equal(t, replication{factor: 1, read: 1, write: 1}, gateway.replicationOf(table.key("a")))
+	assert.Equal(t, replication{factor: 3, read: 2, write: 2}, gateway.replicationOf("a"))
+	assert.Equal(t, replication{factor: 3, read: 2, write: 2}, gateway.replicationOf(tablesPrefix+"unknown/a"))
+}
+
+func TestCatalogMergeKeepsOldestTable(t *testing.T) {
+	now := time.Now()
+	a := catalog{Tables: map[string]Table{
+		"users":  {Name: "users", ID: "1", Created: now},
+		"orders": {Name: "orders", ID: "2", Created: now},
+	}}
+	b := catalog{
+		Tables:  map[string]Table{"users": {Name: "users", ID: "3", Created: now.Add(-time.Second)}},
+		Dropped: []string{"2"},
+	}
+
+	a.merge(b)
+
+	assert.Equal(t, map[string]Table{"users": b.Tables["users"]}, a.Tables)
+	assert.ElementsMatch(t, []string{"1", "2"}, a.Dropped)
+}
+
+func TestCreateTableRejectsInvalidSettings(t *testing.T) {
+	gateway := &MinioGateway{replicationFactor: 3, readQuorum: 2, writeQuorum: 2}
+
+	_, err := gateway.CreateTable(context.Background(), "a/b", TableOptions{})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+
+	_, err = gateway.CreateTable(context.Background(), "users", TableOptions{ReplicationFactor: 1, ReadQuorum: 2})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestTableExpiresAt(t *testing.T) {
+	table := Table{Name: "sessions", TTLAttribute: "expires"}
+	expires, err := item.Number("1700000000")
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Unix(1700000000, 0), table.expiresAt(item.Item{"expires": expires}))
+	assert.True(t, table.expiresAt(item.Item{"expires": item.String("tomorrow")}).IsZero())
+	assert.True(t, Table{}.expiresAt(item.Item{"expires": expires}).IsZero())
+}
+
+func TestCollectDroppedTablesForgetsTablesOnceNothingIsLeft(t *testing.T) {
+	node, s3 := newFakeNode(t, "a")
+	gateway := newFakeGateway(node)
+	ctx := context.Background()
+	table := Table{Name: "orders", ID: "1234"}
+	body, err := json.Marshal(catalog{Tables: map[string]Table{}, Dropped: []string{table.ID}})
+	require.NoError(t, err)
+	s3.put(catalogKey, body, VersionMeta{Clock: vclock.Clock{"c": 1}}, time.Now())
+	s3.put(table.key("order-1"), []byte("{}"), VersionMeta{Clock: vclock.Clock{"g": 1}}, time.Now())
+	record := recordName(shardKey(table, 0), 1)
+	s3.put(record, []byte("{}"), VersionMeta{Clock: vclock.Clock{"g": 1}}, time.Now())
+	dropped := func() []string {
+		cat, err := gateway.readCatalog(ctx)
+		require.NoError(t, err)
+		return cat.Dropped
+	}
+
+	require.NoError(t, gateway.CollectDroppedTables(ctx))
+	assert.False(t, s3.Has(table.key("order-1")))
+	require.NoError(t, gateway.CollectDroppedTables(ctx))
+	assert.Contains(t, dropped(), table.ID, "the stream records of the table are left")
+
+	require.NoError(t, gateway.TrimStreams(ctx))
+	require.False(t, s3.Has(record))
+	require.NoError(t, gateway.CollectDroppedTables(ctx))
+	assert.Contains(t, dropped(), table.ID, "a single scan found nothing left")
+	require.NoError(t, gateway.CollectDroppedTables(ctx))
+	// The catalog is written conditionally, then moved to its key in the
+	// background.
+	require.Eventually(t, func() bool {
+		return !slices.ContainsFunc(s3.Keys(), func(key string) bool { return strings.HasPrefix(key, siblingsPrefix) })
+	}, time.Second, time.Millisecond)
+	assert.NotContains(t, dropped(), table.ID)
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const defaultTombstoneGrace = 24 * time.Hour
+
+var tombstonesCollected = expvar.NewMap("tombstones_collected")
+
+// CollectTombstones removes deleted objects whose tombstone is older than the
+// grace period, once every replica holds nothing but the tombstone.
+func (m *MinioGateway) CollectTombstones(ctx context.Context) error {
+	topo := m.topology()
+	return m.scanNodes(ctx, topo, m.healthySet(topo), m.newTombstoneScan(topo))
+}
+
+type tombstoneScan struct {
+	m          *MinioGateway
+	topo       *topology
+	candidates map[string]bool
+}
+
+func (m *MinioGateway) newTombstoneScan(topo *topology) *tombstoneScan {
+	return &tombstoneScan{m: m, topo: topo, candidates: make(map[string]bool)}
+}
+
+func (s *tombstoneScan) visit(_ context.Context, _ *MinioNode, object minio.ObjectInfo) {
+	if object.Size != 0 || nodeLocal(object.Key) || !s.m.expired(object.LastModified) {
+		return
+	}
+	s.candidates[objectNameOf(object.Key)] = true
+}
+
+func (s *tombstoneScan) finish(ctx context.Context, _ error) error {
+	var errs []error
+	for _, objectName := range sortedKeys(s.candidates) {
+		if err := s.m.collectTombstone(ctx, s.topo, objectName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *MinioGateway) expired(lastModified time.Time) bool {
+	return time.Since(lastModified) >= m.tombstoneGrace
+}
+
+func (m *MinioGateway) collectTombstone(ctx context.Context, topo *topology, objectName string) error {
+	replicas, err := m.replicasOf(topo, objectName)
+	if err != nil {
+		return err
+	}
+
+	var stored []Version
+	for _, node := range replicas {
+		if !m.isHealthy(node) {
+			return nil
+		}
+		versions, err := node.Versions(ctx, objectName)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", node.ID, err)
+		}
+		for _, v := range versions {
+			if !v.Tombstone || !m.expired(v.Info.LastModified) {
+				return nil
+			}
+		}
+		stored = append(stored, versions...)
+	}
+
+	// A write may replace a tombstone once it was checked.
+	for _, v := range stored {
+		removed, err := v.node.removeVersion(ctx, v)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", v.node.ID, err)
+		}
+		if !removed {
+			slog.Info("Tombstone replaced before it was collected",
+				slog.String("node_id", v.node.ID),
+				slog.String("object_name", objectName))
+			return nil
+		}
+		tombstonesCollected.Add(v.node.ID, 1)
+		slog.Debug("Collected tombstone",
+			slog.String("node_id", v.node.ID),
+			slog.String("object_name", objectName),
+			slog.String("key", v.Info.Key))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestCollectTombstoneRemovesExpiredTombstones(t *testing.T) {
+	node, s3 := newFakeNode(t, "a")
+	gateway := newFakeGateway(node)
+	s3.put("key", nil, VersionMeta{Clock: vclock.Clock{"g": 1}, Tombstone: true}, time.Now().Add(-2*defaultTombstoneGrace))
+
+	err := gateway.collectTombstone(context.Background(), gateway.topology(), "key")
+
+	assert.NoError(t, err)
+	assert.Empty(t, s3.Keys())
+}
+
+func TestCollectTombstoneKeepsWriteLandingAfterTheCheck(t *testing.T) {
+	node, s3 := newFakeNode(t, "a")
+	gateway := newFakeGateway(node)
+	s3.put("key", nil, VersionMeta{Clock: vclock.Clock{"g": 1}, Tombstone: true}, time.Now().Add(-2*defaultTombstoneGrace))
+
+	// The tombstone is replaced once it was read, right before it is removed.
+	var heads atomic.Int32
+	s3.Before = func(method, key string) {
+		if method == "HEAD" && key == "key" && heads.Add(1) == 2 {
+			s3.put("key", []byte("value"), VersionMeta{Clock: vclock.Clock{"g": 2}}, time.Now())
+		}
+	}
+
+	err := gateway.collectTombstone(context.Background(), gateway.topology(), "key")
+
+	assert.NoError(t, err)
+	assert.Empty(t, s3.RemovedKeys())
+	versions, err := node.Versions(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+	assert.False(t, versions[0].Tombstone)
+}
@@ -0,0 +1,656 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/vrnvu/go-dynamolike/internal/item"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+const (
+	// MaxTransactItems caps the items of a transaction.
+	MaxTransactItems = 100
+
+	// The record of a transaction lives under txnsPrefix, the locks of its items
+	// under locksPrefix.
+	txnsPrefix  = reservedPrefix + "txns/"
+	locksPrefix = reservedPrefix + "locks/"
+	// Lock markers sit next to the siblings of a locked item so writes notice the
+	// lock. Version IDs are hexadecimal, a marker is never taken for one.
+	lockMarkerPrefix = "lock-"
+
+	// txnTimeout is how long a transaction record may go unchanged before
+	// other gateways recover it.
+	txnTimeout = 30 * time.Second
+)
+
+// Actions of the items of a transaction, as in DynamoDB.
+const (
+	TransactPut            = "Put"
+	TransactUpdate         = "Update"
+	TransactDelete         = "Delete"
+	TransactConditionCheck = "ConditionCheck"
+)
+
+// Codes of cancellation reasons, as in DynamoDB.
+const (
+	ReasonNone                   = "None"
+	ReasonConditionalCheckFailed = "ConditionalCheckFailed"
+	ReasonTransactionConflict    = "TransactionConflict"
+)
+
+// States of a transaction record.
+const (
+	txnPending   = "PENDING"
+	txnCommitted = "COMMITTED"
+	txnAborted   = "ABORTED"
+)
+
+// errTxnDecided stops a transaction from being decided twice.
+var errTxnDecided = errors.New("transaction already decided")
+
+// TransactItem is one action of a transaction.
+type TransactItem struct {
+	Action string
+	Table  string
+	Item   item.Item
+	Key    item.Item
+	Update string
+	// Condition must hold on the current item for the transaction to commit.
+	Condition string
+	Params    item.Params
+}
+
+// CancellationReason tells why an item kept its transaction from committing.
+type CancellationReason struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// TransactionCanceledError is returned when a transaction was cancelled
+// without writing anything. It wraps ErrConflict when another transaction
+// held one of the items, and ErrPreconditionFailed otherwise.
+type TransactionCanceledError struct {
+	Reasons []CancellationReason
+}
+
+func (e *TransactionCanceledError) Error() string {
+	codes := make([]string, len(e.Reasons))
+	for i, reason := range e.Reasons {
+		codes[i] = reason.Code
+	}
+	return fmt.Sprintf("%s: transaction cancelled: [%s]", e.Unwrap(), strings.Join(codes, ", "))
+}
+
+func (e *TransactionCanceledError) Unwrap() error {
+	for _, reason := range e.Reasons {
+		if reason.Code == ReasonTransactionConflict {
+			return ErrConflict
+		}
+	}
+	return ErrPreconditionFailed
+}
+
+// txnRecord is the durable state of a transaction. Once it is committed, its
+// writes are applied by whoever finishes it, even if the coordinator is gone.
+type txnRecord struct {
+	ID     string     `json:"id"`
+	State  string     `json:"state"`
+	Writes []txnWrite `json:"writes"`
+}
+
+// txnWrite is what a transaction does to one item.
+type txnWrite struct {
+	Table string `json:"table"`
+	Name  string `json:"name"`
+	Lock  string `json:"lock"`
+	// Version is the clock to write, empty when the item is left as is.
+	Version   string    `json:"version,omitempty"`
+	Body      []byte    `json:"body,omitempty"`
+	Tombstone bool      `json:"tombstone,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Old       item.Item `json:"old,omitempty"`
+
+	read []string
+}
+
+// transactOp is a TransactItem resolved against its table.
+type transactOp struct {
+	TransactItem
+	table     Table
+	name      string
+	condition *item.Condition
+	update    *item.Update
+}
+
+func txnKey(id string) string {
+	return txnsPrefix + id
+}
+
+func lockKey(objectName string) string {
+	return locksPrefix + objectName
+}
+
+func lockMarkerKey(objectName, id string) string {
+	return siblingsPrefix + objectName + "/" + lockMarkerPrefix + id
+}
+
+func isLockMarker(key string) bool {
+	rest, ok := strings.CutPrefix(key, siblingsPrefix)
+	return ok && strings.HasPrefix(rest[strings.LastIndex(rest, "/")+1:], lockMarkerPrefix)
+}
+
+// TransactWriteItems applies the actions of items all together or not at
+// all. When an item fails its condition or is locked by another
+// transaction, nothing is written and a TransactionCanceledError tells why
+// for each item.
+func (m *MinioGateway) TransactWriteItems(ctx context.Context, items []TransactItem) error {
+	ops, err := m.transactOps(ctx, items)
+	if err != nil {
+		return err
+	}
+	record := txnRecord{ID: uuid.New().String(), State: txnPending}
+	for _, op := range ops {
+		record.Writes = append(record.Writes, txnWrite{Table: op.table.Name, Name: op.name, Lock: lockKey(op.table.key(op.name))})
+	}
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := m.write(ctx, txnKey(record.ID), body, false, PutOptions{Consistency: ConsistencyQuorum, IfNoneMatch: true}); err != nil {
+		return err
+	}
+
+	reasons := make([]CancellationReason, len(ops))
+	cancelled := false
+	for i, op := range ops {
+		if reasons[i], err = m.prepare(ctx, record.ID, op, &record.Writes[i]); err != nil {
+			break
+		}
+		cancelled = cancelled || reasons[i].Code != ReasonNone
+	}
+	if !cancelled && err == nil {
+		for i, op := range ops {
+			if reasons[i], err = m.verifyPrepared(ctx, op, record.Writes[i]); err != nil {
+				break
+			}
+			cancelled = cancelled || reasons[i].Code != ReasonNone
+		}
+	}
+	state := txnCommitted
+	if cancelled || err != nil {
+		state = txnAborted
+	}
+	decided, decideErr := m.decideTxn(ctx, record.ID, state, record.Writes)
+	if decideErr != nil {
+		return errors.Join(err, decideErr)
+	}
+	if finishErr := m.finishTxn(ctx, decided); finishErr != nil {
+		slog.Warn("Failed to finish transaction, leaving it to recovery",
+			slog.String("transaction_id", record.ID),
+			slog.String("state", decided.State),
+			slog.String("error", finishErr.Error()))
+	}
+
+	switch {
+	case err != nil:
+		return err
+	case cancelled:
+		return &TransactionCanceledError{Reasons: reasons}
+	case decided.State != txnCommitted:
+		return fmt.Errorf("%w: transaction %s was aborted by recovery before it committed", ErrConflict, record.ID)
+	}
+	return nil
+}
+
+func (m *MinioGateway) transactOps(ctx context.Context, items []TransactItem) ([]transactOp, error) {
+	if len(items) == 0 || len(items) > MaxTransactItems {
+		return nil, fmt.Errorf("%w: a transaction has between 1 and %d items, got %d", ErrInvalidArgument, MaxTransactItems, len(items))
+	}
+	ops := make([]transactOp, len(items))
+	seen := make(map[string]bool)
+	for i, it := range items {
+		op := transactOp{TransactItem: it}
+		var err error
+		if op.table, err = m.DescribeTable(ctx, it.Table); err != nil {
+			return nil, err
+		}
+		if it.Action == TransactPut {
+			op.name, err = itemName(op.table, it.Item)
+		} else {
+			op.name, err = keyName(op.table, it.Key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if op.condition, err = parseCondition(WriteItemOptions{Condition: it.Condition, Params: it.Params}); err != nil {
+			return nil, err
+		}
+
+		switch it.Action {
+		case TransactPut, TransactDelete:
+		case TransactUpdate:
+			if op.update, err = item.ParseUpdate(it.Update, it.Params); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+			}
+			for _, attr := range op.table.keyAttributes() {
+				if op.update.Touches(attr.Name) {
+					return nil, fmt.Errorf("%w: key attribute %s can't be updated", ErrInvalidArgument, attr.Name)
+				}
+			}
+		case TransactConditionCheck:
+			if strings.TrimSpace(it.Condition) == "" {
+				return nil, fmt.Errorf("%w: condition check of item %d has no condition", ErrInvalidArgument, i)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown action %q of item %d", ErrInvalidArgument, it.Action, i)
+		}
+
+		key := op.table.key(op.name)
+		if seen[key] {
+			return nil, fmt.Errorf("%w: item %s of table %s is in the transaction twice", ErrInvalidArgument, op.name, op.table.Name)
+		}
+		seen[key] = true
+		ops[i] = op
+	}
+	return ops, nil
+}
+
+// prepare locks the item of op, checks its condition and fills w.
+func (m *MinioGateway) prepare(ctx context.Context, id string, op transactOp, w *txnWrite) (CancellationReason, error) {
+	none := CancellationReason{Code: ReasonNone}
+	if reason, err := m.lock(ctx, id, w.Lock); err != nil || reason.Code != ReasonNone {
+		return reason, err
+	}
+	if err := m.mark(ctx, id, op.table.key(op.name), op.table.replication()); err != nil {
+		return none, err
+	}
+
+	siblings, err := m.readSiblings(ctx, op.name, GetOptions{Consistency: ConsistencyQuorum, Table: op.table.Name}, true)
+	if err != nil {
+		return none, err
+	}
+	w.read = versionIDs(siblings)
+	var object *Object
+	if values := visible(siblings); len(values) > 0 {
+		object = &Object{Name: op.name, Siblings: values}
+	}
+	old, err := decodeItem(ctx, object)
+	if err != nil {
+		return none, err
+	}
+	if !op.condition.Holds(old) {
+		return CancellationReason{Code: ReasonConditionalCheckFailed, Message: "condition check failed"}, nil
+	}
+
+	var updated item.Item
+	switch op.Action {
+	case TransactPut:
+		updated = op.Item
+	case TransactUpdate:
+		if updated, err = op.update.Apply(old); err != nil {
+			return none, fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+		}
+		for name, v := range op.Key {
+			updated[name] = v
+		}
+	case TransactDelete:
+		if old == nil {
+			return none, nil
+		}
+		w.Tombstone = true
+	default:
+		return none, nil
+	}
+	if updated != nil {
+		if w.Body, err = json.Marshal(updated); err != nil {
+			return none, fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+		}
+		w.ExpiresAt = op.table.expiresAt(updated)
+	}
+	w.Version = m.nextClock(mergeClocks(siblings)).Encode()
+	w.Old = old
+	return none, nil
+}
+
+// verifyPrepared checks that the item of op still has the versions prepare
+// read, now that every item of the transaction is locked.
+func (m *MinioGateway) verifyPrepared(ctx context.Context, op transactOp, w txnWrite) (CancellationReason, error) {
+	siblings, err := m.readSiblings(ctx, op.name, GetOptions{Consistency: ConsistencyQuorum, Table: op.table.Name}, false)
+	if err != nil {
+		return CancellationReason{}, err
+	}
+	if !sameSiblings(siblings, w.read) {
+		return CancellationReason{Code: ReasonTransactionConflict, Message: "item was written while the transaction was prepared"}, nil
+	}
+	return CancellationReason{Code: ReasonNone}, nil
+}
+
+func versionIDs(versions []Version) []string {
+	ids := make([]string, 0, len(versions))
+	for _, v := range versions {
+		ids = append(ids, v.ID())
+	}
+	return ids
+}
+
+// mark leaves the lock marker of transaction id on enough replicas for any
+// read quorum of them to include one.
+func (m *MinioGateway) mark(ctx context.Context, id, objectName string, rep replication) error {
+	nodes, err := m.topology().preferenceList(objectName, rep.factor)
+	if err != nil {
+		return err
+	}
+	errs := make(chan error, len(nodes))
+	for _, node := range nodes {
+		go func() {
+			_, err := node.minioClient.PutObject(ctx, bucketName, lockMarkerKey(objectName, id), bytes.NewReader(nil), 0, minio.PutObjectOptions{})
+			if err != nil {
+				err = fmt.Errorf("node %s: %w", node.ID, err)
+			}
+			errs <- err
+		}()
+	}
+	var failed []error
+	for range nodes {
+		if err := <-errs; err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if needed := len(nodes) - min(rep.read, len(nodes)) + 1; len(nodes)-len(failed) < needed {
+		return fmt.Errorf("%w: lock of %s marked on %d of %d replicas: %w",
+			ErrNodeUnavailable, objectName, len(nodes)-len(failed), needed, errors.Join(failed...))
+	}
+	return nil
+}
+
+func (m *MinioGateway) unmark(ctx context.Context, id, objectName string) {
+	nodes, err := m.topology().preferenceList(objectName, m.replicationOf(objectName).factor)
+	if err != nil {
+		return
+	}
+	for _, node := range nodes {
+		if err := node.minioClient.RemoveObject(ctx, bucketName, lockMarkerKey(objectName, id), minio.RemoveObjectOptions{}); err != nil {
+			slog.Warn("Failed to remove lock marker",
+				slog.String("node_id", node.ID),
+				slog.String("object_name", objectName),
+				slog.String("transaction_id", id),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+// checkMarkers fails with ErrConflict while a transaction that marked the
+// item is running. Markers of finished transactions are removed.
+func (m *MinioGateway) checkMarkers(ctx context.Context, objectName string, replies []versionsReply) error {
+	finished := make(map[string]bool)
+	for _, reply := range replies {
+		for _, id := range reply.locks {
+			done, ok := finished[id]
+			if !ok {
+				var err error
+				if done, err = m.recoverTxn(ctx, id); err != nil {
+					return err
+				}
+				finished[id] = done
+			}
+			if !done {
+				return fmt.Errorf("%w: item is locked by transaction %s", ErrConflict, id)
+			}
+			if err := reply.node.minioClient.RemoveObject(ctx, bucketName, lockMarkerKey(objectName, id), minio.RemoveObjectOptions{}); err != nil {
+				slog.Warn("Failed to remove lock marker",
+					slog.String("node_id", reply.node.ID),
+					slog.String("object_name", objectName),
+					slog.String("transaction_id", id),
+					slog.String("error", err.Error()))
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MinioGateway) checkUnlocked(ctx context.Context, key string) error {
+	owners, _, err := m.lockOwners(ctx, key)
+	if err != nil {
+		return err
+	}
+	for _, owner := range owners {
+		finished, err := m.recoverTxn(ctx, owner)
+		if err != nil {
+			return err
+		}
+		if !finished {
+			return fmt.Errorf("%w: item is locked by transaction %s", ErrConflict, owner)
+		}
+		if err := m.unlock(ctx, owner, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lock takes the lock key for transaction id, taking over locks of
+// finished transactions.
+func (m *MinioGateway) lock(ctx context.Context, id, key string) (CancellationReason, error) {
+	for range maxCASAttempts {
+		_, err := m.write(ctx, key, []byte(id), false, PutOptions{Consistency: ConsistencyQuorum, IfNoneMatch: true})
+		if !errors.Is(err, ErrPreconditionFailed) {
+			return CancellationReason{Code: ReasonNone}, err
+		}
+		owners, _, err := m.lockOwners(ctx, key)
+		if err != nil {
+			return CancellationReason{}, err
+		}
+		// Racing writes may leave the lock with several owners.
+		if len(owners) == 1 && owners[0] == id {
+			return CancellationReason{Code: ReasonNone}, nil
+		}
+		for _, owner := range owners {
+			if owner == id {
+				continue
+			}
+			finished, err := m.recoverTxn(ctx, owner)
+			if err != nil {
+				return CancellationReason{}, err
+			}
+			if !finished {
+				return CancellationReason{Code: ReasonTransactionConflict, Message: fmt.Sprintf("item is locked by transaction %s", owner)}, nil
+			}
+			if err := m.unlock(ctx, owner, key); err != nil {
+				return CancellationReason{}, err
+			}
+		}
+	}
+	return CancellationReason{}, fmt.Errorf("%w: lock %s changed concurrently %d times", ErrConflict, key, maxCASAttempts)
+}
+
+func (m *MinioGateway) lockOwners(ctx context.Context, key string) ([]string, []Version, error) {
+	object, err := m.Get(ctx, key, GetOptions{Consistency: ConsistencyQuorum})
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var owners []string
+	var versions []Version
+	for _, v := range object.Siblings {
+		owner, err := v.read(ctx)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		owners = append(owners, string(owner))
+		versions = append(versions, v)
+	}
+	return owners, versions, nil
+}
+
+func (m *MinioGateway) unlock(ctx context.Context, id, key string) error {
+	owners, versions, err := m.lockOwners(ctx, key)
+	if err != nil {
+		return err
+	}
+	for i, owner := range owners {
+		if owner != id {
+			continue
+		}
+		if _, err := m.write(ctx, key, nil, true, PutOptions{Consistency: ConsistencyQuorum, Context: versions[i].Clock}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeTxn(ctx context.Context, object *Object) (txnRecord, error) {
+	var record txnRecord
+	data, err := object.Siblings[0].read(ctx)
+	if err != nil {
+		return record, err
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, fmt.Errorf("invalid transaction record %s: %w", object.Name, err)
+	}
+	return record, nil
+}
+
+// decideTxn moves transaction id out of the pending state. It returns the
+// record as decided, by this call or an earlier one.
+func (m *MinioGateway) decideTxn(ctx context.Context, id, state string, writes []txnWrite) (txnRecord, error) {
+	var record txnRecord
+	_, err := m.readModifyWrite(ctx, txnKey(id), PutOptions{Consistency: ConsistencyQuorum}, func(object *Object, _ *PutOptions) ([]byte, bool, error) {
+		if object == nil {
+			return nil, false, fmt.Errorf("transaction %s: %w", id, ErrNotFound)
+		}
+		var err error
+		if record, err = decodeTxn(ctx, object); err != nil {
+			return nil, false, err
+		}
+		if record.State != txnPending {
+			return nil, false, errTxnDecided
+		}
+		record.State = state
+		if writes != nil {
+			record.Writes = writes
+		}
+		body, err := json.Marshal(record)
+		return body, false, err
+	})
+	if errors.Is(err, errTxnDecided) {
+		return record, nil
+	}
+	return record, err
+}
+
+// finishTxn applies the writes of a committed transaction, releases its
+// locks and removes its record. Every step can be run again.
+func (m *MinioGateway) finishTxn(ctx context.Context, record txnRecord) error {
+	if record.State == txnCommitted {
+		for _, w := range record.Writes {
+			if err := m.applyTxnWrite(ctx, w); err != nil {
+				return err
+			}
+		}
+	}
+	for _, w := range record.Writes {
+		m.unmark(ctx, record.ID, strings.TrimPrefix(w.Lock, locksPrefix))
+		if err := m.unlock(ctx, record.ID, w.Lock); err != nil {
+			return err
+		}
+	}
+	_, err := m.write(ctx, txnKey(record.ID), nil, true, PutOptions{Consistency: ConsistencyQuorum})
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (m *MinioGateway) applyTxnWrite(ctx context.Context, w txnWrite) error {
+	if w.Version == "" {
+		return nil
+	}
+	version, err := vclock.Decode(w.Version)
+	if err != nil {
+		return fmt.Errorf("invalid version of %s in transaction: %w", w.Name, err)
+	}
+	t, err := m.DescribeTable(ctx, w.Table)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = m.write(ctx, w.Name, w.Body, w.Tombstone, PutOptions{
+		Consistency: ConsistencyQuorum,
+		Table:       t.Name,
+		Context:     version,
+		version:     version,
+		ExpiresAt:   w.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	m.updateIndexes(ctx, t, w.Name, w.Old)
+	return nil
+}
+
+// recoverTxn finishes transaction id when its record went unchanged for
+// txnTimeout. It reports whether the transaction is finished.
+func (m *MinioGateway) recoverTxn(ctx context.Context, id string) (bool, error) {
+	object, err := m.Get(ctx, txnKey(id), GetOptions{Consistency: ConsistencyQuorum})
+	if errors.Is(err, ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if time.Since(object.Siblings[0].Info.LastModified) < txnTimeout {
+		return false, nil
+	}
+	record, err := m.decideTxn(ctx, id, txnAborted, nil)
+	if errors.Is(err, ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	slog.Info("Recovering transaction", slog.String("transaction_id", id), slog.String("state", record.State))
+	if err := m.finishTxn(ctx, record); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RecoverTransactions finishes the transactions whose coordinator went away.
+func (m *MinioGateway) RecoverTransactions(ctx context.Context) error {
+	ids := make(map[string]bool)
+	var errs []error
+	for _, node := range m.healthyNodes(mapValues(m.topology().nodes)) {
+		for object := range node.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: txnsPrefix, Recursive: true}) {
+			if object.Err != nil {
+				errs = append(errs, fmt.Errorf("node %s: %w", node.ID, object.Err))
+				break
+			}
+			if object.Size > 0 && time.Since(object.LastModified) >= txnTimeout {
+				ids[strings.TrimPrefix(object.Key, txnsPrefix)] = true
+			}
+		}
+	}
+	for _, id := range sortedKeys(ids) {
+		if _, err := m.recoverTxn(ctx, id); err != nil {
+			errs = append(errs, fmt.Errorf("transaction %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vrnvu/go-dynamolike/internal/item"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestTransactionCanceledErrorCause(t *testing.T) {
+	failed := &TransactionCanceledError{Reasons: []CancellationReason{
+		{Code: ReasonNone},
+		{Code: ReasonConditionalCheckFailed},
+	}}
+	assert.ErrorIs(t, failed, ErrPreconditionFailed)
+	assert.NotErrorIs(t, failed, ErrConflict)
+	assert.Contains(t, failed.Error(), "[None, ConditionalCheckFailed]")
+
+	conflict := &TransactionCanceledError{Reasons: []CancellationReason{
+		{Code: ReasonConditionalCheckFailed},
+		{Code: ReasonTransactionConflict},
+	}}
+	assert.ErrorIs(t, conflict, ErrConflict, "conflicts are worth retrying")
+}
+
+func TestTransactWriteItemsRejectsInvalidTransactions(t *testing.T) {
+	gateway := &MinioGateway{}
+	gateway.catalog.store(catalog{Tables: map[string]Table{"users": {Name: "users", ID: "1234"}}})
+	alice := item.Item{"id": item.String("alice")}
+
+	tests := map[string][]TransactItem{
+		"empty":                   nil,
+		"same item twice":         {{Action: TransactPut, Table: "users", Item: alice}, {Action: TransactDelete, Table: "users", Key: alice}},
+		"unknown action":          {{Action: "Scan", Table: "users", Key: alice}},
+		"check without condition": {{Action: TransactConditionCheck, Table: "users", Key: alice}},
+		"update of the key":       {{Action: TransactUpdate, Table: "users", Key: alice, Update: "REMOVE id"}},
+	}
+	for name, items := range tests {
+		err := gateway.TransactWriteItems(context.Background(), items)
+		assert.ErrorIs(t, err, ErrInvalidArgument, name)
+	}
+}
+
+// lockItem locks and marks the item named name of table for transaction id,
+// which is still running unless finished.
+func lockItem(t *testing.T, s3 *fakeS3, table Table, name, id string, finished bool) {
+	t.Helper()
+	s3.put(lockKey(table.key(name)), []byte(id), VersionMeta{Clock: vclock.Clock{"l": 1}}, time.Now())
+	s3.put(lockMarkerKey(table.key(name), id), nil, VersionMeta{}, time.Now())
+	if finished {
+		return
+	}
+	body, err := json.Marshal(txnRecord{ID: id, State: txnPending})
+	require.NoError(t, err)
+	s3.put(txnKey(id), body, VersionMeta{Clock: vclock.Clock{"t": 1}}, time.Now())
+}
+
+func TestWriteOfLockedItemFailsWithConflict(t *testing.T) {
+	gateway, s3, table := newStreamedGateway(t)
+	lockItem(t, s3, table, "order-1", "txn-1", false)
+
+	err := gateway.PutItem(context.Background(), "orders", item.Item{"id": item.String("order-1")}, WriteItemOptions{})
+
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.False(t, s3.Has(table.key("order-1")))
+}
+
+func TestWriteRemovesMarkerOfFinishedTransaction(t *testing.T) {
+	gateway, s3, table := newStreamedGateway(t)
+	lockItem(t, s3, table, "order-1", "txn-1", true)
+
+	err := gateway.PutItem(context.Background(), "orders", item.Item{"id": item.String("order-1")}, WriteItemOptions{})
+
+	assert.NoError(t, err)
+	assert.False(t, s3.Has(lockMarkerKey(table.key("order-1"), "txn-1")))
+}
+
+func TestWriteOfUnmarkedItemDoesntReadItsLock(t *testing.T) {
+	gateway, s3, _ := newStreamedGateway(t)
+	var mu sync.Mutex
+	var keys []string
+	s3.Before = func(_, key string) {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, key)
+	}
+
+	err := gateway.PutItem(context.Background(), "orders", item.Item{"id": item.String("order-1")}, WriteItemOptions{})
+
+	require.NoError(t, err)
+	mu.Lock()
+	defer mu.Unlock()
+	for _, key := range keys {
+		assert.False(t, strings.HasPrefix(key, locksPrefix), "read %s", key)
+	}
+}
+
+func TestTransactWriteItemsRemovesItsLockMarkers(t *testing.T) {
+	gateway, s3, table := newStreamedGateway(t)
+
+	err := gateway.TransactWriteItems(context.Background(), []TransactItem{
+		{Action: TransactPut, Table: "orders", Item: item.Item{"id": item.String("order-1")}},
+		{Action: TransactPut, Table: "orders", Item: item.Item{"id": item.String("order-2")}},
+	})
+
+	require.NoError(t, err)
+	assert.True(t, s3.Has(table.key("order-1")))
+	assert.True(t, s3.Has(table.key("order-2")))
+	for _, key := range s3.Keys() {
+		assert.False(t, isLockMarker(key), "marker %s is left", key)
+	}
+	err = gateway.PutItem(context.Background(), "orders", item.Item{"id": item.String("order-1"), "status": item.String("paid")}, WriteItemOptions{})
+	assert.NoError(t, err)
+}
+
+func TestVerifyPreparedCancelsWhenItemChanged(t *testing.T) {
+	gateway, s3, table := newStreamedGateway(t)
+	op := transactOp{TransactItem: TransactItem{Action: TransactPut, Table: "orders"}, table: table, name: "order-1"}
+	s3.put(table.key("order-1"), []byte(`{"id":{"S":"order-1"}}`), VersionMeta{Clock: vclock.Clock{"g": 1}}, time.Now())
+	prepared := txnWrite{read: []string{vclock.Clock{"g": 1}.Version()}}
+
+	reason, err := gateway.verifyPrepared(context.Background(), op, prepared)
+	require.NoError(t, err)
+	assert.Equal(t, ReasonNone, reason.Code)
+
+	// A single item write that checked the lock before it was taken lands.
+	s3.put(table.key("order-1"), []byte(`{"id":{"S":"order-1"}}`), VersionMeta{Clock: vclock.Clock{"g": 2}}, time.Now())
+
+	reason, err = gateway.verifyPrepared(context.Background(), op, prepared)
+	require.NoError(t, err)
+	assert.Equal(t, ReasonTransactionConflict, reason.Code)
+}
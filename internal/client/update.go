@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// modifyFunc returns what to write in place of object, nil when the object
+// doesn't exist.
+type modifyFunc func(object *Object, opts *PutOptions) (body []byte, tombstone bool, err error)
+
+// readModifyWrite reads objectName, passes it to modify and writes back the
+// result on the condition that the object didn't change in between, starting
+// over when it did.
+func (m *MinioGateway) readModifyWrite(ctx context.Context, objectName string, opts PutOptions, modify modifyFunc) (PutResult, error) {
+	for range maxCASAttempts {
+		object, err := m.Get(ctx, objectName, GetOptions{Consistency: opts.Consistency, Table: opts.Table})
+		if errors.Is(err, ErrNotFound) {
+			object = nil
+		} else if err != nil {
+			return PutResult{}, err
+		}
+		writeOpts := opts
+		body, tombstone, err := modify(object, &writeOpts)
+		if err != nil {
+			return PutResult{}, err
+		}
+		if object == nil && tombstone {
+			return PutResult{}, nil
+		}
+
+		switch {
+		case object == nil:
+			writeOpts.IfNoneMatch = true
+		case len(object.Siblings) == 1:
+			writeOpts.IfMatch = object.Siblings[0].ID()
+		default:
+			writeOpts.Context = object.Context
+			writeOpts.ifSiblings = versionIDs(object.Siblings)
+		}
+		result, err := m.write(ctx, objectName, body, tombstone, writeOpts)
+		if errors.Is(err, ErrPreconditionFailed) {
+			continue
+		}
+		return result, err
+	}
+	return PutResult{}, fmt.Errorf("%w: object %s changed concurrently %d times", ErrConflict, objectName, maxCASAttempts)
+}
@@ -0,0 +1,321 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// Large bodies are staged under uploadsPrefix on a single node before being
+	// written to the replicas.
+	uploadsPrefix = reservedPrefix + "uploads/"
+
+	maxBufferedBody = 16 << 20
+	// The MinIO client buffers a part at a time.
+	stagingPartSize = 16 << 20
+
+	MaxUploadParts  = 10000
+	uploadRetention = 7 * 24 * time.Hour
+)
+
+// Part is a part of a multipart upload.
+type Part struct {
+	PartNumber int    `json:"part_number"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size,omitempty"`
+}
+
+// uploadToken is what a multipart upload ID encodes: the node the parts are
+// uploaded to and the upload on that node.
+type uploadToken struct {
+	Node   string `json:"node"`
+	Key    string `json:"key"`
+	Upload string `json:"upload"`
+}
+
+func (t uploadToken) encode() string {
+	data, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parseUploadToken(objectName, uploadID string) (uploadToken, error) {
+	var token uploadToken
+	data, err := base64.RawURLEncoding.DecodeString(uploadID)
+	if err == nil {
+		err = json.Unmarshal(data, &token)
+	}
+	if err != nil || !strings.HasPrefix(token.Key, uploadsPrefix) || !strings.HasSuffix(token.Key, "/"+objectName) {
+		return token, fmt.Errorf("%w: upload ID %q of object %s", ErrInvalidArgument, uploadID, objectName)
+	}
+	return token, nil
+}
+
+func stagingKey(objectName string) string {
+	return uploadsPrefix + uuid.New().String() + "/" + objectName
+}
+
+// nodeLocal reports whether key is kept by a single node rather than
+// replicated.
+func nodeLocal(key string) bool {
+	return strings.HasPrefix(key, hintsPrefix) || strings.HasPrefix(key, uploadsPrefix) || isLockMarker(key)
+}
+
+type stagedPayload struct {
+	node     *MinioNode
+	key      string
+	length   int64
+	retained bool
+}
+
+func (p stagedPayload) open(ctx context.Context) (io.ReadCloser, error) {
+	object, err := p.node.Get(ctx, p.key)
+	if err != nil {
+		return nil, nodeError(err)
+	}
+	return object, nil
+}
+
+func (p stagedPayload) size() int64 { return p.length }
+
+func (p stagedPayload) release(ctx context.Context, written bool) {
+	if p.retained && !written {
+		return
+	}
+	if err := p.node.minioClient.RemoveObject(ctx, bucketName, p.key, minio.RemoveObjectOptions{}); err != nil {
+		slog.Warn("Failed to remove staged upload",
+			slog.String("node_id", p.node.ID),
+			slog.String("key", p.key),
+			slog.String("error", err.Error()))
+	}
+}
+
+// sizedReader fails with ErrInvalidArgument when its body ends before the
+// length it was announced with.
+type sizedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (s *sizedReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.remaining -= int64(n)
+	if err == io.EOF && s.remaining > 0 {
+		return n, fmt.Errorf("%w: body ended %d bytes short of its length", ErrInvalidArgument, s.remaining)
+	}
+	return n, err
+}
+
+func (m *MinioGateway) stagingNode(objectName string, factor int) (*MinioNode, error) {
+	nodes, err := m.topology().preferenceList(objectName, factor)
+	if err != nil {
+		return nil, err
+	}
+	if healthy := m.healthyNodes(nodes); len(healthy) > 0 {
+		return healthy[0], nil
+	}
+	return nil, fmt.Errorf("%w: no healthy node to stage object %s on", ErrNodeUnavailable, objectName)
+}
+
+// putStaged stages body on a node and writes it from there to every
+// replica.
+func (m *MinioGateway) putStaged(ctx context.Context, name string, body io.Reader, size int64, opts PutOptions) (PutResult, error) {
+	objectName, rep, err := m.locate(ctx, opts.Table, opts.index, name)
+	if err != nil {
+		return PutResult{}, err
+	}
+	node, err := m.stagingNode(objectName, rep.factor)
+	if err != nil {
+		return PutResult{}, err
+	}
+	var stageOpts minio.PutObjectOptions
+	if size < 0 {
+		stageOpts.PartSize = stagingPartSize
+	}
+	key := stagingKey(objectName)
+	if size >= 0 {
+		body = &sizedReader{r: body, remaining: size}
+	}
+	info, err := node.minioClient.PutObject(ctx, bucketName, key, body, size, stageOpts)
+	if errors.Is(err, ErrInvalidArgument) {
+		return PutResult{}, fmt.Errorf("object %s: %w", name, err)
+	}
+	if err != nil {
+		return PutResult{}, fmt.Errorf("failed to stage object %s on node %s: %w", name, node.ID, nodeError(err))
+	}
+
+	return m.writePayload(ctx, name, stagedPayload{node: node, key: key, length: info.Size}, false, opts)
+}
+
+func (m *MinioGateway) uploadNode(ctx context.Context, name, uploadID string, opts PutOptions) (*MinioNode, uploadToken, error) {
+	objectName, _, err := m.locate(ctx, opts.Table, opts.index, name)
+	if err != nil {
+		return nil, uploadToken{}, err
+	}
+	token, err := parseUploadToken(objectName, uploadID)
+	if err != nil {
+		return nil, token, err
+	}
+	node, ok := m.topology().nodes[token.Node]
+	if !ok {
+		return nil, token, fmt.Errorf("%w: node %s of the upload left the cluster", ErrNotFound, token.Node)
+	}
+	return node, token, nil
+}
+
+func core(node *MinioNode) minio.Core {
+	return minio.Core{Client: node.minioClient}
+}
+
+// CreateMultipartUpload starts a multipart upload of name on the node
+// owning it and returns its upload ID.
+func (m *MinioGateway) CreateMultipartUpload(ctx context.Context, name string, opts PutOptions) (string, error) {
+	objectName, rep, err := m.locate(ctx, opts.Table, opts.index, name)
+	if err != nil {
+		return "", err
+	}
+	node, err := m.stagingNode(objectName, rep.factor)
+	if err != nil {
+		return "", err
+	}
+	token := uploadToken{Node: node.ID, Key: stagingKey(objectName)}
+	if token.Upload, err = core(node).NewMultipartUpload(ctx, bucketName, token.Key, minio.PutObjectOptions{}); err != nil {
+		return "", nodeError(err)
+	}
+	return token.encode(), nil
+}
+
+// UploadPart uploads a part of a multipart upload. size must be known.
+func (m *MinioGateway) UploadPart(ctx context.Context, name, uploadID string, partNumber int, body io.Reader, size int64, opts PutOptions) (Part, error) {
+	if partNumber < 1 || partNumber > MaxUploadParts {
+		return Part{}, fmt.Errorf("%w: part number %d, expected between 1 and %d", ErrInvalidArgument, partNumber, MaxUploadParts)
+	}
+	if size < 0 {
+		return Part{}, fmt.Errorf("%w: parts must have a known length", ErrInvalidArgument)
+	}
+	node, token, err := m.uploadNode(ctx, name, uploadID, opts)
+	if err != nil {
+		return Part{}, err
+	}
+	part, err := core(node).PutObjectPart(ctx, bucketName, token.Key, token.Upload, partNumber, body, size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return Part{}, uploadError(err)
+	}
+	return Part{PartNumber: part.PartNumber, ETag: part.ETag, Size: part.Size}, nil
+}
+
+func (m *MinioGateway) ListParts(ctx context.Context, name, uploadID string, opts PutOptions) ([]Part, error) {
+	node, token, err := m.uploadNode(ctx, name, uploadID, opts)
+	if err != nil {
+		return nil, err
+	}
+	var parts []Part
+	marker := 0
+	for {
+		result, err := core(node).ListObjectParts(ctx, bucketName, token.Key, token.Upload, marker, 1000)
+		if err != nil {
+			return nil, uploadError(err)
+		}
+		for _, part := range result.ObjectParts {
+			parts = append(parts, Part{PartNumber: part.PartNumber, ETag: part.ETag, Size: part.Size})
+		}
+		if !result.IsTruncated {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+// CompleteMultipartUpload assembles parts into the body of name and writes
+// it like Put does. A failed write can be retried by completing again.
+func (m *MinioGateway) CompleteMultipartUpload(ctx context.Context, name, uploadID string, parts []Part, opts PutOptions) (PutResult, error) {
+	if len(parts) == 0 {
+		return PutResult{}, fmt.Errorf("%w: an upload has at least one part", ErrInvalidArgument)
+	}
+	node, token, err := m.uploadNode(ctx, name, uploadID, opts)
+	if err != nil {
+		return PutResult{}, err
+	}
+	completed := make([]minio.CompletePart, len(parts))
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return PutResult{}, fmt.Errorf("%w: parts must be in increasing part number order", ErrInvalidArgument)
+		}
+		completed[i] = minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag}
+	}
+	_, err = core(node).CompleteMultipartUpload(ctx, bucketName, token.Key, token.Upload, completed, minio.PutObjectOptions{})
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchUpload" {
+		return PutResult{}, uploadError(err)
+	}
+
+	info, err := node.minioClient.StatObject(ctx, bucketName, token.Key, minio.StatObjectOptions{})
+	if err != nil {
+		return PutResult{}, uploadError(err)
+	}
+	return m.writePayload(ctx, name, stagedPayload{node: node, key: token.Key, length: info.Size, retained: true}, false, opts)
+}
+
+// AbortMultipartUpload removes a multipart upload and its parts.
+func (m *MinioGateway) AbortMultipartUpload(ctx context.Context, name, uploadID string, opts PutOptions) error {
+	node, token, err := m.uploadNode(ctx, name, uploadID, opts)
+	if err != nil {
+		return err
+	}
+	if err := core(node).AbortMultipartUpload(ctx, bucketName, token.Key, token.Upload); err != nil {
+		return uploadError(err)
+	}
+	return nil
+}
+
+func uploadError(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchUpload", "NoSuchKey":
+		return fmt.Errorf("%w: upload: %w", ErrNotFound, err)
+	case "InvalidPart", "InvalidPartOrder", "EntityTooSmall":
+		return fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+	default:
+		return nodeError(err)
+	}
+}
+
+// CollectUploads removes the multipart uploads and staged bodies left
+// behind for longer than the retention period.
+func (m *MinioGateway) CollectUploads(ctx context.Context) error {
+	var errs []error
+	for _, node := range m.healthyNodes(mapValues(m.topology().nodes)) {
+		for upload := range node.minioClient.ListIncompleteUploads(ctx, bucketName, uploadsPrefix, true) {
+			if upload.Err != nil {
+				errs = append(errs, fmt.Errorf("node %s: %w", node.ID, upload.Err))
+				break
+			}
+			if time.Since(upload.Initiated) < uploadRetention {
+				continue
+			}
+			if err := core(node).AbortMultipartUpload(ctx, bucketName, upload.Key, upload.UploadID); err != nil {
+				errs = append(errs, fmt.Errorf("node %s: %w", node.ID, err))
+			}
+		}
+		for object := range node.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: uploadsPrefix, Recursive: true}) {
+			if object.Err != nil {
+				errs = append(errs, fmt.Errorf("node %s: %w", node.ID, object.Err))
+				break
+			}
+			if time.Since(object.LastModified) < uploadRetention {
+				continue
+			}
+			if err := node.minioClient.RemoveObject(ctx, bucketName, object.Key, minio.RemoveObjectOptions{}); err != nil {
+				errs = append(errs, fmt.Errorf("node %s: %w", node.ID, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
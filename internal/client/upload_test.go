@@ -0,0 +1,92 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func TestUploadTokenRoundTrip(t *testing.T) {
+	token := uploadToken{Node: "minio1", Key: stagingKey("photos/cat.jpg"), Upload: "abc"}
+
+	parsed, err := parseUploadToken("photos/cat.jpg", token.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, token, parsed)
+}
+
+func TestParseUploadTokenRejectsOtherObjects(t *testing.T) {
+	token := uploadToken{Node: "minio1", Key: stagingKey("cat.jpg"), Upload: "abc"}
+
+	_, err := parseUploadToken("dog.jpg", token.encode())
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	_, err = parseUploadToken("cat.jpg", "not-a-token")
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+
+	token.Key = "cat.jpg"
+	_, err = parseUploadToken("cat.jpg", token.encode())
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestStagedUploadsAreNodeLocal(t *testing.T) {
+	assert.True(t, nodeLocal(stagingKey("cat.jpg")))
+	assert.True(t, nodeLocal(hintsPrefix+"minio2/cat.jpg"))
+	assert.False(t, nodeLocal("cat.jpg"))
+}
+
+func TestUploadPartValidatesPartNumber(t *testing.T) {
+	m := &MinioGateway{}
+	for _, partNumber := range []int{0, MaxUploadParts + 1} {
+		_, err := m.UploadPart(context.Background(), "cat.jpg", "upload", partNumber, strings.NewReader("x"), 1, PutOptions{})
+		assert.ErrorIs(t, err, ErrInvalidArgument)
+	}
+
+	_, err := m.UploadPart(context.Background(), "cat.jpg", "upload", 1, strings.NewReader("x"), -1, PutOptions{})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestFailedStagedPutKeepsTheStageUntilReplicasFinish(t *testing.T) {
+	a, aS3 := newFakeNode(t, "a")
+	b, bS3 := newFakeNode(t, "b")
+	c, cS3 := newFakeNode(t, "c")
+	gateway := newFakeGateway(a, b, c)
+	fakes := map[string]*fakeS3{"a": aS3, "b": bS3, "c": cS3}
+	nodes, err := gateway.topology().preferenceList("key", 3)
+	assert.NoError(t, err)
+	staging, failing, slow := fakes[nodes[0].ID], fakes[nodes[1].ID], fakes[nodes[2].ID]
+
+	// One replica fails the write at once while another is still writing
+	// when the write gives up on its quorum.
+	failing.Deny.Store(true)
+	unblock := make(chan struct{})
+	slow.Before = func(string, string) { <-unblock }
+
+	body := bytes.Repeat([]byte("x"), maxBufferedBody+1)
+	_, err = gateway.Put(context.Background(), "key", bytes.NewReader(body), int64(len(body)),
+		PutOptions{Consistency: ConsistencyAll, Context: vclock.Clock{"g": 1}})
+	assert.ErrorIs(t, err, ErrNodeUnavailable)
+	close(unblock)
+
+	assert.Eventually(t, func() bool {
+		return !slices.ContainsFunc(staging.Keys(), func(key string) bool { return strings.HasPrefix(key, uploadsPrefix) })
+	}, 10*time.Second, 10*time.Millisecond, "the staged body is removed once every replica finished")
+	versions, err := nodes[2].Versions(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1, "the replica writing late wrote the whole body")
+}
+
+func TestPutRejectsTruncatedBodies(t *testing.T) {
+	node, _ := newFakeNode(t, "a")
+	gateway := newFakeGateway(node)
+
+	for _, size := range []int64{16, maxBufferedBody + 16} {
+		body := bytes.Repeat([]byte("x"), int(size)-1)
+		_, err := gateway.Put(context.Background(), "key", bytes.NewReader(body), size, PutOptions{})
+		assert.ErrorIs(t, err, ErrInvalidArgument, "a body of %d bytes declared as %d", len(body), size)
+	}
+}
@@ -0,0 +1,422 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+const (
+	// Keys under reservedPrefix are owned by the gateway and never exposed as objects.
+	reservedPrefix = ".dynamolike/"
+	siblingsPrefix = reservedPrefix + "siblings/"
+
+	clockMetadataKey     = "Vclock"
+	tombstoneMetadataKey = "Tombstone"
+	// Expires alone would be taken for the HTTP header.
+	expiresAtMetadataKey = "Expires-At"
+
+	maxCASAttempts = 5
+)
+
+// VersionMeta is stored as user metadata next to the body of a version.
+type VersionMeta struct {
+	Clock vclock.Clock
+	// Tombstone marks a deleted object. It replaces the value so read repair
+	// and anti-entropy can't bring it back.
+	Tombstone    bool
+	UserMetadata map[string]string
+	ExpiresAt    time.Time
+}
+
+func (v VersionMeta) expired(now time.Time) bool {
+	return !v.ExpiresAt.IsZero() && !now.Before(v.ExpiresAt)
+}
+
+func (v VersionMeta) userMetadata() map[string]string {
+	metadata := make(map[string]string, len(v.UserMetadata)+2)
+	for key, value := range v.UserMetadata {
+		metadata[key] = value
+	}
+	metadata[clockMetadataKey] = v.Clock.Encode()
+	if v.Tombstone {
+		metadata[tombstoneMetadataKey] = "true"
+	} else {
+		delete(metadata, tombstoneMetadataKey)
+	}
+	if v.ExpiresAt.IsZero() {
+		delete(metadata, expiresAtMetadataKey)
+	} else {
+		metadata[expiresAtMetadataKey] = strconv.FormatInt(v.ExpiresAt.Unix(), 10)
+	}
+	return metadata
+}
+
+func parseVersionMeta(metadata minio.StringMap) (VersionMeta, error) {
+	var user map[string]string
+	for key, value := range metadata {
+		if key == clockMetadataKey || key == tombstoneMetadataKey || key == expiresAtMetadataKey {
+			continue
+		}
+		if user == nil {
+			user = make(map[string]string)
+		}
+		user[key] = value
+	}
+	meta := VersionMeta{Clock: vclock.Clock{}, Tombstone: metadata[tombstoneMetadataKey] == "true", UserMetadata: user}
+	if expiresAt, ok := metadata[expiresAtMetadataKey]; ok {
+		seconds, err := strconv.ParseInt(expiresAt, 10, 64)
+		if err != nil {
+			return meta, fmt.Errorf("invalid expiry %q: %w", expiresAt, err)
+		}
+		meta.ExpiresAt = time.Unix(seconds, 0)
+	}
+	clock, err := vclock.Decode(metadata[clockMetadataKey])
+	if err != nil {
+		return meta, err
+	}
+	meta.Clock = clock
+	return meta, nil
+}
+
+// Version is one stored copy of an object on a node.
+type Version struct {
+	VersionMeta
+	Info minio.ObjectInfo
+	node *MinioNode
+}
+
+func (v Version) ID() string {
+	return v.Clock.Version()
+}
+
+// Open returns the body of the version. It fails with ErrNotFound when the
+// version was replaced since it was read.
+func (v Version) Open(ctx context.Context) (*minio.Object, error) {
+	object, err := v.node.Get(ctx, v.Info.Key)
+	if err != nil {
+		return nil, nodeError(err)
+	}
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, fmt.Errorf("version %s of %s on node %s: %w", v.ID(), v.Info.Key, v.node.ID, nodeError(err))
+	}
+	return object, nil
+}
+
+func (v Version) read(ctx context.Context) ([]byte, error) {
+	body, err := v.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, nodeError(err)
+	}
+	return data, nil
+}
+
+// Object is the result of a read: every version seen on the replicas that is
+// not superseded by another one.
+type Object struct {
+	Name     string
+	Siblings []Version
+	// Context merges the clocks of all siblings. Writing back with it resolves the conflict.
+	Context vclock.Clock
+}
+
+func IsReserved(objectName string) bool {
+	return strings.HasPrefix(objectName, reservedPrefix)
+}
+
+func siblingKey(objectName string, clock vclock.Clock) string {
+	return siblingsPrefix + objectName + "/" + clock.Version()
+}
+
+// reconcile drops duplicated and superseded versions and sorts the rest
+// newest first.
+func reconcile(versions []Version) []Version {
+	seen := make(map[string]bool)
+	var siblings []Version
+	for i, v := range versions {
+		if seen[v.ID()] {
+			continue
+		}
+		superseded := false
+		for j, other := range versions {
+			if i != j && other.Clock.Compare(v.Clock) == vclock.After {
+				superseded = true
+				break
+			}
+		}
+		if !superseded {
+			seen[v.ID()] = true
+			siblings = append(siblings, v)
+		}
+	}
+	sort.Slice(siblings, func(i, j int) bool {
+		if !siblings[i].Info.LastModified.Equal(siblings[j].Info.LastModified) {
+			return siblings[i].Info.LastModified.After(siblings[j].Info.LastModified)
+		}
+		return siblings[i].ID() > siblings[j].ID()
+	})
+	return siblings
+}
+
+func visible(siblings []Version) []Version {
+	now := time.Now()
+	var values []Version
+	for _, v := range siblings {
+		if !v.Tombstone && !v.expired(now) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func mergeClocks(versions []Version) vclock.Clock {
+	merged := vclock.Clock{}
+	for _, v := range versions {
+		merged = merged.Merge(v.Clock)
+	}
+	return merged
+}
+
+func (m *MinioNode) statVersion(ctx context.Context, key string) (Version, error) {
+	info, err := m.minioClient.StatObject(ctx, bucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		return Version{}, err
+	}
+	meta, err := parseVersionMeta(info.UserMetadata)
+	if err != nil {
+		slog.Warn("Ignoring invalid vector clock",
+			slog.String("node_id", m.ID),
+			slog.String("key", key),
+			slog.String("error", err.Error()))
+	}
+	return Version{VersionMeta: meta, Info: info, node: m}, nil
+}
+
+// Versions returns the stored copy of objectName and its siblings, if any.
+// Siblings are read first so one moved to the object key is never missed.
+func (m *MinioNode) Versions(ctx context.Context, objectName string) ([]Version, error) {
+	versions, _, err := m.versions(ctx, objectName)
+	return versions, err
+}
+
+func (m *MinioNode) versions(ctx context.Context, objectName string) ([]Version, []string, error) {
+	prefix := siblingsPrefix + objectName + "/"
+	var siblings []Version
+	var locks []string
+	for object := range m.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix}) {
+		if object.Err != nil {
+			return nil, nil, object.Err
+		}
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		if id, ok := strings.CutPrefix(strings.TrimPrefix(object.Key, prefix), lockMarkerPrefix); ok {
+			locks = append(locks, id)
+			continue
+		}
+		v, err := m.statVersion(ctx, object.Key)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return nil, nil, err
+		}
+		siblings = append(siblings, v)
+	}
+
+	var versions []Version
+	v, err := m.statVersion(ctx, objectName)
+	switch {
+	case err == nil:
+		versions = append(versions, v)
+	case !isNotFound(err):
+		return nil, nil, err
+	}
+	return append(versions, siblings...), locks, nil
+}
+
+// removeVersion removes the copy v was read from, unless a write replaced it
+// since, and reports whether it did.
+func (m *MinioNode) removeVersion(ctx context.Context, v Version) (bool, error) {
+	info, err := m.minioClient.StatObject(ctx, bucketName, v.Info.Key, minio.StatObjectOptions{})
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.ETag != v.Info.ETag {
+		return false, nil
+	}
+	if err := m.minioClient.RemoveObject(ctx, bucketName, v.Info.Key, minio.RemoveObjectOptions{}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// payload is the body of a version, opened by every replica on its own.
+type payload interface {
+	open(ctx context.Context) (io.ReadCloser, error)
+	size() int64
+	release(ctx context.Context, written bool)
+}
+
+type bytesPayload []byte
+
+func (p bytesPayload) open(context.Context) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(p)), nil
+}
+
+func (p bytesPayload) size() int64 { return int64(len(p)) }
+
+func (p bytesPayload) release(context.Context, bool) {}
+
+// PutVersion stores body as the version of objectName described by meta.
+// Concurrent versions are kept as siblings and an older write is a no-op. A
+// conditional write must supersede every version on the node instead.
+func (m *MinioNode) PutVersion(ctx context.Context, objectName string, body []byte, meta VersionMeta, conditional bool) (minio.UploadInfo, error) {
+	return m.putVersion(ctx, objectName, bytesPayload(body), meta, conditional)
+}
+
+func (m *MinioNode) putVersion(ctx context.Context, objectName string, body payload, meta VersionMeta, conditional bool) (minio.UploadInfo, error) {
+	if conditional {
+		return m.putConditional(ctx, objectName, body, meta)
+	}
+	clock := meta.Clock
+	for range maxCASAttempts {
+		versions, err := m.Versions(ctx, objectName)
+		if err != nil {
+			return minio.UploadInfo{}, err
+		}
+
+		var current *Version
+		for i, v := range versions {
+			if v.Clock.Compare(clock) == vclock.After {
+				slog.Debug("Skipping obsolete write",
+					slog.String("node_id", m.ID),
+					slog.String("object_name", objectName),
+					slog.String("version", clock.Version()))
+				return minio.UploadInfo{Bucket: bucketName, Key: v.Info.Key, ETag: v.Info.ETag}, nil
+			}
+			if v.Info.Key == objectName {
+				current = &versions[i]
+			}
+		}
+
+		var stored *Version
+		key := objectName
+		opts := minio.PutObjectOptions{UserMetadata: meta.userMetadata()}
+		switch {
+		case current == nil:
+			opts.SetMatchETagExcept("*")
+		case clock.Compare(current.Clock) == vclock.After:
+			opts.SetMatchETag(current.Info.ETag)
+		case clock.Compare(current.Clock) == vclock.Equal:
+			stored = current
+		default:
+			key = siblingKey(objectName, clock)
+			for i, v := range versions {
+				if v.Info.Key == key {
+					stored = &versions[i]
+				}
+			}
+		}
+
+		var info minio.UploadInfo
+		if stored != nil {
+			info = minio.UploadInfo{Bucket: bucketName, Key: stored.Info.Key, ETag: stored.Info.ETag}
+		} else {
+			reader, err := body.open(ctx)
+			if err != nil {
+				return minio.UploadInfo{}, err
+			}
+			info, err = m.minioClient.PutObject(ctx, bucketName, key, reader, body.size(), opts)
+			reader.Close()
+			if isPreconditionFailed(err) {
+				continue
+			}
+			if err != nil {
+				return minio.UploadInfo{}, err
+			}
+		}
+
+		for _, v := range versions {
+			if v.Info.Key != objectName && v.Info.Key != key && clock.Descends(v.Clock) {
+				if err := m.minioClient.RemoveObject(ctx, bucketName, v.Info.Key, minio.RemoveObjectOptions{}); err != nil {
+					slog.Warn("Failed to remove superseded sibling",
+						slog.String("node_id", m.ID),
+						slog.String("key", v.Info.Key),
+						slog.String("error", err.Error()))
+				}
+			}
+		}
+		return info, nil
+	}
+	return minio.UploadInfo{}, fmt.Errorf("%w: object %s on node %s changed concurrently %d times", ErrConflict, objectName, m.ID, maxCASAttempts)
+}
+
+// putConditional writes the version of a conditional write at its sibling
+// key and checks what it supersedes again once it is written.
+func (m *MinioNode) putConditional(ctx context.Context, objectName string, body payload, meta VersionMeta) (minio.UploadInfo, error) {
+	key := siblingKey(objectName, meta.Clock)
+	if err := m.checkSupersedes(ctx, objectName, meta.Clock, key); err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	reader, err := body.open(ctx)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	opts := minio.PutObjectOptions{UserMetadata: meta.userMetadata()}
+	opts.SetMatchETagExcept("*")
+	info, err := m.minioClient.PutObject(ctx, bucketName, key, reader, body.size(), opts)
+	reader.Close()
+	if isPreconditionFailed(err) {
+		return minio.UploadInfo{}, fmt.Errorf("%w: version %s of %s on node %s was written already",
+			ErrPreconditionFailed, meta.Clock.Version(), objectName, m.ID)
+	}
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	if err := m.checkSupersedes(ctx, objectName, meta.Clock, key); err != nil {
+		if _, removeErr := m.removeVersion(ctx, Version{Info: minio.ObjectInfo{Key: key, ETag: info.ETag}}); removeErr != nil {
+			slog.Warn("Failed to remove refused conditional write",
+				slog.String("node_id", m.ID),
+				slog.String("key", key),
+				slog.String("error", removeErr.Error()))
+		}
+		return minio.UploadInfo{}, err
+	}
+	info.Key = objectName
+	return info, nil
+}
+
+func (m *MinioNode) checkSupersedes(ctx context.Context, objectName string, clock vclock.Clock, own string) error {
+	versions, err := m.Versions(ctx, objectName)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if v.Info.Key != own && clock.Compare(v.Clock) != vclock.After {
+			return fmt.Errorf("%w: version %s of %s on node %s was not read before writing",
+				ErrPreconditionFailed, v.ID(), objectName, m.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
+)
+
+func version(key string, clock vclock.Clock, modified time.Time) Version {
+	return Version{VersionMeta: VersionMeta{Clock: clock}, Info: minio.ObjectInfo{Key: key, LastModified: modified}}
+}
+
+func TestReconcileDropsSupersededVersions(t *testing.T) {
+	now := time.Now()
+	old := version("key", vclock.Clock{"a": 1}, now.Add(-time.Minute))
+	newer := version("key", vclock.Clock{"a": 2}, now)
+
+	siblings := reconcile([]Version{old, newer, newer})
+
+	assert.Equal(t, []Version{newer}, siblings)
+}
+
+func TestReconcileKeepsConcurrentVersions(t *testing.T) {
+	now := time.Now()
+	left := version("key", vclock.Clock{"a": 2, "b": 1}, now.Add(-time.Minute))
+	right := version(siblingKey("key", vclock.Clock{"a": 1, "c": 1}), vclock.Clock{"a": 1, "c": 1}, now)
+	old := version("key", vclock.Clock{"a": 1}, now.Add(-time.Hour))
+
+	siblings := reconcile([]Version{left, old, right})
+
+	assert.Equal(t, []Version{right, left}, siblings)
+	assert.Equal(t, vclock.Clock{"a": 2, "b": 1, "c": 1}, mergeClocks(siblings))
+}
+
+func TestIsReserved(t *testing.T) {
+	assert.True(t, IsReserved(siblingKey("key", vclock.Clock{"a": 1})))
+	assert.False(t, IsReserved("key"))
+	assert.False(t, IsReserved(".dynamolike"))
+}
+
+func TestVersionMetaRoundTrip(t *testing.T) {
+	meta := VersionMeta{Clock: vclock.Clock{"a": 2}, Tombstone: true, UserMetadata: map[string]string{"Owner": "team-a"}}
+
+	parsed, err := parseVersionMeta(meta.userMetadata())
+
+	assert.NoError(t, err)
+	assert.Equal(t, meta, parsed)
+
+	meta = VersionMeta{Clock: vclock.Clock{"a": 2}, UserMetadata: map[string]string{clockMetadataKey: "forged", tombstoneMetadataKey: "true"}}
+	assert.Equal(t, map[string]string{clockMetadataKey: meta.Clock.Encode()}, meta.userMetadata(),
+		"user metadata can't override the keys the gateway uses")
+
+	parsed, err = parseVersionMeta(minio.StringMap{})
+	assert.NoError(t, err)
+	assert.Equal(t, VersionMeta{Clock: vclock.Clock{}}, parsed, "objects written without metadata have an empty clock")
+}
+
+func TestVisibleDropsTombstones(t *testing.T) {
+	now := time.Now()
+	value := version("a", vclock.Clock{"a": 1}, now)
+	tombstone := version("b", vclock.Clock{"b": 1}, now)
+	tombstone.Tombstone = true
+
+	assert.Equal(t, []Version{value}, visible([]Version{value, tombstone}))
+	assert.Empty(t, visible([]Version{tombstone}))
+}
+
+func TestVisibleDropsExpiredVersions(t *testing.T) {
+	now := time.Now()
+	live := version("a", vclock.Clock{"a": 1}, now)
+	live.ExpiresAt = now.Add(time.Hour)
+	expired := version("b", vclock.Clock{"b": 1}, now)
+	expired.ExpiresAt = now.Add(-time.Second)
+
+	assert.Equal(t, []Version{live}, visible([]Version{live, expired}))
+
+	meta := VersionMeta{Clock: vclock.Clock{"a": 1}, ExpiresAt: time.Unix(1700000000, 0)}
+	parsed, err := parseVersionMeta(meta.userMetadata())
+	assert.NoError(t, err)
+	assert.Equal(t, meta, parsed)
+	listed, ok := listedExpiry(minio.ObjectInfo{UserMetadata: minio.StringMap{"X-Amz-Meta-Expires-At": "1700000000"}})
+	assert.True(t, ok)
+	assert.Equal(t, meta.ExpiresAt, listed)
+}
+
+func TestNextClockNeverRepeatsForTheSameBase(t *testing.T) {
+	m := &MinioGateway{id: "g"}
+	base := vclock.Clock{"g": 3, "h": 1}
+
+	first := m.nextClock(base)
+	second := m.nextClock(base)
+
+	assert.Equal(t, vclock.After, first.Compare(base))
+	assert.Equal(t, vclock.After, second.Compare(first), "concurrent writes through one gateway must not share a clock")
+	assert.Equal(t, uint64(1), second["h"])
+	assert.Equal(t, vclock.Clock{"g": 3, "h": 1}, base, "the base clock must not be modified")
+}
@@ -17,30 +17,39 @@ const CONTAINER_NAME = "minio"
 const CONTAINER_IMAGE = "minio/minio"
 const CONTAINER_PORT = "9000"
 
+// NODE_ID_LABEL overrides the node ID of a container, its name by default.
+const NODE_ID_LABEL = "dynamolike.node-id"
+
 type Registry interface {
 	GetInstances() []MinioInstance
 	GetInstance(key string) (MinioInstance, error)
 	AddInstance(containerID string, instance MinioInstance)
 	RemoveInstance(containerID string)
 	PollNetwork() error
+	// Subscribe returns a channel signalled, coalesced, when instances change.
+	Subscribe() <-chan struct{}
 }
 
 type MinioInstance struct {
-	ID            string
+	ID string
+	// NodeID survives the container being recreated, unlike ID.
+	NodeID        string
 	Name          string
 	IP            string
 	ContainerPort string
 	HostPort      string
 	User          string
 	Password      string
+	Healthy       bool
 }
 
 type DockerRegistry struct {
-	ctx       context.Context
-	network   string
-	reader    *sync.RWMutex
-	cli       *client.Client
-	instances map[string]MinioInstance
+	ctx         context.Context
+	network     string
+	reader      *sync.RWMutex
+	cli         *client.Client
+	instances   map[string]MinioInstance
+	subscribers []chan struct{}
 }
 
 func NewServiceRegistry(ctx context.Context, cli *client.Client, network string) *DockerRegistry {
@@ -57,12 +66,32 @@ func (r *DockerRegistry) AddInstance(containerID string, instance MinioInstance)
 	r.reader.Lock()
 	defer r.reader.Unlock()
 	r.instances[containerID] = instance
+	r.notify()
 }
 
 func (r *DockerRegistry) RemoveInstance(containerID string) {
 	r.reader.Lock()
 	defer r.reader.Unlock()
 	delete(r.instances, containerID)
+	r.notify()
+}
+
+func (r *DockerRegistry) Subscribe() <-chan struct{} {
+	r.reader.Lock()
+	defer r.reader.Unlock()
+	ch := make(chan struct{}, 1)
+	r.subscribers = append(r.subscribers, ch)
+	return ch
+}
+
+// notify signals subscribers without blocking. Callers hold the lock.
+func (r *DockerRegistry) notify() {
+	for _, ch := range r.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
 }
 
 func (r *DockerRegistry) GetInstances() []MinioInstance {
@@ -87,11 +116,12 @@ func (r *DockerRegistry) GetInstance(key string) (MinioInstance, error) {
 
 func (r *DockerRegistry) PollNetwork() error {
 	slog.Info("Polling network for Minio instances")
+	// Stopped containers are listed too, to mark their instances unhealthy.
 	containers, err := r.cli.ContainerList(r.ctx, container.ListOptions{
+		All: true,
 		Filters: filters.NewArgs(
 			filters.Arg("name", CONTAINER_NAME),
 			filters.Arg("ancestor", CONTAINER_IMAGE),
-			filters.Arg("status", "running"),
 			filters.Arg("network", r.network),
 		),
 	})
@@ -101,8 +131,15 @@ func (r *DockerRegistry) PollNetwork() error {
 	}
 	slog.Info("Found containers", "count", len(containers))
 
+	listed := make(map[string]bool, len(containers))
 	for _, container := range containers {
+		listed[container.ID] = true
+		healthy := isContainerHealthy(container)
 		if r.isInstanceRegistered(container.ID) {
+			r.updateInstance(container.ID, healthy, r.containerIP(container))
+			continue
+		}
+		if container.State != "running" {
 			continue
 		}
 
@@ -111,15 +148,64 @@ func (r *DockerRegistry) PollNetwork() error {
 			slog.Error("Error getting Minio instance", "containerID", container.ID, "error", err)
 			continue
 		}
+		instance.Healthy = healthy
 		r.AddInstance(container.ID, instance)
 		slog.Info("Found Minio instance", "instance", instance)
 	}
 
+	for _, instance := range r.GetInstances() {
+		if !listed[instance.ID] {
+			r.RemoveInstance(instance.ID)
+			slog.Info("Removed Minio instance", "instance", instance)
+		}
+	}
+
 	return nil
 }
 
-// TODO assume static instances
+func isContainerHealthy(container types.Container) bool {
+	return container.State == "running" && !strings.Contains(container.Status, "(unhealthy)")
+}
+
+// updateInstance records the health and address of a registered container.
+// An empty ip, the one of a stopped container, keeps the last known address.
+func (r *DockerRegistry) updateInstance(containerID string, healthy bool, ip string) {
+	r.reader.Lock()
+	defer r.reader.Unlock()
+	instance, ok := r.instances[containerID]
+	if !ok {
+		return
+	}
+	changed := false
+	if instance.Healthy != healthy {
+		instance.Healthy = healthy
+		changed = true
+		slog.Info("Minio instance health changed", "containerID", containerID, "healthy", healthy)
+	}
+	if ip != "" && instance.IP != ip {
+		slog.Info("Minio instance address changed", "containerID", containerID, "from", instance.IP, "to", ip)
+		instance.IP = ip
+		changed = true
+	}
+	if changed {
+		r.instances[containerID] = instance
+		r.notify()
+	}
+}
+
+func (r *DockerRegistry) containerIP(container types.Container) string {
+	if container.NetworkSettings == nil {
+		return ""
+	}
+	if endpoint := container.NetworkSettings.Networks[r.network]; endpoint != nil {
+		return endpoint.IPAddress
+	}
+	return ""
+}
+
 func (r *DockerRegistry) isInstanceRegistered(containerID string) bool {
+	r.reader.RLock()
+	defer r.reader.RUnlock()
 	_, ok := r.instances[containerID]
 	return ok
 }
@@ -129,6 +215,10 @@ func (r *DockerRegistry) getMinioInstance(container types.Container) (MinioInsta
 		return MinioInstance{}, fmt.Errorf("no ports found for container %s", container.ID)
 	}
 	hostPort := fmt.Sprintf("%d", container.Ports[0].PublicPort)
+	id, err := nodeID(container)
+	if err != nil {
+		return MinioInstance{}, err
+	}
 
 	containerJSON, err := r.cli.ContainerInspect(r.ctx, container.ID)
 	if err != nil {
@@ -148,11 +238,25 @@ func (r *DockerRegistry) getMinioInstance(container types.Container) (MinioInsta
 
 	return MinioInstance{
 		ID:            container.ID,
+		NodeID:        id,
 		Name:          container.Names[0],
-		IP:            container.NetworkSettings.Networks[r.network].IPAddress,
+		IP:            r.containerIP(container),
 		ContainerPort: CONTAINER_PORT,
 		HostPort:      hostPort,
 		User:          user,
 		Password:      password,
 	}, nil
 }
+
+// nodeID returns the node ID of a container. Hint keys hold it, so it can't
+// be empty nor contain a "/".
+func nodeID(container types.Container) (string, error) {
+	id, ok := container.Labels[NODE_ID_LABEL]
+	if !ok {
+		id = strings.TrimPrefix(container.Names[0], "/")
+	}
+	if strings.TrimSpace(id) == "" || strings.Contains(id, "/") {
+		return "", fmt.Errorf("invalid node id %q of container %s: must be non-empty and contain no /", id, container.ID)
+	}
+	return id, nil
+}
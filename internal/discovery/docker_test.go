@@ -9,6 +9,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/network"
@@ -175,3 +176,78 @@ func TestPollNetworkWithMultipleInstances(t *testing.T) {
 	assert.Equal(t, "9001", instances[1].ContainerPort, "Expected container 2 container port to be set")
 	assert.Equal(t, "9001", instances[1].HostPort, "Expected container 2 host port to be set")
 }
+
+func TestIsContainerHealthy(t *testing.T) {
+	assert.True(t, isContainerHealthy(types.Container{State: "running", Status: "Up 2 minutes"}))
+	assert.True(t, isContainerHealthy(types.Container{State: "running", Status: "Up 5 seconds (health: starting)"}))
+	assert.True(t, isContainerHealthy(types.Container{State: "running", Status: "Up 2 minutes (healthy)"}))
+	assert.False(t, isContainerHealthy(types.Container{State: "running", Status: "Up 2 minutes (unhealthy)"}))
+	assert.False(t, isContainerHealthy(types.Container{State: "exited", Status: "Exited (0) 3 seconds ago"}))
+}
+
+func TestNodeID(t *testing.T) {
+	id, err := nodeID(types.Container{Names: []string{"/go-dynamolike-minio-1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "go-dynamolike-minio-1", id)
+
+	id, err = nodeID(types.Container{
+		Names:  []string{"/go-dynamolike-minio-1"},
+		Labels: map[string]string{NODE_ID_LABEL: "node-a"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "node-a", id)
+}
+
+func TestNodeIDRejectsIDsBreakingHintKeys(t *testing.T) {
+	for _, label := range []string{"", " ", "rack-1/node-a"} {
+		_, err := nodeID(types.Container{
+			Names:  []string{"/go-dynamolike-minio-1"},
+			Labels: map[string]string{NODE_ID_LABEL: label},
+		})
+		assert.Error(t, err, "label %q", label)
+	}
+}
+
+func TestSubscribeIsSignalledOnMembershipChanges(t *testing.T) {
+	registry := NewServiceRegistry(context.Background(), nil, TEST_NETWORK)
+	changes := registry.Subscribe()
+
+	registry.AddInstance("container-1", MinioInstance{ID: "container-1"})
+	registry.AddInstance("container-2", MinioInstance{ID: "container-2"})
+
+	assert.Len(t, changes, 1, "pending signals are coalesced")
+	<-changes
+
+	registry.RemoveInstance("container-1")
+	assert.Len(t, changes, 1)
+	assert.Equal(t, 1, len(registry.GetInstances()))
+}
+
+func TestUpdateInstanceNotifiesHealthChanges(t *testing.T) {
+	registry := NewServiceRegistry(context.Background(), nil, TEST_NETWORK)
+	registry.AddInstance("container-1", MinioInstance{ID: "container-1", IP: "10.0.0.2", Healthy: true})
+	changes := registry.Subscribe()
+
+	registry.updateInstance("container-1", true, "10.0.0.2")
+	assert.Len(t, changes, 0, "an unchanged instance is not signalled")
+
+	registry.updateInstance("container-1", false, "")
+	assert.Len(t, changes, 1)
+	instance, err := registry.GetInstance("container-1")
+	assert.NoError(t, err)
+	assert.False(t, instance.Healthy)
+	assert.Equal(t, "10.0.0.2", instance.IP, "a stopped container keeps its last address")
+}
+
+func TestUpdateInstanceFollowsAddressChanges(t *testing.T) {
+	registry := NewServiceRegistry(context.Background(), nil, TEST_NETWORK)
+	registry.AddInstance("container-1", MinioInstance{ID: "container-1", IP: "10.0.0.2", Healthy: true})
+	changes := registry.Subscribe()
+
+	registry.updateInstance("container-1", true, "10.0.0.7")
+
+	assert.Len(t, changes, 1)
+	instance, err := registry.GetInstance("container-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.7", instance.IP)
+}
@@ -0,0 +1,264 @@
+package item
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Condition is a parsed condition expression, such as
+// attribute_not_exists(id) OR (version = :expected AND NOT #s IN (:a, :b)).
+type Condition struct {
+	root conditionNode
+}
+
+type conditionNode interface {
+	holds(it Item) bool
+}
+
+// ParseCondition parses a condition expression. The empty expression always
+// holds.
+func ParseCondition(expr string, params Params) (*Condition, error) {
+	if strings.TrimSpace(expr) == "" {
+		return &Condition{}, nil
+	}
+	p, err := newParser(expr, params)
+	if err != nil {
+		return nil, fmt.Errorf("condition expression: %w", err)
+	}
+	root, err := p.or()
+	if err == nil {
+		err = p.done()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("condition expression: %w", err)
+	}
+	return &Condition{root: root}, nil
+}
+
+// Holds evaluates the condition against it, nil for an item that doesn't
+// exist.
+func (c *Condition) Holds(it Item) bool {
+	return c.root == nil || c.root.holds(it)
+}
+
+type orNode []conditionNode
+
+func (n orNode) holds(it Item) bool {
+	return slices.ContainsFunc(n, func(c conditionNode) bool { return c.holds(it) })
+}
+
+type andNode []conditionNode
+
+func (n andNode) holds(it Item) bool {
+	return !slices.ContainsFunc(n, func(c conditionNode) bool { return !c.holds(it) })
+}
+
+type notNode struct{ conditionNode }
+
+func (n notNode) holds(it Item) bool { return !n.conditionNode.holds(it) }
+
+type comparisonNode struct {
+	op          string
+	left, right operand
+}
+
+func (n comparisonNode) holds(it Item) bool {
+	left, ok := n.left.eval(it)
+	if !ok {
+		return false
+	}
+	right, ok := n.right.eval(it)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "=":
+		return left.Equal(right)
+	case "<>":
+		return !left.Equal(right)
+	}
+	c, ok := left.Compare(right)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+type betweenNode struct {
+	value, low, high operand
+}
+
+func (n betweenNode) holds(it Item) bool {
+	return comparisonNode{op: ">=", left: n.value, right: n.low}.holds(it) &&
+		comparisonNode{op: "<=", left: n.value, right: n.high}.holds(it)
+}
+
+type inNode struct {
+	value   operand
+	options []operand
+}
+
+func (n inNode) holds(it Item) bool {
+	return slices.ContainsFunc(n.options, func(option operand) bool {
+		return comparisonNode{op: "=", left: n.value, right: option}.holds(it)
+	})
+}
+
+type functionNode struct {
+	name     string
+	target   path
+	argument operand
+}
+
+func (n functionNode) holds(it Item) bool {
+	v, exists := n.target.get(it)
+	switch n.name {
+	case "attribute_exists":
+		return exists
+	case "attribute_not_exists":
+		return !exists
+	}
+	arg, ok := n.argument.eval(it)
+	if !exists || !ok {
+		return false
+	}
+	switch n.name {
+	case "begins_with":
+		return v.kind == arg.kind && (v.kind == KindString && strings.HasPrefix(v.s, arg.s) ||
+			v.kind == KindBinary && strings.HasPrefix(string(v.b), string(arg.b)))
+	case "contains":
+		switch v.kind {
+		case KindString:
+			return arg.kind == KindString && strings.Contains(v.s, arg.s)
+		case KindStringSet:
+			return arg.kind == KindString && slices.Contains(v.set, arg.s)
+		case KindNumberSet:
+			return arg.kind == KindNumber && slices.Contains(v.set, arg.s)
+		case KindList:
+			return slices.ContainsFunc(v.list, arg.Equal)
+		}
+		return false
+	default: // attribute_type
+		return arg.kind == KindString && v.kind.String() == arg.s
+	}
+}
+
+func (p *parser) or() (conditionNode, error) {
+	nodes, err := p.separated("OR", p.and)
+	if len(nodes) == 1 {
+		return nodes[0], err
+	}
+	return orNode(nodes), err
+}
+
+func (p *parser) and() (conditionNode, error) {
+	nodes, err := p.separated("AND", p.not)
+	if len(nodes) == 1 {
+		return nodes[0], err
+	}
+	return andNode(nodes), err
+}
+
+func (p *parser) separated(keyword string, parse func() (conditionNode, error)) ([]conditionNode, error) {
+	var nodes []conditionNode
+	for {
+		node, err := parse()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if !p.accept(keyword) {
+			return nodes, nil
+		}
+	}
+}
+
+func (p *parser) not() (conditionNode, error) {
+	if p.accept("NOT") {
+		node, err := p.not()
+		return notNode{node}, err
+	}
+	if p.accept("(") {
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	}
+	if t := p.peek(); t.kind == tokenIdent && p.tokens[p.pos+1].text == "(" {
+		switch name := strings.ToLower(t.text); name {
+		case "attribute_exists", "attribute_not_exists", "begins_with", "contains", "attribute_type":
+			p.pos += 2
+			return p.function(name)
+		}
+	}
+	return p.comparison()
+}
+
+func (p *parser) function(name string) (conditionNode, error) {
+	target, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	node := functionNode{name: name, target: target}
+	if name != "attribute_exists" && name != "attribute_not_exists" {
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		if node.argument, err = p.conditionOperand(); err != nil {
+			return nil, err
+		}
+	}
+	return node, p.expect(")")
+}
+
+func (p *parser) comparison() (conditionNode, error) {
+	left, err := p.conditionOperand()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.accept("BETWEEN"):
+		low, err := p.conditionOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.conditionOperand()
+		return betweenNode{value: left, low: low, high: high}, err
+	case p.accept("IN"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		node := inNode{value: left}
+		for {
+			option, err := p.conditionOperand()
+			if err != nil {
+				return nil, err
+			}
+			node.options = append(node.options, option)
+			if !p.accept(",") {
+				return node, p.expect(")")
+			}
+		}
+	}
+	for _, op := range []string{"=", "<>", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.conditionOperand()
+			return comparisonNode{op: op, left: left, right: right}, err
+		}
+	}
+	return nil, fmt.Errorf("expected comparison, got %s", p.peek())
+}
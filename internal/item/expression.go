@@ -0,0 +1,233 @@
+package item
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Params are the placeholders referenced by an expression: #name for
+// attribute names and :value for values, as in DynamoDB's
+// ExpressionAttributeNames and ExpressionAttributeValues.
+type Params struct {
+	Names  map[string]string `json:"names,omitempty"`
+	Values map[string]Value  `json:"values,omitempty"`
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenName
+	tokenValue
+	tokenNumber
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '#' || c == ':' || isIdentRune(c, true):
+			start := i
+			i++
+			for i < len(expr) && isIdentRune(rune(expr[i]), false) {
+				i++
+			}
+			kind := tokenIdent
+			if c == '#' {
+				kind = tokenName
+			} else if c == ':' {
+				kind = tokenValue
+			}
+			if i-start == 1 && kind != tokenIdent {
+				return nil, fmt.Errorf("empty placeholder at offset %d", start)
+			}
+			tokens = append(tokens, token{kind: kind, text: expr[start:i]})
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(expr) && unicode.IsDigit(rune(expr[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: expr[start:i]})
+		case strings.HasPrefix(expr[i:], "<>"), strings.HasPrefix(expr[i:], "<="), strings.HasPrefix(expr[i:], ">="):
+			tokens = append(tokens, token{kind: tokenPunct, text: expr[i : i+2]})
+			i += 2
+		case strings.ContainsRune(".[](),=<>+-", c):
+			tokens = append(tokens, token{kind: tokenPunct, text: string(c)})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+func isIdentRune(c rune, first bool) bool {
+	return c == '_' || unicode.IsLetter(c) || (!first && unicode.IsDigit(c))
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	params Params
+}
+
+func newParser(expr string, params Params) (*parser, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{tokens: tokens, params: params}, nil
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the punctuation or keyword text.
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokenPunct && t.text == text) || (t.kind == tokenIdent && strings.EqualFold(t.text, text)) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("expected %q, got %s", text, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) done() error {
+	if t := p.peek(); t.kind != tokenEOF {
+		return fmt.Errorf("unexpected %s", t)
+	}
+	return nil
+}
+
+func (p *parser) path() (path, error) {
+	var result path
+	for {
+		t := p.next()
+		switch t.kind {
+		case tokenIdent:
+			result = append(result, pathElement{name: t.text})
+		case tokenName:
+			name, ok := p.params.Names[t.text]
+			if !ok {
+				return nil, fmt.Errorf("undefined attribute name %s", t.text)
+			}
+			result = append(result, pathElement{name: name})
+		default:
+			return nil, fmt.Errorf("expected attribute name, got %s", t)
+		}
+		for p.accept("[") {
+			t := p.next()
+			index, err := strconv.Atoi(t.text)
+			if t.kind != tokenNumber || err != nil {
+				return nil, fmt.Errorf("expected list index, got %s", t)
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			result = append(result, pathElement{index: index, isIndex: true})
+		}
+		if !p.accept(".") {
+			return result, nil
+		}
+	}
+}
+
+func (p *parser) value(t token) (Value, error) {
+	v, ok := p.params.Values[t.text]
+	if !ok {
+		return Value{}, fmt.Errorf("undefined attribute value %s", t.text)
+	}
+	return v, nil
+}
+
+// operand evaluates to a value, ok is false when it refers to a missing
+// attribute.
+type operand interface {
+	eval(it Item) (Value, bool)
+}
+
+type literal Value
+
+func (l literal) eval(Item) (Value, bool) { return Value(l), true }
+
+type pathOperand path
+
+func (o pathOperand) eval(it Item) (Value, bool) { return path(o).get(it) }
+
+type sizeOperand path
+
+func (o sizeOperand) eval(it Item) (Value, bool) {
+	v, ok := path(o).get(it)
+	if !ok {
+		return Value{}, false
+	}
+	var n int
+	switch v.kind {
+	case KindString:
+		n = len(v.s)
+	case KindBinary:
+		n = len(v.b)
+	case KindList:
+		n = len(v.list)
+	case KindMap:
+		n = len(v.m)
+	case KindStringSet, KindNumberSet:
+		n = len(v.set)
+	default:
+		return Value{}, false
+	}
+	return Value{kind: KindNumber, s: strconv.Itoa(n)}, true
+}
+
+// conditionOperand parses a path, a value placeholder or size(path).
+func (p *parser) conditionOperand() (operand, error) {
+	t := p.peek()
+	if t.kind == tokenValue {
+		p.next()
+		v, err := p.value(t)
+		return literal(v), err
+	}
+	if t.kind == tokenIdent && strings.EqualFold(t.text, "size") && p.tokens[p.pos+1].text == "(" {
+		p.pos += 2
+		target, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		return sizeOperand(target), p.expect(")")
+	}
+	target, err := p.path()
+	return pathOperand(target), err
+}
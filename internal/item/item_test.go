@@ -0,0 +1,198 @@
+package item
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustNumber(t *testing.T, s string) Value {
+	t.Helper()
+	v, err := Number(s)
+	require.NoError(t, err)
+	return v
+}
+
+func TestValueJSONRoundTrip(t *testing.T) {
+	data := `{"id":{"S":"a"},"n":{"N":"1.50"},"ok":{"BOOL":true},"none":{"NULL":true},` +
+		`"l":{"L":[{"S":"x"},{"N":"2"}]},"m":{"M":{"k":{"S":"v"}}},"tags":{"SS":["b","a","b"]},"empty":{"L":[]}}`
+
+	var it Item
+	require.NoError(t, json.Unmarshal([]byte(data), &it))
+
+	assert.Equal(t, "1.5", it["n"].Text(), "numbers are stored in canonical form")
+	assert.Equal(t, []string{"a", "b"}, it["tags"].SetMembers())
+	encoded, err := json.Marshal(it)
+	require.NoError(t, err)
+	var decoded Item
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.True(t, it.Equal(decoded))
+}
+
+func TestValueJSONRejectsInvalidValues(t *testing.T) {
+	for _, data := range []string{`{"N":"abc"}`, `{"S":"a","N":"1"}`, `{"X":"a"}`, `{"SS":[]}`} {
+		var v Value
+		assert.Error(t, json.Unmarshal([]byte(data), &v), data)
+	}
+}
+
+func TestCondition(t *testing.T) {
+	it := Item{
+		"id":      String("user#1"),
+		"age":     mustNumber(t, "30"),
+		"status":  String("active"),
+		"tags":    StringSet("a", "b"),
+		"profile": Map(Item{"city": String("Barcelona")}),
+	}
+	params := Params{
+		Names: map[string]string{"#s": "status"},
+		Values: map[string]Value{
+			":min": mustNumber(t, "18"), ":max": mustNumber(t, "65"), ":active": String("active"),
+			":prefix": String("user#"), ":city": String("Barcelona"), ":tag": String("b"),
+		},
+	}
+
+	tests := []struct {
+		expr  string
+		holds bool
+	}{
+		{"", true},
+		{"attribute_exists(id)", true},
+		{"attribute_not_exists(id)", false},
+		{"age BETWEEN :min AND :max AND #s = :active", true},
+		{"age < :min OR NOT (#s <> :active)", true},
+		{"begins_with(id, :prefix)", true},
+		{"profile.city = :city", true},
+		{"contains(tags, :tag)", true},
+		{"#s IN (:prefix, :city)", false},
+		{"size(tags) = :min", false},
+		{"missing = :min", false},
+	}
+	for _, tt := range tests {
+		c, err := ParseCondition(tt.expr, params)
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.holds, c.Holds(it), tt.expr)
+	}
+	assert.True(t, (&Condition{}).Holds(nil))
+
+	for _, expr := range []string{"age >", "#undefined = :min", "age = :undefined", "(age = :min", "age = :min extra"} {
+		_, err := ParseCondition(expr, params)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	it := Item{
+		"id":     String("a"),
+		"visits": mustNumber(t, "1.5"),
+		"tmp":    Bool(true),
+		"tags":   StringSet("a", "b"),
+		"list":   List(String("x")),
+	}
+	params := Params{
+		Names: map[string]string{"#n": "name"},
+		Values: map[string]Value{
+			":name": String("Alice"), ":one": mustNumber(t, "1"), ":more": List(String("y")),
+			":tags": StringSet("c"), ":drop": StringSet("a", "b"), ":zero": mustNumber(t, "0"),
+		},
+	}
+
+	u, err := ParseUpdate("SET #n = :name, visits = visits + :one, list = list_append(list, :more), "+
+		"counter = if_not_exists(counter, :zero) REMOVE tmp ADD hits :one, extra :tags DELETE tags :drop", params)
+	require.NoError(t, err)
+	updated, err := u.Apply(it)
+	require.NoError(t, err)
+
+	assert.Equal(t, Item{
+		"id":      String("a"),
+		"name":    String("Alice"),
+		"visits":  mustNumber(t, "2.5"),
+		"list":    List(String("x"), String("y")),
+		"counter": mustNumber(t, "0"),
+		"hits":    mustNumber(t, "1"),
+		"extra":   StringSet("c"),
+	}, updated)
+	assert.True(t, it["tmp"].BoolValue(), "the original item is left unchanged")
+	assert.True(t, u.Touches("visits"))
+	assert.False(t, u.Touches("id"))
+
+	u, err = ParseUpdate("SET missing.nested = :one", params)
+	require.NoError(t, err)
+	_, err = u.Apply(it)
+	assert.Error(t, err, "the parent of a path must exist")
+
+	for _, expr := range []string{"", "SET", "SET a = :one SET b = :one", "ADD a b", "UPSERT a = :one"} {
+		_, err := ParseUpdate(expr, params)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestUpdateNullMapsAndLists(t *testing.T) {
+	var it Item
+	require.NoError(t, json.Unmarshal([]byte(`{"id":{"S":"a"},"m":{"M":null},"l":{"L":null}}`), &it))
+
+	u, err := ParseUpdate("SET m.a = :v, l = list_append(l, :l)", Params{
+		Values: map[string]Value{":v": String("x"), ":l": List(String("y"))},
+	})
+	require.NoError(t, err)
+	updated, err := u.Apply(it)
+	require.NoError(t, err)
+
+	assert.Equal(t, Map(Item{"a": String("x")}), updated["m"])
+	assert.Equal(t, List(String("y")), updated["l"])
+}
+
+func TestProjection(t *testing.T) {
+	it := Item{
+		"id":      String("a"),
+		"secret":  String("s"),
+		"profile": Map(Item{"city": String("Barcelona"), "zip": String("08001")}),
+		"list":    List(String("x"), String("y"), String("z")),
+	}
+
+	p, err := ParseProjection("id, profile.city, list[2], missing", Params{})
+	require.NoError(t, err)
+
+	assert.Equal(t, Item{
+		"id":      String("a"),
+		"profile": Map(Item{"city": String("Barcelona")}),
+		"list":    List(String("z")),
+	}, p.Apply(it))
+
+	p, err = ParseProjection("", Params{})
+	require.NoError(t, err)
+	assert.Equal(t, it, p.Apply(it))
+}
+
+func TestKeyCondition(t *testing.T) {
+	params := Params{
+		Names: map[string]string{"#sk": "created"},
+		Values: map[string]Value{
+			":c": String("customer-1"), ":from": mustNumber(t, "10"), ":to": mustNumber(t, "20"), ":p": String("2024-"),
+		},
+	}
+
+	c, err := ParseKeyCondition("customer = :c AND #sk BETWEEN :from AND :to", params)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"created", "customer"}, c.Keys())
+	v, ok := c.Equals("customer")
+	assert.True(t, ok)
+	assert.Equal(t, "customer-1", v.Text())
+	_, ok = c.Equals("created")
+	assert.False(t, ok)
+	assert.True(t, c.Matches("created", mustNumber(t, "15")))
+	assert.False(t, c.Matches("created", mustNumber(t, "21")))
+	assert.True(t, c.Matches("other", Null()))
+
+	c, err = ParseKeyCondition("begins_with(day, :p) and customer = :c", params)
+	require.NoError(t, err)
+	assert.True(t, c.Matches("day", String("2024-01-01")))
+	assert.False(t, c.Matches("day", String("2023-12-31")))
+
+	for _, expr := range []string{"", "a = :c AND a = :c", "a.b = :c", "a <> :c", "a = b", "a = :c AND b = :c AND c = :c", "a = :c OR b = :c"} {
+		_, err := ParseKeyCondition(expr, params)
+		assert.Error(t, err, expr)
+	}
+}
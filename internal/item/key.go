@@ -0,0 +1,140 @@
+package item
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// KeyCondition is a parsed key condition expression: an equality on the
+// partition key, optionally followed by AND and a condition on the sort key,
+// such as pk = :customer AND sk BETWEEN :from AND :to.
+type KeyCondition struct {
+	keys map[string]keyCondition
+}
+
+type keyCondition struct {
+	op   string
+	node conditionNode
+	// value is the operand of an equality.
+	value Value
+}
+
+// ParseKeyCondition parses a key condition expression. Each condition
+// compares a top level attribute to values with =, <, <=, >, >=, BETWEEN or
+// begins_with.
+func ParseKeyCondition(expr string, params Params) (*KeyCondition, error) {
+	p, err := newParser(expr, params)
+	if err != nil {
+		return nil, fmt.Errorf("key condition expression: %w", err)
+	}
+	c := &KeyCondition{keys: make(map[string]keyCondition)}
+	for {
+		name, condition, err := p.keyCondition()
+		if err != nil {
+			return nil, fmt.Errorf("key condition expression: %w", err)
+		}
+		if _, ok := c.keys[name]; ok {
+			return nil, fmt.Errorf("key condition expression: %s has more than one condition", name)
+		}
+		c.keys[name] = condition
+		if len(c.keys) == 2 || !p.accept("AND") {
+			break
+		}
+	}
+	if err := p.done(); err != nil {
+		return nil, fmt.Errorf("key condition expression: %w", err)
+	}
+	return c, nil
+}
+
+func (p *parser) keyCondition() (string, keyCondition, error) {
+	if t := p.peek(); t.kind == tokenIdent && strings.EqualFold(t.text, "begins_with") && p.tokens[p.pos+1].text == "(" {
+		p.pos += 2
+		name, err := p.keyName()
+		if err != nil {
+			return "", keyCondition{}, err
+		}
+		if err := p.expect(","); err != nil {
+			return "", keyCondition{}, err
+		}
+		prefix, err := p.keyValue()
+		if err != nil {
+			return "", keyCondition{}, err
+		}
+		node := functionNode{name: "begins_with", target: path{{name: name}}, argument: literal(prefix)}
+		return name, keyCondition{op: "begins_with", node: node}, p.expect(")")
+	}
+
+	name, err := p.keyName()
+	if err != nil {
+		return "", keyCondition{}, err
+	}
+	target := pathOperand{{name: name}}
+	if p.accept("BETWEEN") {
+		low, err := p.keyValue()
+		if err != nil {
+			return "", keyCondition{}, err
+		}
+		if err := p.expect("AND"); err != nil {
+			return "", keyCondition{}, err
+		}
+		high, err := p.keyValue()
+		node := betweenNode{value: target, low: literal(low), high: literal(high)}
+		return name, keyCondition{op: "BETWEEN", node: node}, err
+	}
+	for _, op := range []string{"=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			v, err := p.keyValue()
+			node := comparisonNode{op: op, left: target, right: literal(v)}
+			return name, keyCondition{op: op, node: node, value: v}, err
+		}
+	}
+	return "", keyCondition{}, fmt.Errorf("expected key comparison, got %s", p.peek())
+}
+
+// keyName parses a top level attribute name.
+func (p *parser) keyName() (string, error) {
+	target, err := p.path()
+	if err != nil {
+		return "", err
+	}
+	if len(target) != 1 || target[0].isIndex {
+		return "", fmt.Errorf("key conditions only apply to top level attributes, got %s", target)
+	}
+	return target[0].name, nil
+}
+
+func (p *parser) keyValue() (Value, error) {
+	t := p.next()
+	if t.kind != tokenValue {
+		return Value{}, fmt.Errorf("expected value placeholder, got %s", t)
+	}
+	return p.value(t)
+}
+
+// Keys returns the attributes the condition applies to, sorted.
+func (c *KeyCondition) Keys() []string {
+	keys := make([]string, 0, len(c.keys))
+	for name := range c.keys {
+		keys = append(keys, name)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Equals returns the value the condition requires name to be equal to.
+func (c *KeyCondition) Equals(name string) (Value, bool) {
+	condition, ok := c.keys[name]
+	if !ok || condition.op != "=" {
+		return Value{}, false
+	}
+	return condition.value, true
+}
+
+// Matches reports whether v satisfies the condition on name. It holds for
+// any value when there is no condition on name.
+func (c *KeyCondition) Matches(name string, v Value) bool {
+	condition, ok := c.keys[name]
+	return !ok || condition.node.holds(Item{name: v})
+}
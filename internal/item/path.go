@@ -0,0 +1,150 @@
+package item
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathElement is one step of a document path: an attribute of a map, or an
+// element of a list when index is set.
+type pathElement struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+// path addresses a value nested in an item, like a.b[2].c.
+type path []pathElement
+
+func (p path) String() string {
+	var b strings.Builder
+	for i, e := range p {
+		switch {
+		case e.isIndex:
+			b.WriteString("[" + strconv.Itoa(e.index) + "]")
+		case i > 0:
+			b.WriteString("." + e.name)
+		default:
+			b.WriteString(e.name)
+		}
+	}
+	return b.String()
+}
+
+// get returns the value at p, ok is false when it doesn't exist.
+func (p path) get(it Item) (Value, bool) {
+	current := Map(it)
+	for _, e := range p {
+		switch {
+		case e.isIndex && current.kind == KindList && e.index < len(current.list):
+			current = current.list[e.index]
+		case !e.isIndex && current.kind == KindMap:
+			v, ok := current.m[e.name]
+			if !ok {
+				return Value{}, false
+			}
+			current = v
+		default:
+			return Value{}, false
+		}
+	}
+	return current, true
+}
+
+// set stores v at p. The parent of p must exist. Setting an index past the
+// end of a list appends to it.
+func (p path) set(it Item, v Value) error {
+	parent, last := p[:len(p)-1], p[len(p)-1]
+	return parent.modify(it, func(container *Value) error {
+		switch {
+		case last.isIndex && container.kind == KindList:
+			if last.index >= len(container.list) {
+				container.list = append(container.list, v)
+			} else {
+				container.list[last.index] = v
+			}
+		case !last.isIndex && container.kind == KindMap:
+			container.m[last.name] = v
+		default:
+			return fmt.Errorf("document path %s doesn't match the item", p)
+		}
+		return nil
+	})
+}
+
+// remove deletes the value at p. Removing a value that doesn't exist is a
+// no-op.
+func (p path) remove(it Item) {
+	parent, last := p[:len(p)-1], p[len(p)-1]
+	_ = parent.modify(it, func(container *Value) error {
+		switch {
+		case last.isIndex && container.kind == KindList:
+			if last.index < len(container.list) {
+				container.list = append(container.list[:last.index], container.list[last.index+1:]...)
+			}
+		case !last.isIndex && container.kind == KindMap:
+			delete(container.m, last.name)
+		}
+		return nil
+	})
+}
+
+// modify calls fn with the container at p, which it may change in place.
+func (p path) modify(it Item, fn func(*Value) error) error {
+	root := Map(it)
+	return p.modifyValue(&root, fn)
+}
+
+func (p path) modifyValue(current *Value, fn func(*Value) error) error {
+	if len(p) == 0 {
+		return fn(current)
+	}
+	e := p[0]
+	switch {
+	case e.isIndex && current.kind == KindList && e.index < len(current.list):
+		return p[1:].modifyValue(&current.list[e.index], fn)
+	case !e.isIndex && current.kind == KindMap:
+		child, ok := current.m[e.name]
+		if !ok {
+			return fmt.Errorf("document path %s doesn't match the item", p)
+		}
+		if err := p[1:].modifyValue(&child, fn); err != nil {
+			return err
+		}
+		current.m[e.name] = child
+		return nil
+	default:
+		return fmt.Errorf("document path %s doesn't match the item", p)
+	}
+}
+
+// Clone returns a deep copy of it.
+func (it Item) Clone() Item {
+	if it == nil {
+		return nil
+	}
+	clone := make(Item, len(it))
+	for name, v := range it {
+		clone[name] = v.clone()
+	}
+	return clone
+}
+
+func (v Value) clone() Value {
+	switch v.kind {
+	case KindList:
+		list := make([]Value, len(v.list))
+		for i, e := range v.list {
+			list[i] = e.clone()
+		}
+		v.list = list
+	case KindMap:
+		v.m = v.m.Clone()
+	case KindBinary:
+		v.b = append([]byte(nil), v.b...)
+	case KindStringSet, KindNumberSet:
+		v.set = append([]string(nil), v.set...)
+	}
+	return v
+}
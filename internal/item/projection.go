@@ -0,0 +1,86 @@
+package item
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Projection is a parsed projection expression, a comma separated list of
+// document paths to return from an item.
+type Projection struct {
+	paths []path
+}
+
+// ParseProjection parses a projection expression. The empty expression
+// projects whole items.
+func ParseProjection(expr string, params Params) (*Projection, error) {
+	if strings.TrimSpace(expr) == "" {
+		return &Projection{}, nil
+	}
+	p, err := newParser(expr, params)
+	if err != nil {
+		return nil, fmt.Errorf("projection expression: %w", err)
+	}
+	projection := &Projection{}
+	for {
+		target, err := p.path()
+		if err != nil {
+			return nil, fmt.Errorf("projection expression: %w", err)
+		}
+		projection.paths = append(projection.paths, target)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if err := p.done(); err != nil {
+		return nil, fmt.Errorf("projection expression: %w", err)
+	}
+	return projection, nil
+}
+
+// Apply returns the attributes of it selected by the projection. Nested
+// values keep their enclosing maps, and list elements selected by index are
+// returned in a list in the order they were projected.
+func (p *Projection) Apply(it Item) Item {
+	if len(p.paths) == 0 {
+		return it
+	}
+	projected := make(Item)
+	for _, target := range p.paths {
+		v, ok := target.get(it)
+		if !ok {
+			continue
+		}
+		root := Map(projected)
+		place(&root, target, v)
+	}
+	return projected
+}
+
+// place stores v at target under current, creating the containers leading to
+// it.
+func place(current *Value, target path, v Value) {
+	if len(target) == 0 {
+		*current = v.clone()
+		return
+	}
+	e := target[0]
+	if e.isIndex {
+		if current.kind != KindList {
+			*current = List()
+		}
+		var child Value
+		place(&child, target[1:], v)
+		current.list = append(current.list, child)
+		return
+	}
+	if current.kind != KindMap {
+		*current = Map(make(Item))
+	}
+	child, ok := current.m[e.name]
+	if !ok {
+		child = Value{}
+	}
+	place(&child, target[1:], v)
+	current.m[e.name] = child
+}
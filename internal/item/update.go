@@ -0,0 +1,239 @@
+package item
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// Update is a parsed update expression made of SET, REMOVE, ADD and DELETE
+// clauses, such as SET #n = :name, visits = visits + :one REMOVE tmp.
+type Update struct {
+	actions []updateAction
+}
+
+type updateAction struct {
+	clause string
+	target path
+	value  operand
+}
+
+// ParseUpdate parses an update expression.
+func ParseUpdate(expr string, params Params) (*Update, error) {
+	p, err := newParser(expr, params)
+	if err != nil {
+		return nil, fmt.Errorf("update expression: %w", err)
+	}
+	u := &Update{}
+	seen := make(map[string]bool)
+	for p.peek().kind != tokenEOF {
+		t := p.next()
+		clause := strings.ToUpper(t.text)
+		if t.kind != tokenIdent || !slices.Contains([]string{"SET", "REMOVE", "ADD", "DELETE"}, clause) {
+			return nil, fmt.Errorf("update expression: expected SET, REMOVE, ADD or DELETE, got %s", t)
+		}
+		if seen[clause] {
+			return nil, fmt.Errorf("update expression: %s clause repeated", clause)
+		}
+		seen[clause] = true
+		for {
+			action, err := p.updateAction(clause)
+			if err != nil {
+				return nil, fmt.Errorf("update expression: %w", err)
+			}
+			u.actions = append(u.actions, action)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if len(u.actions) == 0 {
+		return nil, fmt.Errorf("update expression: no actions")
+	}
+	return u, nil
+}
+
+func (p *parser) updateAction(clause string) (updateAction, error) {
+	target, err := p.path()
+	if err != nil {
+		return updateAction{}, err
+	}
+	action := updateAction{clause: clause, target: target}
+	switch clause {
+	case "SET":
+		if err := p.expect("="); err != nil {
+			return action, err
+		}
+		action.value, err = p.setValue()
+	case "ADD", "DELETE":
+		t := p.next()
+		if t.kind != tokenValue {
+			return action, fmt.Errorf("expected value placeholder, got %s", t)
+		}
+		var v Value
+		v, err = p.value(t)
+		action.value = literal(v)
+	}
+	return action, err
+}
+
+// setValue parses the right hand side of a SET action: an operand, or the
+// sum or difference of two operands.
+func (p *parser) setValue() (operand, error) {
+	left, err := p.setOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"+", "-"} {
+		if p.accept(op) {
+			right, err := p.setOperand()
+			return arithmetic{op: op, left: left, right: right}, err
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) setOperand() (operand, error) {
+	t := p.peek()
+	if t.kind == tokenIdent && p.tokens[p.pos+1].text == "(" {
+		switch name := strings.ToLower(t.text); name {
+		case "if_not_exists", "list_append":
+			p.pos += 2
+			first, err := p.setOperand()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+			second, err := p.setOperand()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := first.(pathOperand); name == "if_not_exists" && !ok {
+				return nil, fmt.Errorf("if_not_exists expects a document path first")
+			}
+			return setFunction{name: name, first: first, second: second}, p.expect(")")
+		}
+	}
+	return p.conditionOperand()
+}
+
+type arithmetic struct {
+	op          string
+	left, right operand
+}
+
+func (a arithmetic) eval(it Item) (Value, bool) {
+	left, ok := a.left.eval(it)
+	if !ok || left.kind != KindNumber {
+		return Value{}, false
+	}
+	right, ok := a.right.eval(it)
+	if !ok || right.kind != KindNumber {
+		return Value{}, false
+	}
+	if a.op == "+" {
+		return Value{kind: KindNumber, s: formatNumber(new(big.Rat).Add(left.rat(), right.rat()))}, true
+	}
+	return Value{kind: KindNumber, s: formatNumber(new(big.Rat).Sub(left.rat(), right.rat()))}, true
+}
+
+type setFunction struct {
+	name          string
+	first, second operand
+}
+
+func (f setFunction) eval(it Item) (Value, bool) {
+	first, ok := f.first.eval(it)
+	if f.name == "if_not_exists" {
+		if ok {
+			return first, true
+		}
+		return f.second.eval(it)
+	}
+	second, ok2 := f.second.eval(it)
+	if !ok || !ok2 || first.kind != KindList || second.kind != KindList {
+		return Value{}, false
+	}
+	return List(append(slices.Clone(first.list), second.list...)...), true
+}
+
+// Touches reports whether the update changes the top level attribute name.
+func (u *Update) Touches(name string) bool {
+	return slices.ContainsFunc(u.actions, func(a updateAction) bool { return a.target[0].name == name })
+}
+
+// Apply returns a copy of it with the update applied, it is nil for an item
+// that doesn't exist. Every operand is evaluated against the item as it was
+// before the update.
+func (u *Update) Apply(it Item) (Item, error) {
+	values := make([]Value, len(u.actions))
+	for i, action := range u.actions {
+		if action.value == nil {
+			continue
+		}
+		v, ok := action.value.eval(it)
+		if !ok {
+			return nil, fmt.Errorf("%s %s: operand refers to a missing attribute or has the wrong type", action.clause, action.target)
+		}
+		values[i] = v
+	}
+
+	updated := it.Clone()
+	if updated == nil {
+		updated = make(Item)
+	}
+	for i, action := range u.actions {
+		var err error
+		switch action.clause {
+		case "SET":
+			err = action.target.set(updated, values[i])
+		case "REMOVE":
+			action.target.remove(updated)
+		case "ADD":
+			err = add(updated, action.target, values[i])
+		case "DELETE":
+			err = deleteFromSet(updated, action.target, values[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", action.clause, action.target, err)
+		}
+	}
+	return updated, nil
+}
+
+// add increments a number or adds members to a set. A missing attribute
+// starts from zero or the empty set.
+func add(it Item, target path, v Value) error {
+	current, exists := target.get(it)
+	switch {
+	case !exists && (v.kind == KindNumber || v.kind == KindStringSet || v.kind == KindNumberSet):
+		return target.set(it, v)
+	case v.kind == KindNumber && current.kind == KindNumber:
+		return target.set(it, Value{kind: KindNumber, s: formatNumber(new(big.Rat).Add(current.rat(), v.rat()))})
+	case (v.kind == KindStringSet || v.kind == KindNumberSet) && current.kind == v.kind:
+		return target.set(it, Value{kind: v.kind, set: normalizeSet(append(slices.Clone(current.set), v.set...))})
+	default:
+		return fmt.Errorf("ADD only applies to numbers and sets of the same type")
+	}
+}
+
+// deleteFromSet removes members from a set, removing the attribute when the
+// set ends up empty.
+func deleteFromSet(it Item, target path, v Value) error {
+	current, exists := target.get(it)
+	if !exists {
+		return nil
+	}
+	if (v.kind != KindStringSet && v.kind != KindNumberSet) || current.kind != v.kind {
+		return fmt.Errorf("DELETE only applies to sets of the same type")
+	}
+	remaining := slices.DeleteFunc(slices.Clone(current.set), func(member string) bool { return slices.Contains(v.set, member) })
+	if len(remaining) == 0 {
+		target.remove(it)
+		return nil
+	}
+	return target.set(it, Value{kind: v.kind, set: remaining})
+}
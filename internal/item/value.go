@@ -0,0 +1,320 @@
+// Package item implements DynamoDB style items: maps of typed attribute
+// values, and the projection, condition and update expressions evaluated
+// against them.
+package item
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Kind is the type of a Value, named like the DynamoDB type descriptors.
+type Kind int
+
+const (
+	KindNull Kind = iota
+	KindString
+	KindNumber
+	KindBinary
+	KindBool
+	KindList
+	KindMap
+	KindStringSet
+	KindNumberSet
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindString:
+		return "S"
+	case KindNumber:
+		return "N"
+	case KindBinary:
+		return "B"
+	case KindBool:
+		return "BOOL"
+	case KindList:
+		return "L"
+	case KindMap:
+		return "M"
+	case KindStringSet:
+		return "SS"
+	case KindNumberSet:
+		return "NS"
+	default:
+		return "NULL"
+	}
+}
+
+// Value is a typed attribute value. It is encoded in JSON like DynamoDB does,
+// as an object with a single type descriptor key: {"S": "text"}, {"N": "1.5"},
+// {"L": [{"BOOL": true}]}...
+type Value struct {
+	kind    Kind
+	s       string
+	b       []byte
+	boolean bool
+	list    []Value
+	m       Item
+	set     []string
+}
+
+// Item is a map of attribute names to values.
+type Item map[string]Value
+
+func String(s string) Value { return Value{kind: KindString, s: s} }
+
+// Number returns a number value. It fails when s is not a decimal number or
+// is out of the range DynamoDB supports, about 38 digits and an exponent
+// between -130 and 125.
+func Number(s string) (Value, error) {
+	if len(s) > 64 {
+		return Value{}, fmt.Errorf("invalid number %q: too long", s)
+	}
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		// Rat parses exponents by materializing them, bound them first.
+		exp, err := strconv.Atoi(s[i+1:])
+		if err != nil || exp < -130 || exp > 125 {
+			return Value{}, fmt.Errorf("invalid number %q", s)
+		}
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Value{}, fmt.Errorf("invalid number %q", s)
+	}
+	return Value{kind: KindNumber, s: formatNumber(r)}, nil
+}
+
+func Binary(b []byte) Value { return Value{kind: KindBinary, b: b} }
+func Bool(b bool) Value     { return Value{kind: KindBool, boolean: b} }
+func Null() Value           { return Value{kind: KindNull} }
+func List(l ...Value) Value { return Value{kind: KindList, list: l} }
+func Map(m Item) Value      { return Value{kind: KindMap, m: m} }
+func StringSet(s ...string) Value {
+	return Value{kind: KindStringSet, set: normalizeSet(s)}
+}
+
+// NumberSet returns a number set. It fails when a member is not a number.
+func NumberSet(s ...string) (Value, error) {
+	members := make([]string, 0, len(s))
+	for _, n := range s {
+		v, err := Number(n)
+		if err != nil {
+			return Value{}, err
+		}
+		members = append(members, v.s)
+	}
+	return Value{kind: KindNumberSet, set: normalizeSet(members)}, nil
+}
+
+func normalizeSet(s []string) []string {
+	s = slices.Clone(s)
+	slices.Sort(s)
+	return slices.Compact(s)
+}
+
+func (v Value) Kind() Kind { return v.kind }
+
+// Text returns the string of a string value and the canonical form of a
+// number value.
+func (v Value) Text() string { return v.s }
+
+func (v Value) Bytes() []byte        { return v.b }
+func (v Value) BoolValue() bool      { return v.boolean }
+func (v Value) ListValue() []Value   { return v.list }
+func (v Value) MapValue() Item       { return v.m }
+func (v Value) SetMembers() []string { return v.set }
+
+func (v Value) rat() *big.Rat {
+	r, _ := new(big.Rat).SetString(v.s)
+	return r
+}
+
+// formatNumber renders r as a decimal without trailing zeros. Numbers parsed
+// from decimal strings always have a finite decimal expansion.
+func formatNumber(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	scale := new(big.Int).Set(r.Denom())
+	digits := 0
+	for _, f := range []int64{2, 5} {
+		n := 0
+		for new(big.Int).Mod(scale, big.NewInt(f)).Sign() == 0 {
+			scale.Div(scale, big.NewInt(f))
+			n++
+		}
+		digits = max(digits, n)
+	}
+	s := r.FloatString(digits)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// Equal reports whether v and other have the same type and value.
+func (v Value) Equal(other Value) bool {
+	if v.kind != other.kind {
+		return false
+	}
+	switch v.kind {
+	case KindString, KindNumber:
+		return v.s == other.s
+	case KindBinary:
+		return bytes.Equal(v.b, other.b)
+	case KindBool:
+		return v.boolean == other.boolean
+	case KindList:
+		return slices.EqualFunc(v.list, other.list, Value.Equal)
+	case KindMap:
+		return v.m.Equal(other.m)
+	case KindStringSet, KindNumberSet:
+		return slices.Equal(v.set, other.set)
+	default:
+		return true
+	}
+}
+
+// Equal reports whether both items have the same attributes.
+func (it Item) Equal(other Item) bool {
+	if len(it) != len(other) {
+		return false
+	}
+	for name, v := range it {
+		o, ok := other[name]
+		if !ok || !v.Equal(o) {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare orders two strings, numbers or binaries of the same type. ok is
+// false for values that can't be ordered.
+func (v Value) Compare(other Value) (c int, ok bool) {
+	if v.kind != other.kind {
+		return 0, false
+	}
+	switch v.kind {
+	case KindString:
+		return strings.Compare(v.s, other.s), true
+	case KindNumber:
+		return v.rat().Cmp(other.rat()), true
+	case KindBinary:
+		return bytes.Compare(v.b, other.b), true
+	default:
+		return 0, false
+	}
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	var body any
+	switch v.kind {
+	case KindString, KindNumber:
+		body = v.s
+	case KindBinary:
+		body = v.b
+	case KindBool:
+		body = v.boolean
+	case KindList:
+		body = v.list
+		if v.list == nil {
+			body = []Value{}
+		}
+	case KindMap:
+		body = v.m
+		if v.m == nil {
+			body = Item{}
+		}
+	case KindStringSet, KindNumberSet:
+		body = v.set
+	default:
+		body = true
+	}
+	return json.Marshal(map[string]any{v.kind.String(): body})
+}
+
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var typed map[string]json.RawMessage
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return err
+	}
+	if len(typed) != 1 {
+		return fmt.Errorf("attribute value must have exactly one type descriptor, got %d", len(typed))
+	}
+	for descriptor, raw := range typed {
+		parsed, err := parseValue(descriptor, raw)
+		if err != nil {
+			return fmt.Errorf("%s attribute value: %w", descriptor, err)
+		}
+		*v = parsed
+	}
+	return nil
+}
+
+func parseValue(descriptor string, raw json.RawMessage) (Value, error) {
+	switch descriptor {
+	case "S":
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return String(s), err
+	case "N":
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return Value{}, err
+		}
+		return Number(s)
+	case "B":
+		var b []byte
+		err := json.Unmarshal(raw, &b)
+		return Binary(b), err
+	case "BOOL":
+		var b bool
+		err := json.Unmarshal(raw, &b)
+		return Bool(b), err
+	case "NULL":
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil || !b {
+			return Value{}, fmt.Errorf("NULL must be true")
+		}
+		return Null(), nil
+	case "L":
+		var l []Value
+		if err := json.Unmarshal(raw, &l); err != nil {
+			return Value{}, err
+		}
+		if l == nil {
+			l = []Value{}
+		}
+		return List(l...), nil
+	case "M":
+		var m Item
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return Value{}, err
+		}
+		if m == nil {
+			m = Item{}
+		}
+		return Map(m), nil
+	case "SS", "NS":
+		var s []string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return Value{}, err
+		}
+		if len(s) == 0 {
+			return Value{}, fmt.Errorf("sets can't be empty")
+		}
+		if descriptor == "SS" {
+			return StringSet(s...), nil
+		}
+		return NumberSet(s...)
+	default:
+		return Value{}, fmt.Errorf("unknown type descriptor")
+	}
+}
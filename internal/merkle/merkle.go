@@ -0,0 +1,140 @@
+package merkle
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/dgryski/go-farm"
+)
+
+// Tree is a fixed-depth Merkle tree over key/value pairs. Keys are placed in
+// leaves by hash, so two trees of the same depth built from replicas of one
+// key range can be compared level by level and only the leaves whose hashes
+// differ need to be inspected key by key.
+type Tree struct {
+	depth  int
+	leaves []map[string]string
+	levels [][]uint64
+}
+
+// New returns an empty tree with 2^depth leaves.
+func New(depth int) *Tree {
+	leaves := make([]map[string]string, 1<<depth)
+	for i := range leaves {
+		leaves[i] = make(map[string]string)
+	}
+	return &Tree{depth: depth, leaves: leaves}
+}
+
+func (t *Tree) leaf(key string) int {
+	return int(farm.Fingerprint64([]byte(key)) >> (64 - t.depth))
+}
+
+// Insert records the value of key, what copies of it are compared by. It
+// invalidates previously computed hashes.
+func (t *Tree) Insert(key, value string) {
+	t.leaves[t.leaf(key)][key] = value
+	t.levels = nil
+}
+
+// Len returns the number of keys in the tree.
+func (t *Tree) Len() int {
+	n := 0
+	for _, leaf := range t.leaves {
+		n += len(leaf)
+	}
+	return n
+}
+
+// Root returns the hash covering every key in the tree.
+func (t *Tree) Root() uint64 {
+	t.build()
+	return t.levels[0][0]
+}
+
+// build computes levels[0] (the root) down to levels[depth] (the leaves).
+func (t *Tree) build() {
+	if t.levels != nil {
+		return
+	}
+	t.levels = make([][]uint64, t.depth+1)
+	hashes := make([]uint64, len(t.leaves))
+	for i, leaf := range t.leaves {
+		hashes[i] = hashLeaf(leaf)
+	}
+	t.levels[t.depth] = hashes
+	for level := t.depth - 1; level >= 0; level-- {
+		children := t.levels[level+1]
+		hashes := make([]uint64, len(children)/2)
+		for i := range hashes {
+			hashes[i] = hashPair(children[2*i], children[2*i+1])
+		}
+		t.levels[level] = hashes
+	}
+}
+
+func hashLeaf(leaf map[string]string) uint64 {
+	if len(leaf) == 0 {
+		return 0
+	}
+	keys := make([]string, 0, len(leaf))
+	for key := range leaf {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf []byte
+	for _, key := range keys {
+		buf = append(buf, key...)
+		buf = append(buf, 0)
+		buf = append(buf, leaf[key]...)
+		buf = append(buf, 0)
+	}
+	return farm.Fingerprint64(buf)
+}
+
+func hashPair(left, right uint64) uint64 {
+	if left == 0 && right == 0 {
+		return 0
+	}
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[:8], left)
+	binary.LittleEndian.PutUint64(buf[8:], right)
+	return farm.Fingerprint64(buf[:])
+}
+
+// Diff returns the keys that are missing from one of the trees or have a
+// different value in each, sorted. Both trees must have the same depth.
+func Diff(a, b *Tree) []string {
+	if a.depth != b.depth {
+		panic("merkle: comparing trees of different depth")
+	}
+	a.build()
+	b.build()
+
+	var keys []string
+	var walk func(level, i int)
+	walk = func(level, i int) {
+		if a.levels[level][i] == b.levels[level][i] {
+			return
+		}
+		if level < a.depth {
+			walk(level+1, 2*i)
+			walk(level+1, 2*i+1)
+			return
+		}
+		for key, value := range a.leaves[i] {
+			if other, ok := b.leaves[i][key]; !ok || other != value {
+				keys = append(keys, key)
+			}
+		}
+		for key := range b.leaves[i] {
+			if _, ok := a.leaves[i][key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	walk(0, 0)
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,42 @@
+package merkle
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEqualTreesHaveNoDiff(t *testing.T) {
+	a, b := New(4), New(4)
+	for i := range 100 {
+		a.Insert(fmt.Sprintf("key-%d", i), "etag")
+		b.Insert(fmt.Sprintf("key-%d", 99-i), "etag")
+	}
+
+	assert.Equal(t, a.Root(), b.Root())
+	assert.Empty(t, Diff(a, b))
+	assert.Equal(t, 100, a.Len())
+}
+
+func TestDiffFindsMissingAndChangedKeys(t *testing.T) {
+	a, b := New(4), New(4)
+	for i := range 100 {
+		a.Insert(fmt.Sprintf("key-%d", i), "etag")
+		b.Insert(fmt.Sprintf("key-%d", i), "etag")
+	}
+	a.Insert("only-in-a", "etag")
+	b.Insert("only-in-b", "etag")
+	b.Insert("key-42", "other-etag")
+
+	assert.NotEqual(t, a.Root(), b.Root())
+	assert.Equal(t, []string{"key-42", "only-in-a", "only-in-b"}, Diff(a, b))
+}
+
+func TestEmptyTrees(t *testing.T) {
+	a, b := New(3), New(3)
+	b.Insert("key", "etag")
+
+	assert.Equal(t, uint64(0), a.Root())
+	assert.Equal(t, []string{"key"}, Diff(a, b))
+}
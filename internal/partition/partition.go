@@ -1,49 +1,35 @@
 package partition
 
-import (
-	"bytes"
-
-	"github.com/dgryski/go-farm"
-	"github.com/lithammer/go-jump-consistent-hash"
-)
+import "strings"
 
+// Partitioner maps keys to the stable IDs of the nodes storing them. Keys are
+// hashed whole: to store every key sharing a partition key on the same
+// nodes, callers place keys built with CompositeKey by their PartitionKey.
 type Partitioner interface {
-	Hash(key string) int
-}
-
-type Partition struct {
-	nodes        int
-	virtualNodes int
-	hasher       *jump.Hasher
-}
-
-type FarmHash struct {
-	buf bytes.Buffer
-}
-
-func (f *FarmHash) Write(p []byte) (n int, err error) {
-	return f.buf.Write(p)
+	// Hash returns the ID of the node owning key, or "" when there are no nodes.
+	Hash(key string) string
+	// PreferenceList returns up to n distinct node IDs for key, owner first.
+	PreferenceList(key string, n int) []string
 }
 
-func (f *FarmHash) Reset() {
-	f.buf.Reset()
-}
+// sortKeySeparator ends the partition key of a composite key. Partition keys
+// must not contain it.
+const sortKeySeparator = "#"
 
-func (f *FarmHash) Sum64() uint64 {
-	// https://github.com/dgryski/go-farm
-	return farm.Hash64(f.buf.Bytes())
+// CompositeKey returns the key of sortKey within partitionKey.
+func CompositeKey(partitionKey, sortKey string) string {
+	return partitionKey + sortKeySeparator + sortKey
 }
 
-func New(nodes int) *Partition {
-	virtualNodes := nodes * 1000
-	hasher := jump.New(virtualNodes, &FarmHash{})
-	return &Partition{
-		nodes:        nodes,
-		virtualNodes: virtualNodes,
-		hasher:       hasher,
-	}
+// SortKeyPrefix returns the prefix shared by the composite keys of
+// partitionKey.
+func SortKeyPrefix(partitionKey string) string {
+	return partitionKey + sortKeySeparator
 }
 
-func (p *Partition) Hash(key string) int {
-	return p.hasher.Hash(key) % p.nodes
+// PartitionKey returns the partition key of a composite key, and any other
+// key unchanged.
+func PartitionKey(key string) string {
+	partitionKey, _, _ := strings.Cut(key, sortKeySeparator)
+	return partitionKey
 }
@@ -0,0 +1,94 @@
+package partition
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/dgryski/go-farm"
+)
+
+const DefaultVirtualNodes = 128
+
+// Node is a member of a Ring. VirtualNodes is the number of tokens the node
+// owns, so a node with twice the virtual nodes receives about twice the keys.
+type Node struct {
+	ID           string
+	VirtualNodes int
+}
+
+type token struct {
+	hash uint64
+	node string
+}
+
+// Ring is a consistent hash ring with virtual nodes. A key belongs to the
+// first token clockwise from its hash; adding or removing a node only moves
+// the keys of the ranges next to its tokens. A Ring is immutable, build a new
+// one when membership changes.
+type Ring struct {
+	tokens  []token
+	members []string
+}
+
+// NewRing places VirtualNodes tokens for each node on the ring.
+func NewRing(nodes ...Node) *Ring {
+	r := &Ring{}
+	for _, node := range nodes {
+		if node.VirtualNodes < 1 {
+			node.VirtualNodes = DefaultVirtualNodes
+		}
+		r.members = append(r.members, node.ID)
+		for i := 0; i < node.VirtualNodes; i++ {
+			r.tokens = append(r.tokens, token{hash: hashKey(fmt.Sprintf("%s#%d", node.ID, i)), node: node.ID})
+		}
+	}
+	slices.Sort(r.members)
+	r.members = slices.Compact(r.members)
+	sort.Slice(r.tokens, func(i, j int) bool {
+		if r.tokens[i].hash == r.tokens[j].hash {
+			return r.tokens[i].node < r.tokens[j].node
+		}
+		return r.tokens[i].hash < r.tokens[j].hash
+	})
+	return r
+}
+
+func hashKey(key string) uint64 {
+	return farm.Hash64([]byte(key))
+}
+
+// Members returns the IDs of the nodes on the ring, sorted.
+func (r *Ring) Members() []string {
+	return slices.Clone(r.members)
+}
+
+// PreferenceList returns up to n distinct nodes responsible for key: the
+// owner of the first token clockwise from the hash of key followed by the
+// owners of the next tokens.
+func (r *Ring) PreferenceList(key string, n int) []string {
+	if len(r.tokens) == 0 || n < 1 {
+		return nil
+	}
+	n = min(n, len(r.members))
+
+	h := hashKey(key)
+	start := sort.Search(len(r.tokens), func(i int) bool { return r.tokens[i].hash >= h })
+	owners := make([]string, 0, n)
+	for i := 0; i < len(r.tokens) && len(owners) < n; i++ {
+		node := r.tokens[(start+i)%len(r.tokens)].node
+		if !slices.Contains(owners, node) {
+			owners = append(owners, node)
+		}
+	}
+	return owners
+}
+
+// Hash returns the node owning key.
+func (r *Ring) Hash(key string) string {
+	owners := r.PreferenceList(key, 1)
+	if len(owners) == 0 {
+		return ""
+	}
+	return owners[0]
+}
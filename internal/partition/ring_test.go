@@ -0,0 +1,79 @@
+package partition
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingOwnersAreDistinctAndStartWithOwner(t *testing.T) {
+	r := NewRing(Node{ID: "a"}, Node{ID: "b"}, Node{ID: "c"})
+
+	for i := range 100 {
+		key := fmt.Sprintf("key-%d", i)
+		owners := r.PreferenceList(key, 3)
+
+		assert.Equal(t, r.Hash(key), owners[0])
+		assert.ElementsMatch(t, []string{"a", "b", "c"}, owners)
+	}
+	assert.Len(t, r.PreferenceList("key", 5), 3, "owners are capped by the number of members")
+}
+
+func TestRingIsDeterministic(t *testing.T) {
+	a := NewRing(Node{ID: "a"}, Node{ID: "b"}, Node{ID: "c"})
+	b := NewRing(Node{ID: "c"}, Node{ID: "a"}, Node{ID: "b"})
+
+	for i := range 100 {
+		key := fmt.Sprintf("key-%d", i)
+		assert.Equal(t, a.PreferenceList(key, 2), b.PreferenceList(key, 2))
+	}
+}
+
+func TestRingAddingNodeOnlyMovesKeysToIt(t *testing.T) {
+	before := NewRing(Node{ID: "a"}, Node{ID: "b"}, Node{ID: "c"})
+	after := NewRing(Node{ID: "a"}, Node{ID: "b"}, Node{ID: "c"}, Node{ID: "d"})
+
+	moved := 0
+	for i := range 1000 {
+		key := fmt.Sprintf("key-%d", i)
+		was := before.Hash(key)
+		is := after.Hash(key)
+		if was != is {
+			moved++
+			assert.Equal(t, "d", is)
+		}
+	}
+	assert.InDelta(t, 250, moved, 100)
+}
+
+func TestRingVirtualNodesWeightOwnership(t *testing.T) {
+	r := NewRing(Node{ID: "small", VirtualNodes: 50}, Node{ID: "large", VirtualNodes: 150})
+
+	owned := map[string]int{}
+	for i := range 10000 {
+		owned[r.Hash(fmt.Sprintf("key-%d", i))]++
+	}
+	assert.InDelta(t, 7500, owned["large"], 1000)
+}
+
+func TestRingMembersAndEmptyRing(t *testing.T) {
+	r := NewRing(Node{ID: "b"}, Node{ID: "a"})
+
+	assert.Equal(t, []string{"a", "b"}, r.Members())
+	assert.Equal(t, "", NewRing().Hash("key"))
+	assert.Empty(t, NewRing().PreferenceList("key", 2))
+}
+
+func TestRingHashesKeysWhole(t *testing.T) {
+	r := NewRing(Node{ID: "a"}, Node{ID: "b"}, Node{ID: "c"})
+
+	placed := make(map[string]bool)
+	for i := range 100 {
+		owners := r.PreferenceList(CompositeKey("customer-1", fmt.Sprintf("order-%d", i)), 2)
+		placed[strings.Join(owners, ",")] = true
+	}
+	assert.Greater(t, len(placed), 1, "keys sharing a partition key are not placed by it")
+	assert.Equal(t, "customer-1", PartitionKey(CompositeKey("customer-1", "a#b")))
+}
@@ -0,0 +1,383 @@
+// Package s3test provides an in-memory S3 server for tests of the packages
+// that talk to MinIO nodes.
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Server serves the few S3 calls a node makes from memory: objects are put,
+// uploaded in parts, read, stated, listed and removed, and puts honor
+// If-Match and If-None-Match. It holds a single bucket, which always exists.
+type Server struct {
+	mu      sync.Mutex
+	objects map[string]object
+	uploads map[string]*upload
+	removed []string
+	// Listed counts the recursive listings of the whole bucket.
+	Listed atomic.Int64
+	// Served counts the bytes of object bodies sent so far.
+	Served atomic.Int64
+	// Before, when set, runs before each request is served, outside of the
+	// lock, to let a test change the store between two calls.
+	Before func(method, key string)
+	// Deny answers every request with AccessDenied, which the client doesn't
+	// retry, as a node failing fast would.
+	Deny atomic.Bool
+}
+
+type object struct {
+	body     []byte
+	etag     string
+	header   http.Header
+	modified time.Time
+}
+
+type upload struct {
+	key    string
+	header http.Header
+	parts  map[int][]byte
+}
+
+func NewServer() *Server {
+	return &Server{objects: make(map[string]object), uploads: make(map[string]*upload)}
+}
+
+// Put stores an object with the given headers as last modified at modified.
+func (s *Server) Put(key string, body []byte, header http.Header, modified time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = object{body: body, etag: etagOf(body, modified), header: header, modified: modified}
+}
+
+func (s *Server) Has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[key]
+	return ok
+}
+
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RemovedKeys lists the keys removed, in order.
+func (s *Server) RemovedKeys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.removed...)
+}
+
+// etagOf differs between writes of the same body, like the ETag of a
+// rewritten object with new metadata would.
+func etagOf(body []byte, modified time.Time) string {
+	hash := md5.New()
+	hash.Write(body)
+	io.WriteString(hash, modified.String())
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if s.Before != nil {
+		s.Before(r.Method, key)
+	}
+	if s.Deny.Load() {
+		writeError(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	// The body may be streamed from this same server, read it before locking.
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get("X-Amz-Content-Sha256") == streamingPayload {
+		body = decodeChunks(body)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Has("location"):
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, "<LocationConstraint></LocationConstraint>")
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, r, bucket)
+	case key == "":
+		// Buckets are stated or created.
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && r.URL.Query().Has("uploadId"):
+		upload, ok := s.uploads[r.URL.Query().Get("uploadId")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		s.listParts(w, bucket, r.URL.Query().Get("uploadId"), upload)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := s.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for name, values := range object.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("ETag", `"`+object.etag+`"`)
+		w.Header().Set("Last-Modified", object.modified.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprint(len(object.body)))
+		if r.Method == http.MethodGet {
+			s.serve(w, object.body)
+		}
+	case r.Method == http.MethodPost && r.URL.Query().Has("uploads"):
+		id := strconv.Itoa(len(s.uploads) + 1)
+		s.uploads[id] = &upload{key: key, header: userMetadata(r.Header), parts: make(map[int][]byte)}
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", bucket, key, id)
+	case r.Method == http.MethodPut && r.URL.Query().Has("uploadId"):
+		upload, ok := s.uploads[r.URL.Query().Get("uploadId")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		number, _ := strconv.Atoi(r.URL.Query().Get("partNumber"))
+		upload.parts[number] = body
+		w.Header().Set("ETag", `"`+etagOf(body, time.Time{})+`"`)
+	case r.Method == http.MethodPost && r.URL.Query().Has("uploadId"):
+		id := r.URL.Query().Get("uploadId")
+		upload, ok := s.uploads[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		if !s.checkPut(w, r, key) {
+			return
+		}
+		numbers := make([]int, 0, len(upload.parts))
+		for number := range upload.parts {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		var body []byte
+		for _, number := range numbers {
+			body = append(body, upload.parts[number]...)
+		}
+		delete(s.uploads, id)
+		object := s.store(key, body, upload.header)
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>&quot;%s&quot;</ETag></CompleteMultipartUploadResult>", bucket, key, object.etag)
+	case r.Method == http.MethodDelete && r.URL.Query().Has("uploadId"):
+		delete(s.uploads, r.URL.Query().Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		if !s.checkPut(w, r, key) {
+			return
+		}
+		object := s.store(key, body, userMetadata(r.Header))
+		w.Header().Set("ETag", `"`+object.etag+`"`)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		s.removed = append(s.removed, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// checkPut answers a put to key whose If-Match or If-None-Match doesn't
+// hold and reports whether the put can go on.
+func (s *Server) checkPut(w http.ResponseWriter, r *http.Request, key string) bool {
+	current, exists := s.objects[key]
+	ifMatch, ifNoneMatch := strings.Trim(r.Header.Get("If-Match"), `"`), r.Header.Get("If-None-Match")
+	if (ifMatch != "" && (!exists || current.etag != ifMatch)) || (ifNoneMatch == "*" && exists) {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return false
+	}
+	return true
+}
+
+func (s *Server) store(key string, body []byte, header http.Header) object {
+	modified := time.Now()
+	object := object{body: body, etag: etagOf(body, modified), header: header, modified: modified}
+	s.objects[key] = object
+	return object
+}
+
+// serve writes body in chunks, counting each chunk as served before it is
+// sent.
+func (s *Server) serve(w io.Writer, body []byte) {
+	const chunk = 64 << 10
+	for len(body) > 0 {
+		n := min(chunk, len(body))
+		s.Served.Add(int64(n))
+		if _, err := w.Write(body[:n]); err != nil {
+			return
+		}
+		body = body[n:]
+	}
+}
+
+// streamingPayload marks a body sent in signed chunks, as clients do over
+// plain HTTP.
+const streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+
+// decodeChunks returns the data of a body sent in signed chunks, each a
+// "size;chunk-signature=..." line followed by size bytes. A zero size chunk
+// ends it.
+func decodeChunks(body []byte) []byte {
+	var data []byte
+	for {
+		line, rest, ok := strings.Cut(string(body), "\r\n")
+		if !ok {
+			return data
+		}
+		sizeHex, _, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size == 0 || int(size) > len(rest) {
+			return data
+		}
+		data = append(data, rest[:size]...)
+		body = []byte(strings.TrimPrefix(rest[size:], "\r\n"))
+	}
+}
+
+func userMetadata(h http.Header) http.Header {
+	header := make(http.Header)
+	for name, values := range h {
+		if strings.HasPrefix(name, "X-Amz-Meta-") {
+			header[name] = values
+		}
+	}
+	return header
+}
+
+type listing struct {
+	XMLName        xml.Name      `xml:"ListBucketResult"`
+	Name           string        `xml:"Name"`
+	Prefix         string        `xml:"Prefix"`
+	Delimiter      string        `xml:"Delimiter,omitempty"`
+	IsTruncated    bool          `xml:"IsTruncated"`
+	Contents       []listedKey   `xml:"Contents"`
+	CommonPrefixes []listedShelf `xml:"CommonPrefixes"`
+}
+
+type listedKey struct {
+	Key          string      `xml:"Key"`
+	LastModified time.Time   `xml:"LastModified"`
+	ETag         string      `xml:"ETag"`
+	Size         int         `xml:"Size"`
+	UserMetadata *listedMeta `xml:"UserMetadata,omitempty"`
+}
+
+// listedMeta lists user metadata as MinIO does when asked for it, one
+// element per header.
+type listedMeta struct {
+	Entries []listedEntry
+}
+
+type listedEntry struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+type listedShelf struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// list answers with every matching object in a single page.
+func (s *Server) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	prefix, delimiter, startAfter := query.Get("prefix"), query.Get("delimiter"), query.Get("start-after")
+	if prefix == "" && delimiter == "" {
+		s.Listed.Add(1)
+	}
+	result := listing{Name: bucket, Prefix: prefix, Delimiter: delimiter}
+	var keys []string
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	shelves := make(map[string]bool)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || key <= startAfter {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				shelf := key[:len(prefix)+i+len(delimiter)]
+				if !shelves[shelf] {
+					shelves[shelf] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, listedShelf{Prefix: shelf})
+				}
+				continue
+			}
+		}
+		object := s.objects[key]
+		listed := listedKey{
+			Key:          key,
+			LastModified: object.modified.UTC(),
+			ETag:         `"` + object.etag + `"`,
+			Size:         len(object.body),
+		}
+		if query.Get("metadata") == "true" {
+			listed.UserMetadata = &listedMeta{}
+			for name := range object.header {
+				listed.UserMetadata.Entries = append(listed.UserMetadata.Entries, listedEntry{XMLName: xml.Name{Local: name}, Value: object.header.Get(name)})
+			}
+		}
+		result.Contents = append(result.Contents, listed)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+type listedParts struct {
+	XMLName     xml.Name     `xml:"ListPartsResult"`
+	Bucket      string       `xml:"Bucket"`
+	Key         string       `xml:"Key"`
+	UploadID    string       `xml:"UploadId"`
+	IsTruncated bool         `xml:"IsTruncated"`
+	Parts       []listedPart `xml:"Part"`
+}
+
+type listedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+	Size       int    `xml:"Size"`
+}
+
+// listParts answers with every part of an upload in a single page.
+func (s *Server) listParts(w http.ResponseWriter, bucket, id string, upload *upload) {
+	result := listedParts{Bucket: bucket, Key: upload.key, UploadID: id}
+	numbers := make([]int, 0, len(upload.parts))
+	for number := range upload.parts {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	for _, number := range numbers {
+		body := upload.parts[number]
+		result.Parts = append(result.Parts, listedPart{PartNumber: number, ETag: `"` + etagOf(body, time.Time{}) + `"`, Size: len(body)})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
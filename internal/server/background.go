@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// catalogRefreshInterval keeps table definitions used by background tasks
+// about as fresh as the ones requests read.
+const catalogRefreshInterval = 5 * time.Second
+
+// indexBackfillInterval is how often new indexes are looked for. Backfilling
+// an index lists its whole table, but that only happens once per index.
+const indexBackfillInterval = 10 * time.Second
+
+// transactionRecoveryInterval is how often abandoned transactions are looked
+// for. Their items stay locked until they are finished.
+const transactionRecoveryInterval = 10 * time.Second
+
+// streamFlushInterval is how often changes whose record failed to append are
+// looked for. They are only flushed once pending for a minute.
+const streamFlushInterval = 10 * time.Second
+
+// runEvery calls task every interval until ctx is cancelled.
+func runEvery(ctx context.Context, name string, interval time.Duration, task func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := task(ctx); err != nil {
+				slog.Error("Background task failed", slog.String("task", name), slog.String("error", err.Error()))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RunBackground starts the gateway maintenance loops. They stop with ctx.
+func (s *Server) RunBackground(ctx context.Context) {
+	go s.gateway.WatchMembership(ctx)
+	go runEvery(ctx, "hinted-handoff", s.config.HintReplayInterval, s.gateway.ReplayHints)
+	// Anti-entropy, tombstone and dropped table collection and the expiry
+	// sweep share a listing of every node. Tombstones and expired objects are
+	// hidden from reads already, collecting them as often as replicas are
+	// compared is plenty.
+	go runEvery(ctx, "maintenance", s.config.AntiEntropyInterval, s.gateway.RunMaintenance)
+	go runEvery(ctx, "catalog-refresh", catalogRefreshInterval, s.gateway.RefreshCatalog)
+	go runEvery(ctx, "index-backfill", indexBackfillInterval, s.gateway.BackfillIndexes)
+	// Streams and uploads are listed by prefix, only their own keys.
+	go runEvery(ctx, "stream-trim", s.config.AntiEntropyInterval, s.gateway.TrimStreams)
+	go runEvery(ctx, "stream-flush", streamFlushInterval, s.gateway.FlushStreams)
+	// Abandoned uploads are kept for a week, how often they are looked for
+	// hardly matters.
+	go runEvery(ctx, "upload-gc", s.config.AntiEntropyInterval, s.gateway.CollectUploads)
+	go runEvery(ctx, "transaction-recovery", transactionRecoveryInterval, s.gateway.RecoverTransactions)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/vrnvu/go-dynamolike/internal/client"
+	"github.com/vrnvu/go-dynamolike/internal/item"
+)
+
+// keysAndAttributes are the keys to read from a table, named after the
+// DynamoDB API parameters like the rest of the batch endpoints.
+type keysAndAttributes struct {
+	Keys                     []item.Item       `json:"keys"`
+	ProjectionExpression     string            `json:"projection_expression,omitempty"`
+	ExpressionAttributeNames map[string]string `json:"expression_attribute_names,omitempty"`
+}
+
+type batchGetRequest struct {
+	RequestItems map[string]keysAndAttributes `json:"request_items"`
+}
+
+type batchGetResponse struct {
+	Responses       map[string][]item.Item       `json:"responses"`
+	UnprocessedKeys map[string]keysAndAttributes `json:"unprocessed_keys"`
+}
+
+type putRequest struct {
+	Item item.Item `json:"item"`
+}
+
+type deleteRequest struct {
+	Key item.Item `json:"key"`
+}
+
+// writeRequest holds either a put or a delete.
+type writeRequest struct {
+	PutRequest    *putRequest    `json:"put_request,omitempty"`
+	DeleteRequest *deleteRequest `json:"delete_request,omitempty"`
+}
+
+type batchWriteRequest struct {
+	RequestItems map[string][]writeRequest `json:"request_items"`
+}
+
+type batchWriteResponse struct {
+	UnprocessedItems map[string][]writeRequest `json:"unprocessed_items"`
+}
+
+// handleBatchGet reads the requested keys of several tables. Keys that
+// couldn't be read are answered as unprocessed keys, to be requested again.
+func (s *Server) handleBatchGet(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	var req batchGetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, requestID, fmt.Errorf("%w: batch get request: %w", client.ErrInvalidArgument, err))
+		return
+	}
+	consistency, err := client.ParseConsistency(r.Header.Get(consistencyHeader))
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	requests := make(map[string]client.BatchGetRequest, len(req.RequestItems))
+	for table, keys := range req.RequestItems {
+		requests[table] = client.BatchGetRequest{
+			Keys:       keys.Keys,
+			Projection: keys.ProjectionExpression,
+			Params:     item.Params{Names: keys.ExpressionAttributeNames},
+		}
+	}
+	result, err := s.gateway.BatchGetItem(r.Context(), requests, consistency)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+
+	resp := batchGetResponse{Responses: result.Responses, UnprocessedKeys: make(map[string]keysAndAttributes)}
+	for table, unprocessed := range result.Unprocessed {
+		resp.UnprocessedKeys[table] = keysAndAttributes{
+			Keys:                     unprocessed.Keys,
+			ProjectionExpression:     unprocessed.Projection,
+			ExpressionAttributeNames: unprocessed.Params.Names,
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleBatchWrite puts and deletes items of several tables. Writes that
+// failed are answered as unprocessed items, to be sent again.
+func (s *Server) handleBatchWrite(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	var req batchWriteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, requestID, fmt.Errorf("%w: batch write request: %w", client.ErrInvalidArgument, err))
+		return
+	}
+	consistency, err := client.ParseConsistency(r.Header.Get(consistencyHeader))
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	requests := make(map[string][]client.WriteRequest, len(req.RequestItems))
+	for table, writes := range req.RequestItems {
+		for _, write := range writes {
+			var request client.WriteRequest
+			if write.PutRequest != nil {
+				request.Put = write.PutRequest.Item
+			}
+			if write.DeleteRequest != nil {
+				request.Delete = write.DeleteRequest.Key
+			}
+			requests[table] = append(requests[table], request)
+		}
+	}
+	unprocessed, err := s.gateway.BatchWriteItem(r.Context(), requests, consistency)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+
+	resp := batchWriteResponse{UnprocessedItems: make(map[string][]writeRequest)}
+	for table, writes := range unprocessed {
+		for _, write := range writes {
+			var request writeRequest
+			if write.Put != nil {
+				request.PutRequest = &putRequest{Item: write.Put}
+			} else {
+				request.DeleteRequest = &deleteRequest{Key: write.Delete}
+			}
+			resp.UnprocessedItems[table] = append(resp.UnprocessedItems[table], request)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/vrnvu/go-dynamolike/internal/client"
+)
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// errorResponse is the JSON body of every error response.
+type errorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	// CancellationReasons tell why each item of a cancelled transaction
+	// kept it from committing.
+	CancellationReasons []client.CancellationReason `json:"cancellation_reasons,omitempty"`
+}
+
+// statusOf maps an error returned by the gateway to a status code and the
+// code reported in the error body.
+func statusOf(err error) (int, string) {
+	switch {
+	case errors.Is(err, client.ErrInvalidArgument):
+		return http.StatusBadRequest, "InvalidArgument"
+	case errors.Is(err, client.ErrNotFound):
+		return http.StatusNotFound, "NotFound"
+	case errors.Is(err, errMethodNotAllowed):
+		return http.StatusMethodNotAllowed, "MethodNotAllowed"
+	case errors.Is(err, client.ErrConflict):
+		return http.StatusConflict, "Conflict"
+	case errors.Is(err, client.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed, "PreconditionFailed"
+	case errors.Is(err, client.ErrNodeUnavailable):
+		return http.StatusServiceUnavailable, "NodeUnavailable"
+	default:
+		return http.StatusInternalServerError, "InternalError"
+	}
+}
+
+// writeError answers with the status matching err. Server errors are logged
+// with attrs and their details are kept out of the response.
+func writeError(w http.ResponseWriter, requestID string, err error, attrs ...any) {
+	status, code := statusOf(err)
+	message := err.Error()
+	attrs = append(attrs, slog.String("request_id", requestID), slog.Int("status", status), slog.String("error", message))
+	if status >= http.StatusInternalServerError {
+		slog.Error("Request failed", attrs...)
+	} else {
+		slog.Debug("Request rejected", attrs...)
+	}
+	if status == http.StatusInternalServerError {
+		message = "Internal server error"
+	}
+	resp := errorResponse{Code: code, Message: message, RequestID: requestID}
+	var cancelled *client.TransactionCanceledError
+	if errors.As(err, &cancelled) {
+		resp.Code = "TransactionCanceled"
+		resp.CancellationReasons = cancelled.Reasons
+	}
+	writeJSON(w, status, resp)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vrnvu/go-dynamolike/internal/client"
+)
+
+func TestStatusOf(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("object a: %w", client.ErrNotFound), http.StatusNotFound},
+		{fmt.Errorf("%w: quorum not met", client.ErrNodeUnavailable), http.StatusServiceUnavailable},
+		{fmt.Errorf("%w: etag", client.ErrPreconditionFailed), http.StatusPreconditionFailed},
+		{fmt.Errorf("%w: id", client.ErrInvalidArgument), http.StatusBadRequest},
+		{fmt.Errorf("%w: cas", client.ErrConflict), http.StatusConflict},
+		{fmt.Errorf("boom"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		status, _ := statusOf(tt.err)
+		assert.Equal(t, tt.status, status, tt.err.Error())
+	}
+}
+
+func TestWriteErrorHidesInternalErrors(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	writeError(rec, "request-1", fmt.Errorf("secret backend detail"))
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var body errorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, errorResponse{Code: "InternalError", Message: "Internal server error", RequestID: "request-1"}, body)
+}
+
+func TestWriteErrorReportsCancellationReasons(t *testing.T) {
+	rec := httptest.NewRecorder()
+	reasons := []client.CancellationReason{{Code: client.ReasonNone}, {Code: client.ReasonTransactionConflict}}
+
+	writeError(rec, "request-1", &client.TransactionCanceledError{Reasons: reasons})
+
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	var body errorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "TransactionCanceled", body.Code)
+	assert.Equal(t, reasons, body.CancellationReasons)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/vrnvu/go-dynamolike/internal/client"
+	"github.com/vrnvu/go-dynamolike/internal/item"
+)
+
+// itemRequest is the body of the item endpoints, named after the DynamoDB
+// API parameters.
+type itemRequest struct {
+	Item                      item.Item             `json:"item"`
+	Key                       item.Item             `json:"key"`
+	ConditionExpression       string                `json:"condition_expression"`
+	UpdateExpression          string                `json:"update_expression"`
+	ProjectionExpression      string                `json:"projection_expression"`
+	ExpressionAttributeNames  map[string]string     `json:"expression_attribute_names"`
+	ExpressionAttributeValues map[string]item.Value `json:"expression_attribute_values"`
+}
+
+func (req itemRequest) params() item.Params {
+	return item.Params{Names: req.ExpressionAttributeNames, Values: req.ExpressionAttributeValues}
+}
+
+type getItemResponse struct {
+	Item item.Item `json:"item"`
+}
+
+type updateItemResponse struct {
+	Attributes item.Item `json:"attributes"`
+}
+
+// parseItemRequest decodes the body of an item endpoint.
+func parseItemRequest(r *http.Request) (itemRequest, client.Consistency, error) {
+	var req itemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, client.ConsistencyDefault, fmt.Errorf("%w: item request: %w", client.ErrInvalidArgument, err)
+	}
+	consistency, err := client.ParseConsistency(r.Header.Get(consistencyHeader))
+	return req, consistency, err
+}
+
+func (s *Server) handlePutItem(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	req, consistency, err := parseItemRequest(r)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	opts := client.WriteItemOptions{Consistency: consistency, Condition: req.ConditionExpression, Params: req.params()}
+	if err := s.gateway.PutItem(r.Context(), r.PathValue("table"), req.Item, opts); err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGetItem(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	req, consistency, err := parseItemRequest(r)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	opts := client.GetItemOptions{Consistency: consistency, Projection: req.ProjectionExpression, Params: req.params()}
+	it, err := s.gateway.GetItem(r.Context(), r.PathValue("table"), req.Key, opts)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, getItemResponse{Item: it})
+}
+
+func (s *Server) handleUpdateItem(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	req, consistency, err := parseItemRequest(r)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	opts := client.WriteItemOptions{Consistency: consistency, Condition: req.ConditionExpression, Params: req.params()}
+	it, err := s.gateway.UpdateItem(r.Context(), r.PathValue("table"), req.Key, req.UpdateExpression, opts)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updateItemResponse{Attributes: it})
+}
+
+func (s *Server) handleDeleteItem(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	req, consistency, err := parseItemRequest(r)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	opts := client.WriteItemOptions{Consistency: consistency, Condition: req.ConditionExpression, Params: req.params()}
+	if err := s.gateway.DeleteItem(r.Context(), r.PathValue("table"), req.Key, opts); err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type queryRequest struct {
+	IndexName                 string                `json:"index_name"`
+	KeyConditionExpression    string                `json:"key_condition_expression"`
+	ExpressionAttributeNames  map[string]string     `json:"expression_attribute_names"`
+	ExpressionAttributeValues map[string]item.Value `json:"expression_attribute_values"`
+	// ScanIndexForward selects ascending sort key order, the default.
+	ScanIndexForward  *bool     `json:"scan_index_forward"`
+	Limit             int       `json:"limit"`
+	ExclusiveStartKey item.Item `json:"exclusive_start_key"`
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	var req queryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, requestID, fmt.Errorf("%w: query request: %w", client.ErrInvalidArgument, err))
+		return
+	}
+	consistency, err := client.ParseConsistency(r.Header.Get(consistencyHeader))
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	result, err := s.gateway.Query(r.Context(), r.PathValue("table"), client.QueryOptions{
+		Consistency:       consistency,
+		Index:             req.IndexName,
+		KeyCondition:      req.KeyConditionExpression,
+		Params:            item.Params{Names: req.ExpressionAttributeNames, Values: req.ExpressionAttributeValues},
+		Descending:        req.ScanIndexForward != nil && !*req.ScanIndexForward,
+		Limit:             req.Limit,
+		ExclusiveStartKey: req.ExclusiveStartKey,
+	})
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -1,98 +1,502 @@
 package server
 
 import (
+	"encoding/json"
+	"expvar"
 	"fmt"
 	"io"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
 	"github.com/vrnvu/go-dynamolike/internal/client"
 	"github.com/vrnvu/go-dynamolike/internal/discovery"
 	"github.com/vrnvu/go-dynamolike/internal/partition"
+	"github.com/vrnvu/go-dynamolike/internal/vclock"
 )
 
 type Server struct {
 	Server  *http.Server
 	gateway *client.MinioGateway
+	config  Config
+}
+
+type Config struct {
+	Port                int
+	ReplicationFactor   int
+	ReadQuorum          int
+	WriteQuorum         int
+	SloppyQuorum        bool
+	HintReplayInterval  time.Duration
+	AntiEntropyInterval time.Duration
+	VirtualNodes        int
+	TombstoneGrace      time.Duration
 }
 
 const (
-	objectPath = "/object/{id}"
+	objectPath      = "/object/{id}"
+	incrementPath   = "/object/{id}/increment"
+	uploadsPath     = "/object/{id}/uploads"
+	uploadPath      = "/object/{id}/uploads/{upload}"
+	partPath        = "/object/{id}/uploads/{upload}/parts/{part}"
+	objectsPath     = "/objects"
+	tablesPath      = "/tables"
+	tablePath       = "/tables/{table}"
+	itemPath        = "/tables/{table}/items/{id}"
+	putItemPath     = "/tables/{table}/put-item"
+	getItemPath     = "/tables/{table}/get-item"
+	updateItemPath  = "/tables/{table}/update-item"
+	deleteItemPath  = "/tables/{table}/delete-item"
+	queryPath       = "/tables/{table}/query"
+	indexPath       = "/tables/{table}/indexes/{index}"
+	ttlPath         = "/tables/{table}/ttl"
+	tableStreamPath = "/tables/{table}/stream"
+	streamPath      = "/streams/{table}"
+	transactPath    = "/transact-write-items"
+	batchGetPath    = "/batch/get"
+	batchWritePath  = "/batch/write"
+	antiEntropyPath = "/admin/anti-entropy"
+	rebalancePath   = "/admin/rebalance"
+
+	consistencyHeader = "X-Consistency"
+	contextHeader     = "X-Context"
+	versionHeader     = "X-Version"
+	siblingsHeader    = "X-Siblings"
+	// expiresAtHeader holds the expiry of an object in Unix seconds.
+	expiresAtHeader = "X-Expires-At"
+	// User metadata travels in headers named like S3 does.
+	userMetadataPrefix = "X-Amz-Meta-"
 )
 
 func generateRequestID() string {
 	return uuid.New().String()
 }
 
+// objectRequest holds what the object handlers parse from every request.
+type objectRequest struct {
+	requestID   string
+	objectID    string
+	table       string
+	consistency client.Consistency
+}
+
+// parseObjectRequest serves both objects and table items, which have a
+// table path value.
+func parseObjectRequest(w http.ResponseWriter, r *http.Request) (objectRequest, error) {
+	req := objectRequest{requestID: generateRequestID(), objectID: r.PathValue("id"), table: r.PathValue("table")}
+	w.Header().Set("X-Request-ID", req.requestID)
+
+	if client.IsReserved(req.objectID) {
+		return req, fmt.Errorf("%w: reserved object id %s", client.ErrInvalidArgument, req.objectID)
+	}
+	var err error
+	req.consistency, err = client.ParseConsistency(r.Header.Get(consistencyHeader))
+	return req, err
+}
+
+// parseContext decodes the X-Context header, nil when it is absent.
+func parseContext(r *http.Request) (vclock.Clock, error) {
+	token := r.Header.Get(contextHeader)
+	if token == "" {
+		return nil, nil
+	}
+	clock, err := vclock.Decode(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s header: %w", client.ErrInvalidArgument, contextHeader, err)
+	}
+	return clock, nil
+}
+
+// parseConditions reads the If-None-Match and If-Match headers into opts.
+// If-None-Match only supports *, a create that fails if the object exists.
+func parseConditions(r *http.Request, opts *client.PutOptions) error {
+	ifNoneMatch, ifMatch := r.Header.Get("If-None-Match"), r.Header.Get("If-Match")
+	switch {
+	case ifNoneMatch != "" && ifMatch != "":
+		return fmt.Errorf("%w: If-None-Match and If-Match can't be combined", client.ErrInvalidArgument)
+	case ifNoneMatch != "" && ifNoneMatch != "*":
+		return fmt.Errorf("%w: If-None-Match only supports *", client.ErrInvalidArgument)
+	}
+	opts.IfNoneMatch = ifNoneMatch == "*"
+	opts.IfMatch = ifMatch
+	return nil
+}
+
+// parseExpiresAt returns the expiry requested with the X-Expires-At header,
+// zero without one.
+func parseExpiresAt(r *http.Request) (time.Time, error) {
+	value := r.Header.Get(expiresAtHeader)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}, fmt.Errorf("%w: %s header %q, expected Unix seconds", client.ErrInvalidArgument, expiresAtHeader, value)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
 func (s *Server) handleGetObject(w http.ResponseWriter, r *http.Request) {
-	requestID := generateRequestID()
-	w.Header().Set("X-Request-ID", requestID)
+	req, err := parseObjectRequest(w, r)
+	if err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
 
-	objectID := r.PathValue("id")
-	object, err := s.gateway.Get(r.Context(), objectID)
+	object, err := s.gateway.Get(r.Context(), req.objectID, client.GetOptions{Consistency: req.consistency, Table: req.table})
 	if err != nil {
-		slog.Error("Failed to get object",
-			slog.String("request_id", requestID),
-			slog.String("object_id", objectID),
-			slog.String("error", err.Error()),
-		)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, req.requestID, err, slog.String("object_id", req.objectID))
 		return
 	}
 
-	defer object.Close()
-	if _, err := io.Copy(w, object); err != nil {
+	// Bodies are opened before answering so a missing one turns into an
+	// error status instead of a truncated response.
+	bodies := make([]*minio.Object, 0, len(object.Siblings))
+	defer func() {
+		for _, body := range bodies {
+			body.Close()
+		}
+	}()
+	for _, sibling := range object.Siblings {
+		body, err := sibling.Open(r.Context())
+		if err != nil {
+			writeError(w, req.requestID, err, slog.String("object_id", req.objectID))
+			return
+		}
+		bodies = append(bodies, body)
+	}
+
+	w.Header().Set(contextHeader, object.Context.Encode())
+	if len(object.Siblings) == 1 {
+		setVersionHeaders(w.Header(), object.Siblings[0])
+		_, err = io.Copy(w, bodies[0])
+	} else {
+		err = writeSiblings(w, object, bodies)
+	}
+	if err != nil {
 		slog.Error("Failed to write object to response",
-			slog.String("request_id", requestID),
-			slog.String("object_id", objectID),
+			slog.String("request_id", req.requestID),
+			slog.String("object_id", req.objectID),
 			slog.String("error", err.Error()),
 		)
 	}
 }
 
+// handleHeadObject answers like handleGetObject without reading any body.
+func (s *Server) handleHeadObject(w http.ResponseWriter, r *http.Request) {
+	req, err := parseObjectRequest(w, r)
+	if err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+
+	object, err := s.gateway.Stat(r.Context(), req.objectID, client.GetOptions{Consistency: req.consistency, Table: req.table})
+	if err != nil {
+		writeError(w, req.requestID, err, slog.String("object_id", req.objectID))
+		return
+	}
+
+	w.Header().Set(contextHeader, object.Context.Encode())
+	if len(object.Siblings) > 1 {
+		w.Header().Set(siblingsHeader, strconv.Itoa(len(object.Siblings)))
+		w.WriteHeader(http.StatusMultipleChoices)
+		return
+	}
+	setVersionHeaders(w.Header(), object.Siblings[0])
+	w.WriteHeader(http.StatusOK)
+}
+
+func setVersionHeaders(header http.Header, version client.Version) {
+	header.Set("Content-Length", strconv.FormatInt(version.Info.Size, 10))
+	header.Set("Etag", version.Info.ETag)
+	header.Set("Last-Modified", version.Info.LastModified.UTC().Format(http.TimeFormat))
+	header.Set(versionHeader, version.ID())
+	if !version.ExpiresAt.IsZero() {
+		header.Set(expiresAtHeader, strconv.FormatInt(version.ExpiresAt.Unix(), 10))
+	}
+	for key, value := range version.UserMetadata {
+		header.Set(userMetadataPrefix+key, value)
+	}
+}
+
+// userMetadata returns the user metadata headers of r without their prefix.
+func userMetadata(r *http.Request) map[string]string {
+	var metadata map[string]string
+	for key, values := range r.Header {
+		name, ok := strings.CutPrefix(key, userMetadataPrefix)
+		if !ok || name == "" {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[name] = strings.Join(values, ",")
+	}
+	return metadata
+}
+
+// writeSiblings answers with 300 Multiple Choices and one multipart/mixed part
+// per concurrent version. The client resolves the conflict by writing back
+// with the X-Context header of the response.
+func writeSiblings(w http.ResponseWriter, object *client.Object, bodies []*minio.Object) error {
+	parts := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+parts.Boundary())
+	w.Header().Set(siblingsHeader, strconv.Itoa(len(object.Siblings)))
+	w.WriteHeader(http.StatusMultipleChoices)
+
+	for i, sibling := range object.Siblings {
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {sibling.Info.ContentType},
+			"Etag":          {sibling.Info.ETag},
+			"Last-Modified": {sibling.Info.LastModified.UTC().Format(http.TimeFormat)},
+			versionHeader:   {sibling.ID()},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, bodies[i]); err != nil {
+			return err
+		}
+	}
+	return parts.Close()
+}
+
 func (s *Server) handlePutObject(w http.ResponseWriter, r *http.Request) {
-	requestID := generateRequestID()
-	w.Header().Set("X-Request-ID", requestID)
+	req, err := parseObjectRequest(w, r)
+	if err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+	clock, err := parseContext(r)
+	if err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
 
-	objectID := r.PathValue("id")
-	uploadInfo, err := s.gateway.Put(r.Context(), objectID, r.Body)
+	opts := client.PutOptions{
+		Consistency:  req.consistency,
+		Table:        req.table,
+		Context:      clock,
+		UserMetadata: userMetadata(r),
+	}
+	if err := parseConditions(r, &opts); err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+	if opts.ExpiresAt, err = parseExpiresAt(r); err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+
+	uploadInfo, err := s.gateway.Put(r.Context(), req.objectID, r.Body, r.ContentLength, opts)
 	if err != nil {
-		slog.Error("Failed to put object",
-			slog.String("request_id", requestID),
-			slog.String("object_id", objectID),
-			slog.String("error", err.Error()),
-		)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, req.requestID, err, slog.String("object_id", req.objectID))
 		return
 	}
 
+	w.Header().Set("Etag", uploadInfo.ETag)
+	w.Header().Set(contextHeader, uploadInfo.Clock.Encode())
+	w.Header().Set(versionHeader, uploadInfo.Clock.Version())
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "Key: %s, Bucket: %s, Location: %s", uploadInfo.Key, uploadInfo.Bucket, uploadInfo.Location)
 }
 
+func (s *Server) handleDeleteObject(w http.ResponseWriter, r *http.Request) {
+	req, err := parseObjectRequest(w, r)
+	if err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+	clock, err := parseContext(r)
+	if err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+
+	opts := client.PutOptions{Consistency: req.consistency, Table: req.table, Context: clock}
+	if err := parseConditions(r, &opts); err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+
+	result, err := s.gateway.Delete(r.Context(), req.objectID, opts)
+	if err != nil {
+		writeError(w, req.requestID, err, slog.String("object_id", req.objectID))
+		return
+	}
+
+	w.Header().Set(contextHeader, result.Clock.Encode())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleIncrement adds the by query parameter, 1 by default, to a counter
+// object and answers with its new value.
+func (s *Server) handleIncrement(w http.ResponseWriter, r *http.Request) {
+	req, err := parseObjectRequest(w, r)
+	if err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+	by := int64(1)
+	if value := r.URL.Query().Get("by"); value != "" {
+		if by, err = strconv.ParseInt(value, 10, 64); err != nil {
+			writeError(w, req.requestID, fmt.Errorf("%w: by %q", client.ErrInvalidArgument, value))
+			return
+		}
+	}
+	opts := client.PutOptions{Consistency: req.consistency, Table: req.table, UserMetadata: userMetadata(r)}
+	if opts.ExpiresAt, err = parseExpiresAt(r); err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+
+	value, result, err := s.gateway.Increment(r.Context(), req.objectID, by, opts)
+	if err != nil {
+		writeError(w, req.requestID, err, slog.String("object_id", req.objectID))
+		return
+	}
+
+	w.Header().Set("Etag", result.ETag)
+	w.Header().Set(contextHeader, result.Clock.Encode())
+	w.Header().Set(versionHeader, result.Clock.Version())
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, value)
+}
+
+func (s *Server) handleListObjects(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	query := r.URL.Query()
+	opts := client.ListOptions{
+		Prefix:            query.Get("prefix"),
+		StartAfter:        query.Get("start_after"),
+		ContinuationToken: query.Get("continuation_token"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		if opts.Limit, err = strconv.Atoi(limit); err != nil || opts.Limit == 0 {
+			writeError(w, requestID, fmt.Errorf("%w: limit %q", client.ErrInvalidArgument, limit))
+			return
+		}
+	}
+
+	result, err := s.gateway.List(r.Context(), opts)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleAntiEntropyStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.gateway.AntiEntropyStatus())
+}
+
+func (s *Server) handleRebalanceStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.gateway.RebalanceStatus())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to encode JSON response", slog.String("error", err.Error()))
+	}
+}
+
+// handleObject serves objects.
+func (s *Server) handleObject(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGetObject(w, r)
+	case http.MethodHead:
+		s.handleHeadObject(w, r)
+	case http.MethodPut:
+		s.handlePutObject(w, r)
+	case http.MethodDelete:
+		s.handleDeleteObject(w, r)
+	default:
+		requestID := generateRequestID()
+		w.Header().Set("X-Request-ID", requestID)
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		writeError(w, requestID, fmt.Errorf("%w: %s", errMethodNotAllowed, r.Method))
+	}
+}
+
+// handleItem serves reads of table items. Items are only written through the
+// item API, which keeps them JSON documents and updates their indexes.
+func (s *Server) handleItem(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGetObject(w, r)
+	case http.MethodHead:
+		s.handleHeadObject(w, r)
+	default:
+		requestID := generateRequestID()
+		w.Header().Set("X-Request-ID", requestID)
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, requestID, fmt.Errorf("%w: %s", errMethodNotAllowed, r.Method))
+	}
+}
+
 func (s *Server) newHandler() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc(objectPath, func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			s.handleGetObject(w, r)
-		case http.MethodPut:
-			s.handlePutObject(w, r)
-		default:
-			panic(fmt.Sprintf("Unsupported HTTP method: %s", r.Method))
-		}
-	})
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("GET "+objectsPath, s.handleListObjects)
+	mux.HandleFunc("GET "+antiEntropyPath, s.handleAntiEntropyStatus)
+	mux.HandleFunc("GET "+rebalancePath, s.handleRebalanceStatus)
+	mux.HandleFunc("GET "+tablesPath, s.handleListTables)
+	mux.HandleFunc("GET "+tablePath, s.handleDescribeTable)
+	mux.HandleFunc("PUT "+tablePath, s.handleCreateTable)
+	mux.HandleFunc("DELETE "+tablePath, s.handleDeleteTable)
+	mux.HandleFunc("POST "+putItemPath, s.handlePutItem)
+	mux.HandleFunc("POST "+getItemPath, s.handleGetItem)
+	mux.HandleFunc("POST "+updateItemPath, s.handleUpdateItem)
+	mux.HandleFunc("POST "+deleteItemPath, s.handleDeleteItem)
+	mux.HandleFunc("POST "+queryPath, s.handleQuery)
+	mux.HandleFunc("PUT "+indexPath, s.handleCreateIndex)
+	mux.HandleFunc("DELETE "+indexPath, s.handleDeleteIndex)
+	mux.HandleFunc("PUT "+ttlPath, s.handleSetTimeToLive)
+	mux.HandleFunc("PUT "+tableStreamPath, s.handleSetStream)
+	mux.HandleFunc("GET "+streamPath, s.handleReadStream)
+	mux.HandleFunc("POST "+transactPath, s.handleTransactWrite)
+	mux.HandleFunc("POST "+batchGetPath, s.handleBatchGet)
+	mux.HandleFunc("POST "+batchWritePath, s.handleBatchWrite)
+	mux.HandleFunc("POST "+incrementPath, s.handleIncrement)
+	mux.HandleFunc("POST "+uploadsPath, s.handleCreateUpload)
+	mux.HandleFunc("PUT "+partPath, s.handleUploadPart)
+	mux.HandleFunc("GET "+uploadPath, s.handleListParts)
+	mux.HandleFunc("POST "+uploadPath, s.handleCompleteUpload)
+	mux.HandleFunc("DELETE "+uploadPath, s.handleAbortUpload)
+	mux.HandleFunc(objectPath, s.handleObject)
+	mux.HandleFunc(itemPath, s.handleItem)
 	return mux
 }
 
-func NewServer(port int, registry *discovery.DockerRegistry) *Server {
-	const defaultPartitionSize = 2 // TODO: Make this configurable
+func NewServer(config Config, registry *discovery.DockerRegistry) *Server {
+	newRing := func(nodeIDs []string) partition.Partitioner {
+		members := make([]partition.Node, 0, len(nodeIDs))
+		for _, id := range nodeIDs {
+			members = append(members, partition.Node{ID: id, VirtualNodes: config.VirtualNodes})
+		}
+		return partition.NewRing(members...)
+	}
 
 	gateway, err := client.NewMinioGatewayFixed().
 		WithRegistry(registry).
-		WithPartitioner(partition.New(defaultPartitionSize)).
+		WithPartitionerFactory(newRing).
+		WithReplicationFactor(config.ReplicationFactor).
+		WithQuorum(config.ReadQuorum, config.WriteQuorum).
+		WithSloppyQuorum(config.SloppyQuorum).
+		WithTombstoneGrace(config.TombstoneGrace).
 		InitializeBuckets()
 	if err != nil {
 		slog.Error("Failed to create Minio gateway", slog.String("error", err.Error()))
@@ -101,8 +505,9 @@ func NewServer(port int, registry *discovery.DockerRegistry) *Server {
 
 	s := &Server{
 		gateway: gateway,
+		config:  config,
 		Server: &http.Server{
-			Addr:    fmt.Sprintf(":%d", port),
+			Addr:    fmt.Sprintf(":%d", config.Port),
 			Handler: nil,
 		},
 	}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vrnvu/go-dynamolike/internal/client"
+	"github.com/vrnvu/go-dynamolike/internal/discovery"
+	"github.com/vrnvu/go-dynamolike/internal/partition"
+	"github.com/vrnvu/go-dynamolike/internal/s3test"
+)
+
+// staticRegistry reports a fixed set of healthy instances.
+type staticRegistry struct {
+	instances []discovery.MinioInstance
+}
+
+func (r *staticRegistry) GetInstances() []discovery.MinioInstance { return r.instances }
+
+func (r *staticRegistry) GetInstance(key string) (discovery.MinioInstance, error) {
+	for _, instance := range r.instances {
+		if instance.ID == key {
+			return instance, nil
+		}
+	}
+	return discovery.MinioInstance{}, fmt.Errorf("instance %s not found", key)
+}
+
+func (r *staticRegistry) AddInstance(string, discovery.MinioInstance) {}
+func (r *staticRegistry) RemoveInstance(string)                       {}
+func (r *staticRegistry) PollNetwork() error                          { return nil }
+func (r *staticRegistry) Subscribe() <-chan struct{}                  { return make(chan struct{}) }
+
+// newTestHandler returns the handler of a server whose gateway stores every
+// object on a single in-memory node.
+func newTestHandler(t *testing.T) http.Handler {
+	t.Helper()
+	node := httptest.NewServer(s3test.NewServer())
+	t.Cleanup(node.Close)
+	endpoint, _ := url.Parse(node.URL)
+	host, port, _ := net.SplitHostPort(endpoint.Host)
+
+	registry := &staticRegistry{instances: []discovery.MinioInstance{{
+		ID: "a", NodeID: "a", IP: host, ContainerPort: port, User: "minio", Password: "minio123", Healthy: true,
+	}}}
+	gateway, err := client.NewMinioGatewayFixed().
+		WithID("gateway").
+		WithRegistry(registry).
+		WithPartitioner(partition.NewRing(partition.Node{ID: "a"})).
+		WithReplicationFactor(1).
+		InitializeBuckets()
+	require.NoError(t, err)
+	return (&Server{gateway: gateway}).newHandler()
+}
+
+func serve(handler http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	return rec
+}
+
+// decodeError decodes the JSON error body of rec.
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) errorResponse {
+	t.Helper()
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var body errorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, rec.Header().Get("X-Request-ID"), body.RequestID)
+	return body
+}
+
+func TestHeadObject(t *testing.T) {
+	handler := newTestHandler(t)
+	put := httptest.NewRequest(http.MethodPut, "/object/report", strings.NewReader("quarterly"))
+	put.Header.Set(userMetadataPrefix+"Owner", "alice")
+	require.Equal(t, http.StatusOK, serve(handler, put).Code)
+
+	rec := serve(handler, httptest.NewRequest(http.MethodHead, "/object/report", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "9", rec.Header().Get("Content-Length"))
+	assert.Equal(t, "alice", rec.Header().Get(userMetadataPrefix+"Owner"))
+	assert.NotEmpty(t, rec.Header().Get(contextHeader))
+	assert.NotEmpty(t, rec.Header().Get(versionHeader))
+	assert.Empty(t, rec.Body.String())
+}
+
+func TestHeadMissingObject(t *testing.T) {
+	handler := newTestHandler(t)
+
+	rec := serve(handler, httptest.NewRequest(http.MethodHead, "/object/missing", nil))
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestDeleteObject(t *testing.T) {
+	handler := newTestHandler(t)
+	require.Equal(t, http.StatusOK, serve(handler, httptest.NewRequest(http.MethodPut, "/object/report", strings.NewReader("v1"))).Code)
+
+	rec := serve(handler, httptest.NewRequest(http.MethodDelete, "/object/report", nil))
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get(contextHeader))
+	rec = serve(handler, httptest.NewRequest(http.MethodGet, "/object/report", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "NotFound", decodeError(t, rec).Code)
+}
+
+func TestObjectMethodNotAllowed(t *testing.T) {
+	handler := newTestHandler(t)
+
+	rec := serve(handler, httptest.NewRequest(http.MethodPatch, "/object/report", nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, HEAD, PUT, DELETE", rec.Header().Get("Allow"))
+	assert.Equal(t, "MethodNotAllowed", decodeError(t, rec).Code)
+}
+
+func TestItemsAreNotWrittenRaw(t *testing.T) {
+	handler := (&Server{}).newHandler()
+	requests := []*http.Request{
+		httptest.NewRequest(http.MethodPut, "/tables/users/items/alice", strings.NewReader("alice")),
+		httptest.NewRequest(http.MethodDelete, "/tables/users/items/alice", nil),
+		httptest.NewRequest(http.MethodPost, "/tables/users/items/alice/increment", nil),
+		httptest.NewRequest(http.MethodPost, "/tables/users/items/alice/uploads", nil),
+	}
+	for _, r := range requests {
+		rec := serve(handler, r)
+
+		assert.Contains(t, []int{http.StatusMethodNotAllowed, http.StatusNotFound}, rec.Code, "%s %s", r.Method, r.URL)
+	}
+}
+
+func TestInvalidRequestsAnswerWithJSONErrors(t *testing.T) {
+	handler := newTestHandler(t)
+	badContext := httptest.NewRequest(http.MethodPut, "/object/report", strings.NewReader("v1"))
+	badContext.Header.Set(contextHeader, "not a clock")
+	badCondition := httptest.NewRequest(http.MethodDelete, "/object/report", nil)
+	badCondition.Header.Set("If-None-Match", "etag")
+	badConsistency := httptest.NewRequest(http.MethodGet, "/object/report", nil)
+	badConsistency.Header.Set(consistencyHeader, "sometimes")
+	requests := []*http.Request{
+		badContext,
+		badCondition,
+		badConsistency,
+		httptest.NewRequest(http.MethodGet, "/objects?limit=abc", nil),
+		httptest.NewRequest(http.MethodPut, "/object/report/uploads/1/parts/first", strings.NewReader("p")),
+	}
+	for _, r := range requests {
+		rec := serve(handler, r)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "%s %s", r.Method, r.URL)
+		assert.Equal(t, "InvalidArgument", decodeError(t, rec).Code)
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	handler := newTestHandler(t)
+	rec := serve(handler, httptest.NewRequest(http.MethodPost, "/object/video/uploads", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var created createUploadResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	uploadPath := "/object/video/uploads/" + url.PathEscape(created.UploadID)
+
+	var parts []client.Part
+	for i, body := range []string{"first-", "second"} {
+		rec := serve(handler, httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/parts/%d", uploadPath, i+1), strings.NewReader(body)))
+		require.Equal(t, http.StatusOK, rec.Code)
+		parts = append(parts, client.Part{PartNumber: i + 1, ETag: rec.Header().Get("Etag")})
+	}
+
+	rec = serve(handler, httptest.NewRequest(http.MethodGet, uploadPath, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var listed listPartsResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&listed))
+	assert.Equal(t, created.UploadID, listed.UploadID)
+	assert.Len(t, listed.Parts, 2)
+
+	complete, _ := json.Marshal(completeUploadRequest{Parts: parts})
+	rec = serve(handler, httptest.NewRequest(http.MethodPost, uploadPath, strings.NewReader(string(complete))))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get(contextHeader))
+
+	rec = serve(handler, httptest.NewRequest(http.MethodGet, "/object/video", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body, _ := io.ReadAll(rec.Body)
+	assert.Equal(t, "first-second", string(body))
+}
+
+func TestAbortMultipartUpload(t *testing.T) {
+	handler := newTestHandler(t)
+	rec := serve(handler, httptest.NewRequest(http.MethodPost, "/object/video/uploads", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var created createUploadResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	uploadPath := "/object/video/uploads/" + url.PathEscape(created.UploadID)
+
+	rec = serve(handler, httptest.NewRequest(http.MethodDelete, uploadPath, nil))
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = serve(handler, httptest.NewRequest(http.MethodGet, uploadPath, nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "NotFound", decodeError(t, rec).Code)
+}
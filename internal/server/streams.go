@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vrnvu/go-dynamolike/internal/client"
+)
+
+// defaultStreamWait long-polls unless the consumer asks otherwise.
+const defaultStreamWait = 20 * time.Second
+
+// handleReadStream returns the change records of a shard of a table's
+// stream. Consumers pass the next field of a page as the after parameter of
+// the following request.
+func (s *Server) handleReadStream(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	query := r.URL.Query()
+	opts := client.StreamOptions{Wait: defaultStreamWait}
+	var err error
+	if shard := query.Get("shard"); shard != "" {
+		if opts.Shard, err = strconv.Atoi(shard); err != nil {
+			writeError(w, requestID, fmt.Errorf("%w: shard %q", client.ErrInvalidArgument, shard))
+			return
+		}
+	}
+	if after := query.Get("after"); after != "" {
+		if opts.After, err = strconv.ParseUint(after, 10, 64); err != nil {
+			writeError(w, requestID, fmt.Errorf("%w: after %q", client.ErrInvalidArgument, after))
+			return
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if opts.Limit, err = strconv.Atoi(limit); err != nil || opts.Limit == 0 {
+			writeError(w, requestID, fmt.Errorf("%w: limit %q", client.ErrInvalidArgument, limit))
+			return
+		}
+	}
+	if wait := query.Get("wait"); wait != "" {
+		if opts.Wait, err = time.ParseDuration(wait); err != nil {
+			writeError(w, requestID, fmt.Errorf("%w: wait %q", client.ErrInvalidArgument, wait))
+			return
+		}
+	}
+
+	page, err := s.gateway.ReadStream(r.Context(), r.PathValue("table"), opts)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/vrnvu/go-dynamolike/internal/client"
+)
+
+type tablesResponse struct {
+	Tables []client.Table `json:"tables"`
+}
+
+func (s *Server) handleListTables(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	tables, err := s.gateway.Tables(r.Context())
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tablesResponse{Tables: tables})
+}
+
+func (s *Server) handleDescribeTable(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	table, err := s.gateway.DescribeTable(r.Context(), r.PathValue("table"))
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, table)
+}
+
+// handleCreateTable creates a table. The body optionally holds its
+// replication settings, the gateway defaults apply otherwise.
+func (s *Server) handleCreateTable(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	var opts client.TableOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, requestID, fmt.Errorf("%w: table settings: %w", client.ErrInvalidArgument, err))
+		return
+	}
+
+	table, err := s.gateway.CreateTable(r.Context(), r.PathValue("table"), opts)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, table)
+}
+
+func (s *Server) handleDeleteTable(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	if err := s.gateway.DeleteTable(r.Context(), r.PathValue("table")); err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCreateIndex creates an index with the key attributes in the body.
+func (s *Server) handleCreateIndex(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	var opts client.IndexOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		writeError(w, requestID, fmt.Errorf("%w: index settings: %w", client.ErrInvalidArgument, err))
+		return
+	}
+
+	index, err := s.gateway.CreateIndex(r.Context(), r.PathValue("table"), r.PathValue("index"), opts)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, index)
+}
+
+func (s *Server) handleDeleteIndex(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	if err := s.gateway.DeleteIndex(r.Context(), r.PathValue("table"), r.PathValue("index")); err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type timeToLiveRequest struct {
+	AttributeName string `json:"attribute_name"`
+}
+
+// handleSetTimeToLive sets the attribute holding the expiry of the items of
+// a table. An empty name stops new items from expiring.
+func (s *Server) handleSetTimeToLive(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	var req timeToLiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, requestID, fmt.Errorf("%w: time to live settings: %w", client.ErrInvalidArgument, err))
+		return
+	}
+
+	table, err := s.gateway.SetTimeToLive(r.Context(), r.PathValue("table"), req.AttributeName)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, table)
+}
+
+type streamRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// handleSetStream turns the change stream of a table on or off.
+func (s *Server) handleSetStream(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	var req streamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, requestID, fmt.Errorf("%w: stream settings: %w", client.ErrInvalidArgument, err))
+		return
+	}
+
+	table, err := s.gateway.SetStream(r.Context(), r.PathValue("table"), req.Enabled)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, table)
+}
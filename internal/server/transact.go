@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/vrnvu/go-dynamolike/internal/client"
+)
+
+// transactAction is an action of a transaction, an item request naming the
+// table it applies to.
+type transactAction struct {
+	TableName string `json:"table_name"`
+	itemRequest
+}
+
+// transactWriteItem holds exactly one action.
+type transactWriteItem struct {
+	Put            *transactAction `json:"put"`
+	Update         *transactAction `json:"update"`
+	Delete         *transactAction `json:"delete"`
+	ConditionCheck *transactAction `json:"condition_check"`
+}
+
+type transactWriteRequest struct {
+	TransactItems []transactWriteItem `json:"transact_items"`
+}
+
+func (i transactWriteItem) action() (string, *transactAction, error) {
+	var action string
+	var req *transactAction
+	for name, a := range map[string]*transactAction{
+		client.TransactPut:            i.Put,
+		client.TransactUpdate:         i.Update,
+		client.TransactDelete:         i.Delete,
+		client.TransactConditionCheck: i.ConditionCheck,
+	} {
+		if a == nil {
+			continue
+		}
+		if req != nil {
+			return "", nil, fmt.Errorf("%w: transact item with more than one action", client.ErrInvalidArgument)
+		}
+		action, req = name, a
+	}
+	if req == nil {
+		return "", nil, fmt.Errorf("%w: transact item without an action", client.ErrInvalidArgument)
+	}
+	return action, req, nil
+}
+
+// handleTransactWrite applies the actions of the request all together or
+// not at all. A cancelled transaction answers with a reason per item.
+func (s *Server) handleTransactWrite(w http.ResponseWriter, r *http.Request) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	var req transactWriteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, requestID, fmt.Errorf("%w: transact write request: %w", client.ErrInvalidArgument, err))
+		return
+	}
+	items := make([]client.TransactItem, 0, len(req.TransactItems))
+	for _, i := range req.TransactItems {
+		action, a, err := i.action()
+		if err != nil {
+			writeError(w, requestID, err)
+			return
+		}
+		items = append(items, client.TransactItem{
+			Action:    action,
+			Table:     a.TableName,
+			Item:      a.Item,
+			Key:       a.Key,
+			Update:    a.UpdateExpression,
+			Condition: a.ConditionExpression,
+			Params:    a.params(),
+		})
+	}
+	if err := s.gateway.TransactWriteItems(r.Context(), items); err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/vrnvu/go-dynamolike/internal/client"
+)
+
+type createUploadResponse struct {
+	UploadID string `json:"upload_id"`
+}
+
+type listPartsResponse struct {
+	UploadID string        `json:"upload_id"`
+	Parts    []client.Part `json:"parts"`
+}
+
+type completeUploadRequest struct {
+	Parts []client.Part `json:"parts"`
+}
+
+// handleCreateUpload starts a multipart upload of an object.
+func (s *Server) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	req, err := parseObjectRequest(w, r)
+	if err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+
+	uploadID, err := s.gateway.CreateMultipartUpload(r.Context(), req.objectID, client.PutOptions{Table: req.table})
+	if err != nil {
+		writeError(w, req.requestID, err, slog.String("object_id", req.objectID))
+		return
+	}
+	writeJSON(w, http.StatusOK, createUploadResponse{UploadID: uploadID})
+}
+
+// handleUploadPart uploads the body as a part of a multipart upload. The
+// request must carry a Content-Length.
+func (s *Server) handleUploadPart(w http.ResponseWriter, r *http.Request) {
+	req, err := parseObjectRequest(w, r)
+	if err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+	partNumber, err := strconv.Atoi(r.PathValue("part"))
+	if err != nil {
+		writeError(w, req.requestID, fmt.Errorf("%w: part number %q", client.ErrInvalidArgument, r.PathValue("part")))
+		return
+	}
+
+	part, err := s.gateway.UploadPart(r.Context(), req.objectID, r.PathValue("upload"), partNumber, r.Body, r.ContentLength,
+		client.PutOptions{Table: req.table})
+	if err != nil {
+		writeError(w, req.requestID, err, slog.String("object_id", req.objectID))
+		return
+	}
+	w.Header().Set("Etag", part.ETag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleListParts answers with the parts uploaded so far.
+func (s *Server) handleListParts(w http.ResponseWriter, r *http.Request) {
+	req, err := parseObjectRequest(w, r)
+	if err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+
+	uploadID := r.PathValue("upload")
+	parts, err := s.gateway.ListParts(r.Context(), req.objectID, uploadID, client.PutOptions{Table: req.table})
+	if err != nil {
+		writeError(w, req.requestID, err, slog.String("object_id", req.objectID))
+		return
+	}
+	if parts == nil {
+		parts = []client.Part{}
+	}
+	writeJSON(w, http.StatusOK, listPartsResponse{UploadID: uploadID, Parts: parts})
+}
+
+// handleCompleteUpload assembles the parts listed in the body into the
+// object. It takes the same headers as a put.
+func (s *Server) handleCompleteUpload(w http.ResponseWriter, r *http.Request) {
+	req, err := parseObjectRequest(w, r)
+	if err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+	clock, err := parseContext(r)
+	if err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+	var body completeUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, req.requestID, fmt.Errorf("%w: complete upload request: %w", client.ErrInvalidArgument, err))
+		return
+	}
+
+	opts := client.PutOptions{
+		Consistency:  req.consistency,
+		Table:        req.table,
+		Context:      clock,
+		UserMetadata: userMetadata(r),
+	}
+	if err := parseConditions(r, &opts); err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+	if opts.ExpiresAt, err = parseExpiresAt(r); err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+
+	result, err := s.gateway.CompleteMultipartUpload(r.Context(), req.objectID, r.PathValue("upload"), body.Parts, opts)
+	if err != nil {
+		writeError(w, req.requestID, err, slog.String("object_id", req.objectID))
+		return
+	}
+
+	w.Header().Set("Etag", result.ETag)
+	w.Header().Set(contextHeader, result.Clock.Encode())
+	w.Header().Set(versionHeader, result.Clock.Version())
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Key: %s, Bucket: %s, Location: %s", result.Key, result.Bucket, result.Location)
+}
+
+// handleAbortUpload removes a multipart upload and the parts uploaded.
+func (s *Server) handleAbortUpload(w http.ResponseWriter, r *http.Request) {
+	req, err := parseObjectRequest(w, r)
+	if err != nil {
+		writeError(w, req.requestID, err)
+		return
+	}
+
+	if err := s.gateway.AbortMultipartUpload(r.Context(), req.objectID, r.PathValue("upload"), client.PutOptions{Table: req.table}); err != nil {
+		writeError(w, req.requestID, err, slog.String("object_id", req.objectID))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
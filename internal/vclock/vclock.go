@@ -0,0 +1,141 @@
+package vclock
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dgryski/go-farm"
+)
+
+// Clock is a vector clock mapping a coordinator ID to the number of writes it
+// has coordinated for an object.
+type Clock map[string]uint64
+
+type Ordering int
+
+const (
+	Equal Ordering = iota
+	Before
+	After
+	Concurrent
+)
+
+func (o Ordering) String() string {
+	switch o {
+	case Equal:
+		return "equal"
+	case Before:
+		return "before"
+	case After:
+		return "after"
+	default:
+		return "concurrent"
+	}
+}
+
+// Increment returns a copy of c with the counter of id advanced by one.
+func (c Clock) Increment(id string) Clock {
+	next := c.copy()
+	next[id]++
+	return next
+}
+
+// Merge returns the pointwise maximum of c and other.
+func (c Clock) Merge(other Clock) Clock {
+	merged := c.copy()
+	for id, counter := range other {
+		if counter > merged[id] {
+			merged[id] = counter
+		}
+	}
+	return merged
+}
+
+// Compare reports whether c happened before, after, at the same time as or
+// concurrently with other.
+func (c Clock) Compare(other Clock) Ordering {
+	less, greater := false, false
+	for id, counter := range c {
+		if counter > other[id] {
+			greater = true
+		}
+	}
+	for id, counter := range other {
+		if counter > c[id] {
+			less = true
+		}
+	}
+	switch {
+	case less && greater:
+		return Concurrent
+	case less:
+		return Before
+	case greater:
+		return After
+	default:
+		return Equal
+	}
+}
+
+// Descends reports whether c contains every write recorded in other.
+func (c Clock) Descends(other Clock) bool {
+	ordering := c.Compare(other)
+	return ordering == After || ordering == Equal
+}
+
+// Encode returns the canonical, header-safe representation of c. It is used
+// both as object metadata and as the context token handed to clients.
+func (c Clock) Encode() string {
+	ids := make([]string, 0, len(c))
+	for id := range c {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	entries := make([]string, 0, len(ids))
+	for _, id := range ids {
+		entries = append(entries, id+"="+strconv.FormatUint(c[id], 10))
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(entries, ",")))
+}
+
+// Version returns a short identifier for the write described by c.
+func (c Clock) Version() string {
+	return fmt.Sprintf("%016x", farm.Fingerprint64([]byte(c.Encode())))
+}
+
+// Decode parses a clock produced by Encode. An empty string is an empty clock.
+func Decode(s string) (Clock, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vector clock encoding: %w", err)
+	}
+
+	c := Clock{}
+	if len(raw) == 0 {
+		return c, nil
+	}
+	for _, entry := range strings.Split(string(raw), ",") {
+		id, counter, ok := strings.Cut(entry, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid vector clock entry %q", entry)
+		}
+		n, err := strconv.ParseUint(counter, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vector clock counter %q: %w", entry, err)
+		}
+		c[id] = n
+	}
+	return c, nil
+}
+
+func (c Clock) copy() Clock {
+	cp := make(Clock, len(c)+1)
+	for id, counter := range c {
+		cp[id] = counter
+	}
+	return cp
+}
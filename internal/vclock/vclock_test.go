@@ -0,0 +1,54 @@
+package vclock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	a := Clock{}.Increment("a")
+	ab := a.Increment("b")
+	ac := a.Increment("c")
+
+	assert.Equal(t, Equal, a.Compare(Clock{"a": 1}))
+	assert.Equal(t, Before, a.Compare(ab))
+	assert.Equal(t, After, ab.Compare(a))
+	assert.Equal(t, Concurrent, ab.Compare(ac))
+	assert.Equal(t, Before, Clock{}.Compare(a))
+}
+
+func TestMergeDescendsBothSides(t *testing.T) {
+	ab := Clock{"a": 2, "b": 1}
+	ac := Clock{"a": 1, "c": 3}
+
+	merged := ab.Merge(ac)
+
+	assert.Equal(t, Clock{"a": 2, "b": 1, "c": 3}, merged)
+	assert.True(t, merged.Descends(ab))
+	assert.True(t, merged.Descends(ac))
+	assert.Equal(t, Clock{"a": 2, "b": 1}, ab, "merge must not modify its receiver")
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	c := Clock{"gateway-2": 7, "gateway-1": 3}
+
+	decoded, err := Decode(c.Encode())
+
+	assert.NoError(t, err)
+	assert.Equal(t, c, decoded)
+	assert.Equal(t, c.Encode(), Clock{"gateway-1": 3, "gateway-2": 7}.Encode())
+	assert.Equal(t, c.Version(), decoded.Version())
+}
+
+func TestDecodeEmptyAndInvalid(t *testing.T) {
+	c, err := Decode("")
+	assert.NoError(t, err)
+	assert.Empty(t, c)
+
+	_, err = Decode("not base64!")
+	assert.Error(t, err)
+
+	_, err = Decode(Clock{}.Encode() + "YT14")
+	assert.Error(t, err)
+}
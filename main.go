@@ -14,12 +14,13 @@ import (
 
 	"github.com/docker/docker/client"
 	"github.com/vrnvu/go-dynamolike/internal/discovery"
+	"github.com/vrnvu/go-dynamolike/internal/partition"
 	"github.com/vrnvu/go-dynamolike/internal/server"
 )
 
 const shortUsage = `Usage of go-dynamolike:
 
-	$ go-dynamolike --port <port> --network <network-name>
+	$ go-dynamolike --port <port> --network <network-name> [options]
 
 Flags:
 	--network <network-name>  (REQUIRED)
@@ -27,6 +28,26 @@ Flags:
 		This flag determines which network the program will scan to find MinIO instances.
 	--port <port>  (REQUIRED)
 		Specify the port to use for the HTTP server.
+	--replication-factor <n>  (default: 1)
+		Number of distinct MinIO nodes each object is written to.
+	--virtual-nodes <n>  (default: 128)
+		Number of tokens each MinIO node owns on the consistent hash ring.
+	--read-quorum <r>, --write-quorum <w>  (default: majority of the replication factor)
+		Number of replicas a read waits for and a write must be acknowledged by.
+		Requests can override them with the X-Consistency: one|quorum|all header.
+	--sloppy-quorum  (default: false)
+		Write to the next healthy node on the ring, with a hint naming the
+		intended owner, when a replica is down.
+	--hint-replay-interval <duration>  (default: 10s)
+		How often hints are delivered back to owners that became healthy again.
+	--anti-entropy-interval <duration>  (default: 1m)
+		How often replicas compare Merkle trees and copy over the objects that differ.
+		The outcome of the last run is served on /admin/anti-entropy.
+		Tombstones, expired objects and dropped tables are collected as often.
+	--tombstone-grace <duration>  (default: 24h)
+		How long a deleted object keeps its tombstone before it is garbage collected.
+		A replica that stays unreachable for longer than this may bring the object back.
+		Must be longer than the hint replay and anti-entropy intervals.
 
 Example:
 	$ go-dynamolike --port 3000 --network dynamolike-network
@@ -49,8 +70,16 @@ func main() {
 	}
 	log.SetFlags(0)
 	var (
-		portFlag    = flag.Int("port", 0, "HTTP server port")
-		networkFlag = flag.String("network", "", "Docker network name")
+		portFlag              = flag.Int("port", 0, "HTTP server port")
+		networkFlag           = flag.String("network", "", "Docker network name")
+		replicationFactorFlag = flag.Int("replication-factor", 1, "Number of replicas per object")
+		virtualNodesFlag      = flag.Int("virtual-nodes", partition.DefaultVirtualNodes, "Tokens per node on the hash ring")
+		readQuorumFlag        = flag.Int("read-quorum", 0, "Replicas a read waits for")
+		writeQuorumFlag       = flag.Int("write-quorum", 0, "Replicas a write waits for")
+		sloppyQuorumFlag      = flag.Bool("sloppy-quorum", false, "Store hints on fallback nodes for unavailable replicas")
+		hintReplayFlag        = flag.Duration("hint-replay-interval", 10*time.Second, "Interval between hint replays")
+		antiEntropyFlag       = flag.Duration("anti-entropy-interval", time.Minute, "Interval between anti-entropy runs")
+		tombstoneGraceFlag    = flag.Duration("tombstone-grace", 24*time.Hour, "Age after which tombstones are garbage collected")
 	)
 	flag.Usage = func() {
 		fmt.Fprint(flag.CommandLine.Output(), shortUsage)
@@ -66,11 +95,56 @@ func main() {
 		flag.Usage()
 		return
 	}
-	run(*portFlag, *networkFlag)
+	if *replicationFactorFlag < 1 {
+		slog.Error("Invalid replication factor", slog.Int("replication_factor", *replicationFactorFlag))
+		flag.Usage()
+		return
+	}
+	if *virtualNodesFlag < 1 {
+		slog.Error("Invalid virtual node count", slog.Int("virtual_nodes", *virtualNodesFlag))
+		flag.Usage()
+		return
+	}
+	if *readQuorumFlag < 0 || *readQuorumFlag > *replicationFactorFlag || *writeQuorumFlag < 0 || *writeQuorumFlag > *replicationFactorFlag {
+		slog.Error("Invalid quorum",
+			slog.Int("read_quorum", *readQuorumFlag),
+			slog.Int("write_quorum", *writeQuorumFlag),
+			slog.Int("replication_factor", *replicationFactorFlag))
+		flag.Usage()
+		return
+	}
+	if *hintReplayFlag <= 0 || *antiEntropyFlag <= 0 {
+		slog.Error("Invalid background interval",
+			slog.Duration("hint_replay_interval", *hintReplayFlag),
+			slog.Duration("anti_entropy_interval", *antiEntropyFlag))
+		flag.Usage()
+		return
+	}
+	// A tombstone must outlive the runs that spread it to every replica.
+	if *tombstoneGraceFlag <= max(*hintReplayFlag, *antiEntropyFlag) {
+		slog.Error("Tombstone grace period must be longer than the background intervals",
+			slog.Duration("tombstone_grace", *tombstoneGraceFlag),
+			slog.Duration("hint_replay_interval", *hintReplayFlag),
+			slog.Duration("anti_entropy_interval", *antiEntropyFlag))
+		flag.Usage()
+		return
+	}
+	run(server.Config{
+		Port:                *portFlag,
+		ReplicationFactor:   *replicationFactorFlag,
+		ReadQuorum:          *readQuorumFlag,
+		WriteQuorum:         *writeQuorumFlag,
+		SloppyQuorum:        *sloppyQuorumFlag,
+		HintReplayInterval:  *hintReplayFlag,
+		AntiEntropyInterval: *antiEntropyFlag,
+		VirtualNodes:        *virtualNodesFlag,
+		TombstoneGrace:      *tombstoneGraceFlag,
+	}, *networkFlag)
 }
 
-func run(port int, network string) {
-	// TODO we are going to sleep for the first version so the partition are fixed
+func run(config server.Config, network string) {
+	// Give the MinIO containers a head start, later membership changes are
+	// picked up by the gateway as discovery reports them.
 	time.Sleep(3 * time.Second)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -107,9 +181,10 @@ func run(port int, network string) {
 	}()
 
 	// Start the server
-	server := server.NewServer(port, registry)
+	server := server.NewServer(config, registry)
+	server.RunBackground(ctx)
 
-	slog.Info("Server is running", slog.Int("port", port))
+	slog.Info("Server is running", slog.Int("port", config.Port))
 	go func() {
 		err := server.Server.ListenAndServe()
 		if err != nil && err != http.ErrServerClosed {